@@ -0,0 +1,167 @@
+// Package audit keeps the append-only log of access to patient records.
+//
+// The entries of each hospital form a hash chain: every entry stores the
+// hash of the one before it and its own hash over both, and the hospital's
+// AuditChain row holds the hash of the last entry. Changing an entry breaks
+// its hash, removing or reordering entries breaks the links or the sequence
+// numbers, and removing entries at the end no longer matches the head.
+// Verify walks a chain and reports the first entry that does not match.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// verifyBatchSize is the number of entries Verify loads at a time.
+const verifyBatchSize = 500
+
+// Record appends entry to its hospital's chain, setting its sequence
+// number, time and hashes. It joins the transaction of db if there is one,
+// so a change and its entry are committed together. The chain head stays
+// locked until the transaction ends, so entries of one hospital are
+// appended one at a time.
+func Record(db *gorm.DB, entry *models.AuditEntry) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		head := models.AuditChain{HospitalID: entry.HospitalID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&head).Error; err != nil {
+			return err
+		}
+
+		current := tx.Model(&models.AuditChain{}).Where("hospital_id = ?", entry.HospitalID)
+		if err := current.Update("sequence", gorm.Expr("sequence + 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("hospital_id = ?", entry.HospitalID).First(&head).Error; err != nil {
+			return err
+		}
+
+		entry.ID = 0
+		entry.Sequence = head.Sequence
+		// Databases keep microseconds at most, and the hash must match the
+		// time read back.
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.PrevHash = head.Hash
+		entry.Hash = Hash(*entry)
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.AuditChain{}).
+			Where("hospital_id = ?", entry.HospitalID).
+			Update("hash", entry.Hash).Error; err != nil {
+			return err
+		}
+
+		if len(entry.PatientIDs) == 0 {
+			return nil
+		}
+		patients := make([]models.AuditEntryPatient, 0, len(entry.PatientIDs))
+		seen := map[uint]bool{}
+		for _, patientID := range entry.PatientIDs {
+			if seen[patientID] {
+				continue
+			}
+			seen[patientID] = true
+			patients = append(patients, models.AuditEntryPatient{AuditEntryID: entry.ID, PatientID: patientID})
+		}
+		return tx.Create(&patients).Error
+	})
+}
+
+// Hash returns the hash of entry: SHA-256 over the hash of the entry before
+// it and the JSON encoding of every recorded field.
+func Hash(entry models.AuditEntry) string {
+	content, _ := json.Marshal(struct {
+		HospitalID uint              `json:"hospital_id"`
+		Sequence   uint64            `json:"sequence"`
+		CreatedAt  string            `json:"created_at"`
+		Actor      string            `json:"actor"`
+		StaffID    *uint             `json:"staff_id"`
+		Action     string            `json:"action"`
+		PatientIDs []uint            `json:"patient_ids"`
+		Filters    map[string]string `json:"filters"`
+		Reason     string            `json:"reason"`
+		ClientIP   string            `json:"client_ip"`
+	}{
+		HospitalID: entry.HospitalID,
+		Sequence:   entry.Sequence,
+		CreatedAt:  entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Actor:      entry.Actor,
+		StaffID:    entry.StaffID,
+		Action:     entry.Action,
+		PatientIDs: entry.PatientIDs,
+		Filters:    entry.Filters,
+		Reason:     entry.Reason,
+		ClientIP:   entry.ClientIP,
+	})
+
+	hash := sha256.New()
+	hash.Write([]byte(entry.PrevHash))
+	hash.Write(content)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Verify checks the chain of a hospital from its first entry to its head.
+// db must be able to read the hospital's entries.
+func Verify(db *gorm.DB, hospitalID uint) (models.AuditVerification, error) {
+	var result models.AuditVerification
+	broken := func(sequence uint64, problem string) (models.AuditVerification, error) {
+		result.Valid = false
+		result.BrokenAt = &sequence
+		result.Problem = problem
+		return result, nil
+	}
+
+	var head models.AuditChain
+	if err := db.Where("hospital_id = ?", hospitalID).Limit(1).Find(&head).Error; err != nil {
+		return result, err
+	}
+	result.HeadHash = head.Hash
+
+	previous := ""
+	var sequence uint64
+	for {
+		var entries []models.AuditEntry
+		if err := db.Where("hospital_id = ? AND sequence > ?", hospitalID, sequence).
+			Order("sequence").
+			Limit(verifyBatchSize).
+			Find(&entries).Error; err != nil {
+			return result, err
+		}
+
+		for _, entry := range entries {
+			sequence++
+			if entry.Sequence != sequence {
+				return broken(sequence, fmt.Sprintf("entry %d is missing", sequence))
+			}
+			if entry.PrevHash != previous {
+				return broken(sequence, "entry does not link to the entry before it")
+			}
+			if Hash(entry) != entry.Hash {
+				return broken(sequence, "entry does not match its hash")
+			}
+			previous = entry.Hash
+			result.Entries++
+		}
+		if len(entries) < verifyBatchSize {
+			break
+		}
+	}
+
+	if head.Sequence > sequence {
+		return broken(sequence+1, fmt.Sprintf("entry %d is missing", sequence+1))
+	}
+	if head.Sequence != sequence || head.Hash != previous {
+		return broken(sequence, "chain head does not match the last entry")
+	}
+	result.Valid = true
+	return result, nil
+}
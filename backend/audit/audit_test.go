@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	db.AutoMigrate(&models.AuditEntry{}, &models.AuditEntryPatient{}, &models.AuditChain{})
+	return db
+}
+
+// recordEntries appends n entries to the chain of hospital 1 and one to
+// hospital 2.
+func recordEntries(t *testing.T, db *gorm.DB, n int) {
+	staffID := uint(7)
+	for i := 0; i < n; i++ {
+		entry := models.AuditEntry{
+			HospitalID: 1,
+			Actor:      models.StaffActor(staffID),
+			StaffID:    &staffID,
+			Action:     models.AuditPatientSearch,
+			PatientIDs: []uint{uint(i + 1), uint(i + 2), uint(i + 1)},
+			Filters:    map[string]string{"last_name": "Jaidee"},
+			ClientIP:   "10.0.0.1",
+		}
+		assert.NoError(t, Record(db, &entry))
+		assert.Equal(t, uint64(i+1), entry.Sequence)
+	}
+	assert.NoError(t, Record(db, &models.AuditEntry{HospitalID: 2, Actor: "api_key:1", Action: models.AuditPatientCreate}))
+}
+
+func TestRecord(t *testing.T) {
+	db := setupTestDB(t)
+	recordEntries(t, db, 3)
+
+	var entries []models.AuditEntry
+	db.Where("hospital_id = ?", 1).Order("sequence").Find(&entries)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "", entries[0].PrevHash)
+	assert.Equal(t, entries[0].Hash, entries[1].PrevHash)
+	assert.Equal(t, entries[1].Hash, entries[2].PrevHash)
+	assert.Equal(t, []uint{2, 3, 2}, entries[1].PatientIDs)
+	assert.Equal(t, "Jaidee", entries[1].Filters["last_name"])
+
+	// Entries are found by each patient once
+	var count int64
+	db.Model(&models.AuditEntryPatient{}).Where("patient_id = ?", 2).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	// Each hospital has its own chain
+	var other models.AuditEntry
+	db.Where("hospital_id = ?", 2).First(&other)
+	assert.Equal(t, uint64(1), other.Sequence)
+	assert.Equal(t, "", other.PrevHash)
+
+	var head models.AuditChain
+	db.First(&head, 1)
+	assert.Equal(t, uint64(3), head.Sequence)
+	assert.Equal(t, entries[2].Hash, head.Hash)
+
+	// Entries recorded in a transaction that rolls back leave no trace
+	db.Transaction(func(tx *gorm.DB) error {
+		assert.NoError(t, Record(tx, &models.AuditEntry{HospitalID: 1, Action: models.AuditPatientUpdate}))
+		return gorm.ErrInvalidTransaction
+	})
+	db.First(&head, 1)
+	assert.Equal(t, uint64(3), head.Sequence)
+}
+
+func TestVerify(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		db := setupTestDB(t)
+		recordEntries(t, db, 3)
+
+		result, err := Verify(db, 1)
+		assert.NoError(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, uint64(3), result.Entries)
+		assert.Nil(t, result.BrokenAt)
+
+		// A hospital without entries has a valid, empty chain
+		result, err = Verify(db, 3)
+		assert.NoError(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, uint64(0), result.Entries)
+	})
+
+	tests := []struct {
+		name     string
+		tamper   string
+		brokenAt uint64
+	}{
+		{"Changed Entry", "UPDATE audit_entries SET patient_ids = '[9]' WHERE hospital_id = 1 AND sequence = 2", 2},
+		{"Removed Entry", "DELETE FROM audit_entries WHERE hospital_id = 1 AND sequence = 2", 2},
+		{"Removed Last Entry", "DELETE FROM audit_entries WHERE hospital_id = 1 AND sequence = 3", 3},
+		{"Rewritten Hash", "UPDATE audit_entries SET hash = 'x' WHERE hospital_id = 1 AND sequence = 1", 1},
+		{"Rewound Head", "UPDATE audit_chains SET sequence = 2 WHERE hospital_id = 1", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			recordEntries(t, db, 3)
+			assert.NoError(t, db.Exec(tt.tamper).Error)
+
+			result, err := Verify(db, 1)
+			assert.NoError(t, err)
+			assert.False(t, result.Valid)
+			if assert.NotNil(t, result.BrokenAt) {
+				assert.Equal(t, tt.brokenAt, *result.BrokenAt)
+			}
+			assert.NotEmpty(t, result.Problem)
+
+			// Other hospitals' chains are unaffected
+			result, _ = Verify(db, 2)
+			assert.True(t, result.Valid)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"time"
+)
+
+var ErrInvalidClientCertificate = errors.New("invalid client certificate")
+
+// CertificateIdentity returns what a client certificate is mapped by: the
+// distinguished names of its issuer and its subject, in RFC 2253 form. Both
+// must match exactly, so a certificate from another CA with the same
+// subject is a different identity.
+func CertificateIdentity(cert *x509.Certificate) (issuer string, subject string) {
+	return cert.Issuer.String(), cert.Subject.String()
+}
+
+// ParseForwardedCertificate decodes a client certificate forwarded by a TLS
+// terminating proxy as a URL-escaped PEM block, as in nginx's
+// $ssl_client_escaped_cert.
+func ParseForwardedCertificate(header string) (*x509.Certificate, error) {
+	decoded, err := url.QueryUnescape(header)
+	if err != nil {
+		return nil, ErrInvalidClientCertificate
+	}
+	return ParseCertificatePEM(decoded)
+}
+
+// ParseCertificatePEM decodes a certificate in a PEM block.
+func ParseCertificatePEM(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, ErrInvalidClientCertificate
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidClientCertificate
+	}
+	return cert, nil
+}
+
+// VerifyClientCertificate checks that a certificate was issued for client
+// authentication by one of the CAs in roots.
+func VerifyClientCertificate(cert *x509.Certificate, roots *x509.CertPool, now time.Time) error {
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return ErrInvalidClientCertificate
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func testCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	return testCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+}
+
+func TestClientCertificate(t *testing.T) {
+	ca, caKey := testCA(t, "Hospital CA")
+	labURI, _ := url.Parse("spiffe://hospital-a/lab")
+	client, _ := testCertificate(t, &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "lab-system", Organization: []string{"Hospital A"}},
+		DNSNames:       []string{"lab.hospital-a.internal"},
+		URIs:           []*url.URL{labURI},
+		EmailAddresses: []string{"lab@hospital-a.example"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	issuer, subject := CertificateIdentity(client)
+	assert.Equal(t, "CN=Hospital CA", issuer)
+	assert.Equal(t, "CN=lab-system,O=Hospital A", subject)
+
+	// Forwarded the way nginx's $ssl_client_escaped_cert does
+	header := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: client.Raw})))
+	forwarded, err := ParseForwardedCertificate(header)
+	assert.NoError(t, err)
+	assert.Equal(t, client.Raw, forwarded.Raw)
+
+	// Uploaded as plain PEM, where '+' is not a space
+	uploaded, err := ParseCertificatePEM(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: client.Raw})))
+	assert.NoError(t, err)
+	assert.Equal(t, client.Raw, uploaded.Raw)
+
+	_, err = ParseForwardedCertificate("not-a-certificate")
+	assert.ErrorIs(t, err, ErrInvalidClientCertificate)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	assert.NoError(t, VerifyClientCertificate(client, roots, time.Now()))
+	assert.ErrorIs(t, VerifyClientCertificate(client, roots, time.Now().Add(2*time.Hour)), ErrInvalidClientCertificate)
+
+	otherCA, _ := testCA(t, "Other CA")
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCA)
+	assert.ErrorIs(t, VerifyClientCertificate(client, otherRoots, time.Now()), ErrInvalidClientCertificate)
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+// JWK is a public JSON Web Key (RFC 7517) for RS256 or EdDSA.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK describes a public key as a JWK.
+func NewJWK(keyID string, algorithm string, publicKey interface{}) (JWK, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: algorithm,
+			KeyID:     keyID,
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			Use:       "sig",
+			Algorithm: algorithm,
+			KeyID:     keyID,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	}
+	return JWK{}, errors.New("unsupported public key type")
+}
+
+// VerificationKey converts the JWK into a key usable by ParseJWT.
+func (k JWK) VerificationKey() (VerificationKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return VerificationKey{}, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return VerificationKey{}, err
+		}
+		publicKey := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return VerificationKey{Algorithm: AlgRS256, Key: publicKey}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return VerificationKey{}, errors.New("unsupported curve " + k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return VerificationKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return VerificationKey{}, errors.New("invalid Ed25519 key")
+		}
+		return VerificationKey{Algorithm: AlgEdDSA, Key: ed25519.PublicKey(x)}, nil
+	}
+	return VerificationKey{}, errors.New("unsupported key type " + k.KeyType)
+}
+
+// Key returns the key with the given ID. An empty ID matches the only key of
+// a single-key set.
+func (s JWKSet) Key(keyID string) (JWK, bool) {
+	if keyID == "" && len(s.Keys) == 1 {
+		return s.Keys[0], true
+	}
+	for _, key := range s.Keys {
+		if key.KeyID == keyID {
+			return key, true
+		}
+	}
+	return JWK{}, false
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidJWT = errors.New("invalid token")
+	ErrExpiredJWT = errors.New("token expired")
+)
+
+type JWTHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// VerificationKey checks signatures of a single algorithm. Key is a []byte
+// secret for HS256, *rsa.PublicKey for RS256 or ed25519.PublicKey for EdDSA.
+type VerificationKey struct {
+	Algorithm string
+	Key       interface{}
+}
+
+// SignJWT encodes claims as a compact JWS. key is a []byte secret, an
+// *rsa.PrivateKey or an ed25519.PrivateKey, matching header.Algorithm.
+func SignJWT(header JWTHeader, claims interface{}, key interface{}) (string, error) {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	var signature []byte
+	switch header.Algorithm {
+	case AlgHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", errors.New("HS256 requires a secret key")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case AlgRS256:
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", errors.New("RS256 requires an RSA private key")
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case AlgEdDSA:
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return "", errors.New("EdDSA requires an Ed25519 private key")
+		}
+		signature = ed25519.Sign(privateKey, []byte(signingInput))
+	default:
+		return "", errors.New("unsupported JWT algorithm " + header.Algorithm)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ParseJWT verifies the signature of a compact JWS with the key returned by
+// keyFunc and decodes its payload into claims. The header algorithm must match
+// the key's algorithm. Time-based claims are left to the caller.
+func ParseJWT(token string, keyFunc func(header JWTHeader) (VerificationKey, error), claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidJWT
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidJWT
+	}
+	var header JWTHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return ErrInvalidJWT
+	}
+
+	key, err := keyFunc(header)
+	if err != nil {
+		return ErrInvalidJWT
+	}
+	if header.Algorithm != key.Algorithm {
+		return ErrInvalidJWT
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidJWT
+	}
+	if !verifySignature(key, parts[0]+"."+parts[1], signature) {
+		return ErrInvalidJWT
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidJWT
+	}
+	if err := json.Unmarshal(claimsJSON, claims); err != nil {
+		return ErrInvalidJWT
+	}
+	return nil
+}
+
+func verifySignature(key VerificationKey, signingInput string, signature []byte) bool {
+	switch key.Algorithm {
+	case AlgHS256:
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		return hmac.Equal(signature, mac.Sum(nil))
+	case AlgRS256:
+		publicKey, ok := key.Key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) == nil
+	case AlgEdDSA:
+		publicKey, ok := key.Key.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(publicKey, []byte(signingInput), signature)
+	}
+	return false
+}
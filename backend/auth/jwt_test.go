@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testClaims(expiresAt time.Time) StaffClaims {
+	return StaffClaims{
+		ID:          "jti-1",
+		SessionID:   7,
+		StaffID:     3,
+		HospitalID:  1,
+		Roles:       []string{"nurse"},
+		Permissions: []string{"patient:read"},
+		IssuedAt:    time.Now().Unix(),
+		ExpiresAt:   expiresAt.Unix(),
+	}
+}
+
+func TestKeySetSignAndVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := map[string]interface{}{
+		AlgHS256: []byte("test-secret"),
+		AlgRS256: rsaKey,
+		AlgEdDSA: edKey,
+	}
+
+	for algorithm, material := range keys {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := NewSigningKey("k1", algorithm, material)
+			assert.NoError(t, err)
+			keySet, _ := NewKeySet(key)
+
+			token, err := keySet.Sign(testClaims(time.Now().Add(time.Minute)))
+			assert.NoError(t, err)
+
+			claims, err := keySet.Verify(token, time.Now())
+			assert.NoError(t, err)
+			assert.Equal(t, uint(3), claims.StaffID)
+			assert.Equal(t, uint(1), claims.HospitalID)
+			assert.Equal(t, []string{"nurse"}, claims.Roles)
+
+			// Tampered payload
+			parts := strings.Split(token, ".")
+			forged := parts[0] + "." + parts[1] + "x." + parts[2]
+			_, err = keySet.Verify(forged, time.Now())
+			assert.ErrorIs(t, err, ErrInvalidJWT)
+
+			// Expired
+			_, err = keySet.Verify(token, time.Now().Add(2*time.Minute))
+			assert.ErrorIs(t, err, ErrExpiredJWT)
+		})
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, _ := NewSigningKey("old", AlgHS256, []byte("old-secret"))
+	newKey, _ := NewSigningKey("new", AlgHS256, []byte("new-secret"))
+
+	oldSet, _ := NewKeySet(oldKey)
+	rotatedSet, _ := NewKeySet(newKey, oldKey)
+	newOnlySet, _ := NewKeySet(newKey)
+
+	oldToken, _ := oldSet.Sign(testClaims(time.Now().Add(time.Minute)))
+
+	// Tokens signed with the previous key stay valid during rotation
+	_, err := rotatedSet.Verify(oldToken, time.Now())
+	assert.NoError(t, err)
+
+	// ...and are rejected once the old key is retired
+	_, err = newOnlySet.Verify(oldToken, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+}
+
+func TestParseJWTRejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	signingKey, _ := NewSigningKey("k1", AlgRS256, rsaKey)
+	keySet, _ := NewKeySet(signingKey)
+
+	// HS256 token keyed with the public key's bytes must not verify
+	jwk, _ := NewJWK("k1", AlgRS256, &rsaKey.PublicKey)
+	forged, _ := SignJWT(JWTHeader{Algorithm: AlgHS256, KeyID: "k1"}, testClaims(time.Now().Add(time.Minute)), []byte(jwk.N))
+
+	_, err := keySet.Verify(forged, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	rsaSigningKey, _ := NewSigningKey("rsa", AlgRS256, rsaKey)
+	edSigningKey, _ := NewSigningKey("ed", AlgEdDSA, edKey)
+	secretKey, _ := NewSigningKey("hs", AlgHS256, []byte("secret"))
+	keySet, _ := NewKeySet(edSigningKey, rsaSigningKey, secretKey)
+
+	jwks := keySet.JWKS()
+	assert.Equal(t, 2, len(jwks.Keys))
+
+	// A verifier holding only the JWKS can check tokens
+	token, _ := keySet.Sign(testClaims(time.Now().Add(time.Minute)))
+	var claims StaffClaims
+	err := ParseJWT(token, func(header JWTHeader) (VerificationKey, error) {
+		jwk, found := jwks.Key(header.KeyID)
+		if !found {
+			return VerificationKey{}, ErrInvalidJWT
+		}
+		return jwk.VerificationKey()
+	}, &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "jti-1", claims.ID)
+}
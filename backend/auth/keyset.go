@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// StaffClaims are the claims of the access tokens issued in JWT mode.
+// SessionID is the ID of the models.Token row the access token belongs to.
+type StaffClaims struct {
+	ID          string   `json:"jti"`
+	SessionID   uint     `json:"sid"`
+	StaffID     uint     `json:"staff_id"`
+	HospitalID  uint     `json:"hospital_id"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+
+	// PasswordChangeRequired and MFAEnrollmentRequired limit the token to
+	// changing the password and enrolling MFA respectively.
+	PasswordChangeRequired bool `json:"pcr,omitempty"`
+	MFAEnrollmentRequired  bool `json:"mer,omitempty"`
+}
+
+// SigningKey is a private key (or HS256 secret) identified by a kid.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	key       interface{}
+}
+
+// NewSigningKey wraps a []byte secret (HS256), an *rsa.PrivateKey (RS256) or
+// an ed25519.PrivateKey (EdDSA).
+func NewSigningKey(keyID string, algorithm string, key interface{}) (SigningKey, error) {
+	valid := false
+	switch algorithm {
+	case AlgHS256:
+		secret, ok := key.([]byte)
+		valid = ok && len(secret) > 0
+	case AlgRS256:
+		_, valid = key.(*rsa.PrivateKey)
+	case AlgEdDSA:
+		_, valid = key.(ed25519.PrivateKey)
+	default:
+		return SigningKey{}, errors.New("unsupported JWT algorithm " + algorithm)
+	}
+	if !valid {
+		return SigningKey{}, errors.New("key " + keyID + " does not match algorithm " + algorithm)
+	}
+
+	return SigningKey{ID: keyID, Algorithm: algorithm, key: key}, nil
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8 or PKCS#1 PEM encoded private key.
+func ParsePrivateKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func (k SigningKey) verificationKey() VerificationKey {
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		return VerificationKey{Algorithm: k.Algorithm, Key: &key.PublicKey}
+	case ed25519.PrivateKey:
+		return VerificationKey{Algorithm: k.Algorithm, Key: key.Public()}
+	}
+	return VerificationKey{Algorithm: k.Algorithm, Key: k.key}
+}
+
+// KeySet signs with its first key and verifies with any of its keys, so a
+// new key can be rolled out by putting it first while the old one stays
+// around until every token it signed has expired.
+type KeySet struct {
+	keys []SigningKey
+}
+
+func NewKeySet(keys ...SigningKey) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("a key set needs at least one key")
+	}
+	return &KeySet{keys: keys}, nil
+}
+
+func (ks *KeySet) Sign(claims StaffClaims) (string, error) {
+	active := ks.keys[0]
+	header := JWTHeader{Algorithm: active.Algorithm, Type: "JWT", KeyID: active.ID}
+	return SignJWT(header, claims, active.key)
+}
+
+// Verify checks the signature and expiry of an access token without any
+// database access.
+func (ks *KeySet) Verify(token string, now time.Time) (StaffClaims, error) {
+	var claims StaffClaims
+	err := ParseJWT(token, func(header JWTHeader) (VerificationKey, error) {
+		for _, key := range ks.keys {
+			if key.ID == header.KeyID {
+				return key.verificationKey(), nil
+			}
+		}
+		return VerificationKey{}, ErrInvalidJWT
+	}, &claims)
+	if err != nil {
+		return StaffClaims{}, err
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return StaffClaims{}, ErrExpiredJWT
+	}
+	return claims, nil
+}
+
+// JWKS publishes the public halves of the asymmetric keys. HS256 secrets are
+// never published.
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if key.Algorithm == AlgHS256 {
+			continue
+		}
+		jwk, err := NewJWK(key.ID, key.Algorithm, key.verificationKey().Key)
+		if err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
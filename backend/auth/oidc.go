@@ -0,0 +1,410 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// idTokenLeeway tolerates clock skew between us and the identity provider.
+const idTokenLeeway = time.Minute
+
+// maxOIDCResponseSize caps what is read from an identity provider.
+const maxOIDCResponseSize = 1 << 20
+
+// jwksRefreshInterval limits how often an unknown key ID makes the client
+// fetch the provider's JWKS again, so forged tokens cannot make it call the
+// provider on every request.
+const jwksRefreshInterval = time.Minute
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrInvalidIssuer  = errors.New("issuer must be an https URL of a public host")
+)
+
+// OIDCClient is a confidential OpenID Connect client of one identity
+// provider, using the authorization code flow with PKCE (RFC 7636). The
+// provider's discovery document is fetched on first use and its JWKS is
+// cached for JWKSCacheTTL. A client is safe for concurrent use.
+//
+// All provider endpoints must be https unless AllowInsecure is set, which
+// is meant for identity providers in development. Use NewOIDCHTTPClient as
+// HTTPClient to keep the client off private networks too.
+type OIDCClient struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	HTTPClient    *http.Client
+	JWKSCacheTTL  time.Duration
+	AllowInsecure bool
+
+	mu            sync.Mutex
+	discovery     *OIDCDiscovery
+	keys          *JWKSet
+	keysFetchedAt time.Time
+}
+
+// OIDCDiscovery is the part of the provider metadata the client needs.
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the standard claims used to map an ID token to a staff
+// member.
+type IDTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp,omitempty"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+}
+
+// Audience is the aud claim, which may be a single string or a list.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// PKCEChallenge derives the S256 code challenge sent in the authorization
+// request from the code verifier kept by the client.
+func PKCEChallenge(codeVerifier string) string {
+	digest := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// CheckIssuer checks that an issuer is an https URL without query, fragment
+// or credentials whose host only resolves to public addresses, so that
+// registering an identity provider cannot point the server at internal
+// services. allowInsecure skips the checks of the scheme and addresses.
+func CheckIssuer(issuer string, allowInsecure bool) error {
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" || parsed.User != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return ErrInvalidIssuer
+	}
+	if allowInsecure {
+		if parsed.Scheme != "https" && parsed.Scheme != "http" {
+			return ErrInvalidIssuer
+		}
+		return nil
+	}
+	if parsed.Scheme != "https" {
+		return ErrInvalidIssuer
+	}
+
+	addresses, err := net.LookupIP(parsed.Hostname())
+	if err != nil || len(addresses) == 0 {
+		return ErrInvalidIssuer
+	}
+	for _, address := range addresses {
+		if !isPublicAddress(address) {
+			return ErrInvalidIssuer
+		}
+	}
+	return nil
+}
+
+// NewOIDCHTTPClient returns the HTTP client for calls to identity
+// providers. Unless allowInsecure is set it only connects to public
+// addresses, checked when connecting so that DNS changes after CheckIssuer
+// do not matter, and does not follow redirects to plain http.
+func NewOIDCHTTPClient(timeout time.Duration, allowInsecure bool) *http.Client {
+	if allowInsecure {
+		return &http.Client{Timeout: timeout}
+	}
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicAddress(ip) {
+				return fmt.Errorf("OIDC: refusing to connect to %s", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if request.URL.Scheme != "https" {
+				return errors.New("OIDC: refusing redirect to " + request.URL.Scheme)
+			}
+			if len(via) >= 5 {
+				return errors.New("OIDC: too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+func isPublicAddress(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
+
+func (c *OIDCClient) checkEndpoint(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("OIDC discovery: invalid endpoint %q", endpoint)
+	}
+	if parsed.Scheme != "https" && !(c.AllowInsecure && parsed.Scheme == "http") {
+		return fmt.Errorf("OIDC discovery: endpoint %q is not https", endpoint)
+	}
+	return nil
+}
+
+func (c *OIDCClient) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Discover fetches the provider's discovery document. Its issuer must match
+// the configured one exactly.
+func (c *OIDCClient) Discover() (OIDCDiscovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.discover()
+}
+
+func (c *OIDCClient) discover() (OIDCDiscovery, error) {
+	if c.discovery != nil {
+		return *c.discovery, nil
+	}
+
+	if err := c.checkEndpoint(c.Issuer); err != nil {
+		return OIDCDiscovery{}, err
+	}
+	var discovery OIDCDiscovery
+	discoveryURL := strings.TrimSuffix(c.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(discoveryURL, &discovery); err != nil {
+		return OIDCDiscovery{}, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	if discovery.Issuer != c.Issuer {
+		return OIDCDiscovery{}, fmt.Errorf("OIDC discovery: issuer %q does not match %q", discovery.Issuer, c.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return OIDCDiscovery{}, errors.New("OIDC discovery: incomplete provider metadata")
+	}
+	for _, endpoint := range []string{discovery.AuthorizationEndpoint, discovery.TokenEndpoint, discovery.JWKSURI} {
+		if err := c.checkEndpoint(endpoint); err != nil {
+			return OIDCDiscovery{}, err
+		}
+	}
+
+	c.discovery = &discovery
+	return discovery, nil
+}
+
+// AuthCodeURL returns the provider URL the user is sent to for login.
+func (c *OIDCClient) AuthCodeURL(state string, nonce string, codeVerifier string) (string, error) {
+	discovery, err := c.Discover()
+	if err != nil {
+		return "", err
+	}
+
+	scopes := append([]string{"openid"}, c.Scopes...)
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientID},
+		"redirect_uri":          {c.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns
+// the raw ID token. The ID token still has to be verified.
+func (c *OIDCClient) Exchange(code string, codeVerifier string) (string, error) {
+	discovery, err := c.Discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.RedirectURL},
+		"client_id":     {c.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	request, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	response, err := c.httpClient().Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxOIDCResponseSize))
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", fmt.Errorf("token endpoint returned status %d", response.StatusCode)
+	}
+	if response.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IDToken == "" {
+		return "", errors.New("token endpoint returned no ID token")
+	}
+	return tokenResponse.IDToken, nil
+}
+
+// VerifyIDToken checks the signature of an ID token against the provider's
+// JWKS, then its issuer, audience, expiry and nonce.
+func (c *OIDCClient) VerifyIDToken(rawIDToken string, nonce string, now time.Time) (IDTokenClaims, error) {
+	var claims IDTokenClaims
+	var keyErr error
+	err := ParseJWT(rawIDToken, func(header JWTHeader) (VerificationKey, error) {
+		key, err := c.signingKey(header.KeyID, now)
+		if err != nil {
+			keyErr = err
+			return VerificationKey{}, err
+		}
+		return key.VerificationKey()
+	}, &claims)
+	if keyErr != nil && !errors.Is(keyErr, ErrInvalidIDToken) {
+		return IDTokenClaims{}, keyErr
+	}
+	if err != nil {
+		return IDTokenClaims{}, ErrInvalidIDToken
+	}
+
+	switch {
+	case claims.Issuer != c.Issuer:
+		return IDTokenClaims{}, fmt.Errorf("%w: unexpected issuer", ErrInvalidIDToken)
+	case !claims.Audience.Contains(c.ClientID):
+		return IDTokenClaims{}, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.ClientID:
+		return IDTokenClaims{}, fmt.Errorf("%w: unexpected authorized party", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return IDTokenClaims{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	case now.Add(-idTokenLeeway).Unix() >= claims.ExpiresAt:
+		return IDTokenClaims{}, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case claims.IssuedAt > now.Add(idTokenLeeway).Unix():
+		return IDTokenClaims{}, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return IDTokenClaims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider's key with keyID from the cached JWKS.
+// The JWKS is fetched again once it is older than JWKSCacheTTL, or when the
+// key is unknown, as after the provider rotated its keys, but not more than
+// once per jwksRefreshInterval.
+func (c *OIDCClient) signingKey(keyID string, now time.Time) (JWK, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keys != nil && now.Sub(c.keysFetchedAt) < c.JWKSCacheTTL {
+		if key, found := c.keys.Key(keyID); found {
+			return key, nil
+		}
+		if now.Sub(c.keysFetchedAt) < jwksRefreshInterval {
+			return JWK{}, ErrInvalidIDToken
+		}
+	}
+
+	discovery, err := c.discover()
+	if err != nil {
+		return JWK{}, err
+	}
+	var keys JWKSet
+	if err := c.getJSON(discovery.JWKSURI, &keys); err != nil {
+		return JWK{}, fmt.Errorf("OIDC JWKS: %w", err)
+	}
+	c.keys, c.keysFetchedAt = &keys, now
+
+	key, found := keys.Key(keyID)
+	if !found {
+		return JWK{}, ErrInvalidIDToken
+	}
+	return key, nil
+}
+
+func (c *OIDCClient) getJSON(url string, value interface{}) error {
+	response, err := c.httpClient().Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, response.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, maxOIDCResponseSize)).Decode(value)
+}
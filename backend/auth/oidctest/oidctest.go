@@ -0,0 +1,181 @@
+// Package oidctest provides a minimal OpenID Connect provider for tests of
+// the SSO login flow. It implements discovery, the JWKS endpoint and the
+// token endpoint of the authorization code flow with PKCE; the login page is
+// replaced by Authorize.
+package oidctest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+)
+
+const keyID = "oidctest"
+
+type Provider struct {
+	Server       *httptest.Server
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	key ed25519.PrivateKey
+
+	mu           sync.Mutex
+	grants       map[string]grant
+	jwksRequests int
+}
+
+// grant is an authorization code waiting to be redeemed.
+type grant struct {
+	redirectURI   string
+	codeChallenge string
+	claims        auth.IDTokenClaims
+}
+
+// NewProvider starts a provider that accepts the given client credentials.
+// Call Close when done.
+func NewProvider(clientID string, clientSecret string) *Provider {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		grants:       make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	p.Issuer = p.Server.URL
+	return p
+}
+
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// Authorize stands in for the user logging in at the provider. It checks the
+// authorization request and returns the code and state the provider would
+// redirect back with. Issuer, audience, nonce and times are filled in.
+func (p *Provider) Authorize(authorizationURL string, claims auth.IDTokenClaims) (code string, state string, err error) {
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		return "", "", err
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" {
+		return "", "", errors.New("oidctest: invalid authorization request")
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return "", "", errors.New("oidctest: PKCE is required")
+	}
+
+	code, err = auth.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	claims.Issuer = p.Issuer
+	claims.Audience = auth.Audience{p.ClientID}
+	claims.Nonce = query.Get("nonce")
+	claims.IssuedAt = now.Unix()
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = now.Add(5 * time.Minute).Unix()
+	}
+
+	p.mu.Lock()
+	p.grants[code] = grant{
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		claims:        claims,
+	}
+	p.mu.Unlock()
+
+	return code, query.Get("state"), nil
+}
+
+// SignIDToken signs arbitrary claims with the provider's key.
+func (p *Provider) SignIDToken(claims auth.IDTokenClaims) (string, error) {
+	return auth.SignJWT(auth.JWTHeader{Algorithm: auth.AlgEdDSA, Type: "JWT", KeyID: keyID}, claims, p.key)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, auth.OIDCDiscovery{
+		Issuer:                p.Issuer,
+		AuthorizationEndpoint: p.Issuer + "/authorize",
+		TokenEndpoint:         p.Issuer + "/token",
+		JWKSURI:               p.Issuer + "/jwks",
+	})
+}
+
+// JWKSRequests returns how often the JWKS was fetched.
+func (p *Provider) JWKSRequests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jwksRequests
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.jwksRequests++
+	p.mu.Unlock()
+
+	jwk, _ := auth.NewJWK(keyID, auth.AlgEdDSA, p.key.Public())
+	writeJSON(w, http.StatusOK, auth.JWKSet{Keys: []auth.JWK{jwk}})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, found := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" || !found ||
+		r.PostForm.Get("redirect_uri") != g.redirectURI ||
+		auth.PKCEChallenge(r.PostForm.Get("code_verifier")) != g.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := p.SignIDToken(g.claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
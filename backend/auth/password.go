@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordPolicy describes the rules new passwords must follow. MaxAge of
+// zero means passwords never expire.
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	MaxAge        time.Duration
+
+	blocklist map[string]bool
+}
+
+// PasswordPolicyError lists every rule a password breaks.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the password policy: " + strings.Join(e.Violations, "; ")
+}
+
+// DefaultPasswordPolicy returns the default rules with the built-in list of
+// common passwords loaded.
+func DefaultPasswordPolicy() PasswordPolicy {
+	policy := PasswordPolicy{
+		MinLength:    10,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+	}
+	policy.LoadBlocklist(strings.NewReader(commonPasswords))
+	return policy
+}
+
+// LoadBlocklist adds one password per line from r to the list of rejected
+// passwords, e.g. a local copy of a breached password list.
+func (p *PasswordPolicy) LoadBlocklist(r io.Reader) error {
+	if p.blocklist == nil {
+		p.blocklist = make(map[string]bool)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			p.blocklist[strings.ToLower(password)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// Check returns a *PasswordPolicyError if the password breaks any rule.
+func (p PasswordPolicy) Check(password string, username string) error {
+	var violations []string
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.blocklist[strings.ToLower(password)] {
+		violations = append(violations, "is too common")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, "must not contain the username")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// Expired reports whether a password changed at changedAt must be changed.
+func (p PasswordPolicy) Expired(changedAt time.Time, now time.Time) bool {
+	return p.MaxAge > 0 && changedAt.Add(p.MaxAge).Before(now)
+}
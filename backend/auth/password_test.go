@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	assert.NoError(t, policy.Check("Str0ngPassphrase", "somchai"))
+
+	violations := func(password string, username string) []string {
+		err := policy.Check(password, username)
+		if err == nil {
+			return nil
+		}
+		return err.(*PasswordPolicyError).Violations
+	}
+
+	assert.Contains(t, violations("Sh0rt", ""), "must be at least 10 characters long")
+	assert.Contains(t, violations("alllowercase1", ""), "must contain an uppercase letter")
+	assert.Contains(t, violations("ALLUPPERCASE1", ""), "must contain a lowercase letter")
+	assert.Contains(t, violations("NoDigitsAtAll", ""), "must contain a digit")
+	assert.Contains(t, violations("Password1234", ""), "is too common")
+	assert.Contains(t, violations("Somchai12345", "somchai"), "must not contain the username")
+
+	policy.RequireSymbol = true
+	assert.Contains(t, violations("Str0ngPassphrase", ""), "must contain a symbol")
+	assert.NoError(t, policy.Check("Str0ng-Passphrase", ""))
+}
+
+func TestPasswordPolicyBlocklist(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	assert.NoError(t, policy.Check("Ward7Nurse2024", ""))
+
+	policy.LoadBlocklist(strings.NewReader("ward7nurse2024\n"))
+	assert.Error(t, policy.Check("Ward7Nurse2024", ""))
+}
+
+func TestPasswordPolicyExpired(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	now := time.Now()
+
+	assert.False(t, policy.Expired(now.Add(-365*24*time.Hour), now))
+
+	policy.MaxAge = 90 * 24 * time.Hour
+	assert.False(t, policy.Expired(now.Add(-30*24*time.Hour), now))
+	assert.True(t, policy.Expired(now.Add(-91*24*time.Hour), now))
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationList holds the IDs of JWT access tokens revoked before their
+// expiry. Entries are dropped once the token would have expired anyway, so
+// the list stays small.
+type RevocationList struct {
+	mu      sync.RWMutex
+	entries map[string]time.Time
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{entries: make(map[string]time.Time)}
+}
+
+func (r *RevocationList) Add(tokenID string, expiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, expiry := range r.entries {
+		if expiry.Before(now) {
+			delete(r.entries, id)
+		}
+	}
+	r.entries[tokenID] = expiresAt
+}
+
+func (r *RevocationList) Contains(tokenID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, revoked := r.entries[tokenID]
+	return revoked
+}
+
+// Replace swaps the whole list, e.g. after reloading it from the database.
+func (r *RevocationList) Replace(entries map[string]time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = entries
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+)
+
+type AuthConfig struct {
+	// BootstrapToken unlocks POST /staff/bootstrap, which creates the first
+	// admin of a hospital that has no staff yet. Empty disables bootstrap.
+	BootstrapToken string
+
+	// TokenHashKey keys the HMAC used to store bearer and refresh tokens.
+	TokenHashKey []byte
+
+	// AccessTokenTTL is at most maxAccessTokenTTL, the lifetime access tokens
+	// had before they could be refreshed; ACCESS_TOKEN_TTL can only shorten it.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Failed logins are counted per username and per client IP. Once a
+	// counter reaches its threshold the key is locked for LockoutBase,
+	// doubling with every further failure up to LockoutMax. Counters reset
+	// after LockoutWindow without failures.
+	LockoutThreshold   int
+	IPLockoutThreshold int
+	LockoutWindow      time.Duration
+	LockoutBase        time.Duration
+	LockoutMax         time.Duration
+
+	// JWTKeys is set in JWT mode (AUTH_MODE=jwt): access tokens are then
+	// signed JWTs verified without a database lookup, and Revocations holds
+	// the ones revoked before they expire.
+	JWTKeys     *auth.KeySet
+	Revocations *auth.RevocationList
+
+	PasswordPolicy   auth.PasswordPolicy
+	PasswordResetTTL time.Duration
+
+	// MFAIssuer is the account issuer shown in authenticator apps.
+	MFAIssuer            string
+	MFAChallengeTTL      time.Duration
+	MFAChallengeTries    int
+	MFARecoveryCodeCount int
+
+	// OIDCStateTTL is how long a user has to complete a single sign-on login
+	// at the identity provider. OIDCHTTPTimeout bounds calls to it.
+	// OIDCJWKSCacheTTL is how long a provider's signing keys are cached.
+	// OIDCAllowInsecure accepts http issuers on private networks, for
+	// identity providers in development only.
+	OIDCStateTTL      time.Duration
+	OIDCHTTPTimeout   time.Duration
+	OIDCJWKSCacheTTL  time.Duration
+	OIDCAllowInsecure bool
+}
+
+const maxAccessTokenTTL = 24 * time.Hour
+
+var Auth = AuthConfig{
+	AccessTokenTTL:  maxAccessTokenTTL,
+	RefreshTokenTTL: 30 * 24 * time.Hour,
+	Revocations:     auth.NewRevocationList(),
+
+	LockoutThreshold:   5,
+	IPLockoutThreshold: 20,
+	LockoutWindow:      15 * time.Minute,
+	LockoutBase:        time.Minute,
+	LockoutMax:         time.Hour,
+
+	PasswordPolicy:   auth.DefaultPasswordPolicy(),
+	PasswordResetTTL: time.Hour,
+
+	MFAIssuer:            "Hospital API",
+	MFAChallengeTTL:      5 * time.Minute,
+	MFAChallengeTries:    5,
+	MFARecoveryCodeCount: 10,
+
+	OIDCStateTTL:     10 * time.Minute,
+	OIDCHTTPTimeout:  10 * time.Second,
+	OIDCJWKSCacheTTL: time.Hour,
+}
+
+func LoadAuthConfig() {
+	Auth.BootstrapToken = getEnv("BOOTSTRAP_TOKEN", "")
+	Auth.TokenHashKey = []byte(getEnv("TOKEN_HASH_KEY", ""))
+	if len(Auth.TokenHashKey) == 0 {
+		log.Println("TOKEN_HASH_KEY is not set, stored tokens are hashed without a secret key")
+	}
+	Auth.AccessTokenTTL = getDuration("ACCESS_TOKEN_TTL", Auth.AccessTokenTTL)
+	if Auth.AccessTokenTTL <= 0 || Auth.AccessTokenTTL > maxAccessTokenTTL {
+		log.Fatalf("ACCESS_TOKEN_TTL must be positive and at most %s", maxAccessTokenTTL)
+	}
+	Auth.RefreshTokenTTL = getDuration("REFRESH_TOKEN_TTL", Auth.RefreshTokenTTL)
+
+	Auth.LockoutThreshold = getInt("LOCKOUT_THRESHOLD", Auth.LockoutThreshold)
+	Auth.IPLockoutThreshold = getInt("LOCKOUT_IP_THRESHOLD", Auth.IPLockoutThreshold)
+	Auth.LockoutWindow = getDuration("LOCKOUT_WINDOW", Auth.LockoutWindow)
+	Auth.LockoutBase = getDuration("LOCKOUT_BASE", Auth.LockoutBase)
+	Auth.LockoutMax = getDuration("LOCKOUT_MAX", Auth.LockoutMax)
+
+	Auth.PasswordPolicy.MinLength = getInt("PASSWORD_MIN_LENGTH", Auth.PasswordPolicy.MinLength)
+	Auth.PasswordPolicy.RequireUpper = getBool("PASSWORD_REQUIRE_UPPER", Auth.PasswordPolicy.RequireUpper)
+	Auth.PasswordPolicy.RequireLower = getBool("PASSWORD_REQUIRE_LOWER", Auth.PasswordPolicy.RequireLower)
+	Auth.PasswordPolicy.RequireDigit = getBool("PASSWORD_REQUIRE_DIGIT", Auth.PasswordPolicy.RequireDigit)
+	Auth.PasswordPolicy.RequireSymbol = getBool("PASSWORD_REQUIRE_SYMBOL", Auth.PasswordPolicy.RequireSymbol)
+	Auth.PasswordPolicy.MaxAge = getDuration("PASSWORD_MAX_AGE", Auth.PasswordPolicy.MaxAge)
+	if blocklistFile := getEnv("PASSWORD_BLOCKLIST_FILE", ""); blocklistFile != "" {
+		file, err := os.Open(blocklistFile)
+		if err != nil {
+			log.Fatalf("Failed to open password blocklist: %v", err)
+		}
+		if err := Auth.PasswordPolicy.LoadBlocklist(file); err != nil {
+			log.Fatalf("Failed to read password blocklist: %v", err)
+		}
+		file.Close()
+	}
+	Auth.PasswordResetTTL = getDuration("PASSWORD_RESET_TTL", Auth.PasswordResetTTL)
+
+	Auth.MFAIssuer = getEnv("MFA_ISSUER", Auth.MFAIssuer)
+	Auth.MFAChallengeTTL = getDuration("MFA_CHALLENGE_TTL", Auth.MFAChallengeTTL)
+
+	Auth.OIDCStateTTL = getDuration("OIDC_STATE_TTL", Auth.OIDCStateTTL)
+	Auth.OIDCHTTPTimeout = getDuration("OIDC_HTTP_TIMEOUT", Auth.OIDCHTTPTimeout)
+	Auth.OIDCJWKSCacheTTL = getDuration("OIDC_JWKS_CACHE_TTL", Auth.OIDCJWKSCacheTTL)
+	Auth.OIDCAllowInsecure = getBool("OIDC_ALLOW_INSECURE", Auth.OIDCAllowInsecure)
+
+	if getEnv("AUTH_MODE", "opaque") == "jwt" {
+		Auth.JWTKeys = loadJWTKeys()
+	}
+}
+
+// loadJWTKeys reads JWT_SIGNING_KEYS, a comma separated list of kid=value
+// pairs where value is the secret for HS256 or the path of a PEM private key
+// for RS256 and EdDSA. The first key signs; all of them verify.
+func loadJWTKeys() *auth.KeySet {
+	algorithm := getEnv("JWT_ALGORITHM", auth.AlgHS256)
+
+	var keys []auth.SigningKey
+	for _, entry := range strings.Split(getEnv("JWT_SIGNING_KEYS", ""), ",") {
+		keyID, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+
+		var material interface{} = []byte(value)
+		if algorithm != auth.AlgHS256 {
+			data, err := os.ReadFile(value)
+			if err != nil {
+				log.Fatalf("Failed to read JWT key %s: %v", keyID, err)
+			}
+			if material, err = auth.ParsePrivateKeyPEM(data); err != nil {
+				log.Fatalf("Failed to parse JWT key %s: %v", keyID, err)
+			}
+		}
+
+		key, err := auth.NewSigningKey(keyID, algorithm, material)
+		if err != nil {
+			log.Fatalf("Invalid JWT key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+
+	keySet, err := auth.NewKeySet(keys...)
+	if err != nil {
+		log.Fatalf("AUTH_MODE=jwt requires JWT_SIGNING_KEYS: %v", err)
+	}
+	return keySet
+}
+
+func getBool(key string, defaultValue bool) bool {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid boolean for %s: %v", key, err)
+	}
+	return flag
+}
+
+func getInt(key string, defaultValue int) int {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid number for %s: %v", key, err)
+	}
+	return number
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid duration for %s: %v", key, err)
+	}
+	return duration
+}
@@ -5,7 +5,7 @@ import (
 	"log"
 	"os"
 
-	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/tenant"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -25,7 +25,7 @@ func ConnectDB() {
 		host, user, password, dbname, port, timezone,
 	)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 		panic("failed to connect database")
@@ -33,9 +33,13 @@ func ConnectDB() {
 
 	log.Println("Connected to database successfully")
 
-	db.AutoMigrate(&models.PatientResponse{})
-	db.AutoMigrate(&models.Hospital{}, &models.Staff{}, &models.Patient{})
-	db.AutoMigrate(&models.Token{})
+	if err := tenant.Register(db); err != nil {
+		log.Fatalf("Failed to register tenant scoping: %v", err)
+	}
+
+	if err := Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
 
 	DB = db
 }
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/encryption"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/tenant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoadEncryptionConfig sets up the encryption of patient identifiers from
+// ENCRYPTION_KEY_FILE, a keyfile as read by encryption.LoadKeyFile, and
+// BLIND_INDEX_KEY, the secret of the blind indexes.
+func LoadEncryptionConfig() {
+	keyFile := getEnv("ENCRYPTION_KEY_FILE", "")
+	if keyFile == "" {
+		log.Fatal("ENCRYPTION_KEY_FILE is not set")
+	}
+	provider, err := encryption.LoadKeyFile(keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+
+	encryption.Default, err = encryption.NewCipher(provider, []byte(getEnv("BLIND_INDEX_KEY", "")))
+	if err != nil {
+		log.Fatalf("Failed to set up encryption: %v", err)
+	}
+}
+
+// encryptedPatientColumns are the encrypted columns of patients. Each has
+// its blind index in <column>_index.
+var encryptedPatientColumns = []string{"national_id", "passport_id", "phone_number", "email"}
+
+// encryptedColumns returns the encrypted columns of patient and their blind
+// indexes as an update, which encrypts them under the current key.
+func encryptedColumns(patient *models.Patient) (map[string]interface{}, error) {
+	if err := patient.SetBlindIndexes(); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"national_id":        patient.NationalID,
+		"national_id_index":  patient.NationalIDIndex,
+		"passport_id":        patient.PassportID,
+		"passport_id_index":  patient.PassportIDIndex,
+		"phone_number":       patient.PhoneNumber,
+		"phone_number_index": patient.PhoneNumberIndex,
+		"email":              patient.Email,
+		"email_index":        patient.EmailIndex,
+	}, nil
+}
+
+// migratePatientEncryption encrypts the identifiers of patients stored
+// before they were encrypted and fills in missing blind indexes. The
+// indexes on the plaintext columns are dropped.
+func migratePatientEncryption(db *gorm.DB) error {
+	for _, column := range encryptedPatientColumns {
+		index := "idx_patients_hospital_" + column
+		if db.Migrator().HasIndex(&models.Patient{}, index) {
+			if err := db.Migrator().DropIndex(&models.Patient{}, index); err != nil {
+				return err
+			}
+		}
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, column := range encryptedPatientColumns {
+		conditions = append(conditions, fmt.Sprintf("(%[1]s <> '' AND (%[1]s NOT LIKE ? OR %[1]s_index IS NULL OR %[1]s_index = ''))", column))
+		args = append(args, encryption.Prefix+"%")
+	}
+	_, err := reencryptPatients(db, strings.Join(conditions, " OR "), args...)
+	return err
+}
+
+// uniquePatientIndexes are the blind indexes of identifiers no two patients
+// of a hospital may share.
+var uniquePatientIndexes = []string{"idx_patients_hospital_national_id_index", "idx_patients_hospital_passport_id_index"}
+
+// migrateUniquePatientIndexes makes the blind indexes of national IDs and
+// passport numbers unique in databases where they were created as plain
+// indexes. It fails if patients registered twice have to be merged first.
+func migrateUniquePatientIndexes(db *gorm.DB) error {
+	indexes, err := db.Migrator().GetIndexes(&models.Patient{})
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if !slices.Contains(uniquePatientIndexes, index.Name()) {
+			continue
+		}
+		if unique, _ := index.Unique(); unique {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&models.Patient{}, index.Name()); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&models.Patient{}, index.Name())
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w; merge the patients registered more than once and restart", index.Name(), err)
+		}
+	}
+	return nil
+}
+
+// ReencryptPatients is the key rotation job: it re-encrypts the identifiers
+// of every patient that has some not encrypted under the current key and
+// the snapshots of their revisions, and returns how many records it
+// re-encrypted. Once it has run, older keys can be removed from the
+// keyfile.
+func ReencryptPatients(db *gorm.DB) (int, error) {
+	if encryption.Default == nil {
+		return 0, encryption.ErrNotConfigured
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, column := range encryptedPatientColumns {
+		conditions = append(conditions, fmt.Sprintf("(%[1]s <> '' AND %[1]s NOT LIKE ?)", column))
+		args = append(args, encryption.Prefix+encryption.Default.CurrentKeyID()+":%")
+	}
+	count, err := reencryptPatients(db, strings.Join(conditions, " OR "), args...)
+	if err != nil {
+		return count, err
+	}
+
+	var revisions []models.PatientRevision
+	err = tenant.AllHospitals(db).
+		Where("snapshot NOT LIKE ?", encryption.Prefix+encryption.Default.CurrentKeyID()+":%").
+		FindInBatches(&revisions, 500, func(tx *gorm.DB, batch int) error {
+			for _, revision := range revisions {
+				if err := tenant.AllHospitals(db).Model(&revision).UpdateColumn("snapshot", revision.Snapshot).Error; err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		}).Error
+	return count, err
+}
+
+// reencryptPatients re-encrypts the patients matching condition. Each
+// patient is locked and loaded again in its own transaction before it is
+// written back, so a change saved since the batch was read is not
+// overwritten with the old values; patients such a change has already
+// re-encrypted no longer match and are skipped.
+func reencryptPatients(db *gorm.DB, condition string, args ...interface{}) (int, error) {
+	count := 0
+	var patients []models.Patient
+	err := tenant.AllHospitals(db).Unscoped().
+		Select("id").
+		Where(condition, args...).
+		FindInBatches(&patients, 500, func(tx *gorm.DB, batch int) error {
+			for _, patient := range patients {
+				reencrypted, err := reencryptPatient(db, patient.ID, condition, args...)
+				if err != nil {
+					return err
+				}
+				if reencrypted {
+					count++
+				}
+			}
+			return nil
+		}).Error
+	return count, err
+}
+
+func reencryptPatient(db *gorm.DB, id uint, condition string, args ...interface{}) (bool, error) {
+	reencrypted := false
+	err := tenant.AllHospitals(db).Transaction(func(tx *gorm.DB) error {
+		var patient models.Patient
+		result := tx.Unscoped().
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			Where(condition, args...).
+			Limit(1).
+			Find(&patient)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		changes, err := encryptedColumns(&patient)
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&patient).UpdateColumns(changes).Error; err != nil {
+			return err
+		}
+		reencrypted = true
+		return nil
+	})
+	return reencrypted, err
+}
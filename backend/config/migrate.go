@@ -0,0 +1,344 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/encryption"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/tenant"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/validation"
+	"gorm.io/gorm"
+)
+
+// Migrate brings the schema of db up to date and runs the data migrations,
+// in the order ConnectDB relies on. db must have tenant scoping registered.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(
+		&models.PatientResponse{}, &models.SchemaMigration{},
+		&models.Permission{}, &models.Role{},
+		&models.Hospital{}, &models.Staff{}, &models.Patient{}, &models.HNSequence{}, &models.PatientRevision{},
+		&models.Token{}, &models.RevokedToken{}, &models.PasswordResetToken{},
+		&models.LoginAttempt{}, &models.SecurityEvent{},
+		&models.MFAChallenge{}, &models.RecoveryCode{},
+		&models.APIKey{}, &models.ServiceIdentity{},
+		&models.IdentityProvider{}, &models.ExternalIdentity{}, &models.OIDCLoginState{},
+		&models.AuditEntry{}, &models.AuditEntryPatient{}, &models.AuditChain{},
+	); err != nil {
+		return err
+	}
+	// HNs are only unique because of this index, so refuse to start without
+	// it rather than hand out duplicates.
+	if !db.Migrator().HasIndex(&models.Patient{}, "idx_patients_hospital_hn") {
+		return fmt.Errorf("index idx_patients_hospital_hn is missing; resolve duplicate patient HNs and restart")
+	}
+
+	steps := []struct {
+		name    string
+		migrate func(*gorm.DB) error
+	}{
+		{"tokens", migrateTokenHashes},
+		{"service identities", migrateServiceIdentities},
+		{"patient identifiers", func(db *gorm.DB) error {
+			return migrateOnce(db, "normalize_patient_identifiers", migratePatientIdentifiers)
+		}},
+		{"patient encryption", migratePatientEncryption},
+		{"patient indexes", migrateUniquePatientIndexes},
+		{"name search", migrateNameSearch},
+		{"patient revisions", migratePatientRevisions},
+		{"audit log", migrateAuditLog},
+		{"roles", SeedRoles},
+		{"staff roles", func(db *gorm.DB) error {
+			return migrateOnce(db, "assign_roles_to_legacy_staff", migrateStaffRoles)
+		}},
+	}
+	for _, step := range steps {
+		if err := step.migrate(db); err != nil {
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateOnce runs a data migration that has to look at every row unless it
+// is recorded as done, and records it in the same transaction. If two
+// instances start at once, the second to finish rolls back on the record.
+func migrateOnce(db *gorm.DB, name string, migrate func(*gorm.DB) error) error {
+	var done int64
+	if err := db.Model(&models.SchemaMigration{}).Where("name = ?", name).Count(&done).Error; err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		return tx.Create(&models.SchemaMigration{Name: name, AppliedAt: time.Now()}).Error
+	})
+}
+
+// migrateTokenHashes replaces the plaintext token columns of databases
+// created before tokens were hashed. Existing sessions keep working because
+// their hash is computed from the stored plaintext before it is dropped.
+func migrateTokenHashes(db *gorm.DB) error {
+	columns := map[string]string{
+		"token":         "token_hash",
+		"refresh_token": "refresh_token_hash",
+	}
+
+	for plainColumn, hashColumn := range columns {
+		if !db.Migrator().HasColumn(&models.Token{}, plainColumn) {
+			continue
+		}
+
+		var legacyTokens []struct {
+			ID    uint
+			Value string
+		}
+		if err := db.Table("tokens").
+			Select("id, " + plainColumn + " AS value").
+			Where(plainColumn + " IS NOT NULL AND " + plainColumn + " <> ''").
+			Scan(&legacyTokens).Error; err != nil {
+			return err
+		}
+
+		for _, legacyToken := range legacyTokens {
+			if err := db.Table("tokens").
+				Where("id = ?", legacyToken.ID).
+				Update(hashColumn, auth.HashToken(Auth.TokenHashKey, legacyToken.Value)).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := db.Migrator().DropColumn(&models.Token{}, plainColumn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateServiceIdentities drops the certificate names service identities
+// were matched by before they were bound to a certificate's issuer and
+// subject. Identities registered that way no longer authenticate and have
+// to be registered again with their certificate.
+func migrateServiceIdentities(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.ServiceIdentity{}, "certificate_name") {
+		return nil
+	}
+	return db.Migrator().DropColumn(&models.ServiceIdentity{}, "certificate_name")
+}
+
+// migrateStaffRoles gives roles to staff created before there were any, who
+// would otherwise be left without permissions. In each hospital without an
+// admin the first of them becomes admin; the others become nurses, which
+// keeps the patient search they had. It runs after SeedRoles, once through
+// migrateOnce.
+func migrateStaffRoles(db *gorm.DB) error {
+	var admin, nurse models.Role
+	if err := db.Where("name = ?", models.RoleAdmin).First(&admin).Error; err != nil {
+		return err
+	}
+	if err := db.Where("name = ?", models.RoleNurse).First(&nurse).Error; err != nil {
+		return err
+	}
+
+	var staffWithoutRoles []models.Staff
+	if err := db.Where("NOT EXISTS (SELECT 1 FROM staff_roles WHERE staff_roles.staff_id = staffs.id)").
+		Order("id").Find(&staffWithoutRoles).Error; err != nil {
+		return err
+	}
+
+	hasAdmin := map[uint]bool{}
+	for _, staff := range staffWithoutRoles {
+		if _, checked := hasAdmin[staff.HospitalID]; !checked {
+			var adminCount int64
+			if err := db.Model(&models.Staff{}).
+				Joins("JOIN staff_roles ON staff_roles.staff_id = staffs.id").
+				Where("staffs.hospital_id = ? AND staff_roles.role_id = ?", staff.HospitalID, admin.ID).
+				Count(&adminCount).Error; err != nil {
+				return err
+			}
+			hasAdmin[staff.HospitalID] = adminCount > 0
+		}
+
+		role := nurse
+		if !hasAdmin[staff.HospitalID] {
+			role = admin
+			hasAdmin[staff.HospitalID] = true
+		}
+		if err := db.Model(&staff).Association("Roles").Append(&role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateNameSearch fills the name search columns of patients registered
+// before fuzzy search existed and, on Postgres, adds the pg_trgm indexes
+// fuzzy search relies on.
+func migrateNameSearch(db *gorm.DB) error {
+	if db.Dialector.Name() == "postgres" {
+		statements := []string{
+			"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+			"CREATE INDEX IF NOT EXISTS idx_patients_search_name_trgm ON patients USING gin (search_name gin_trgm_ops)",
+			"CREATE INDEX IF NOT EXISTS idx_patients_search_phonetic_trgm ON patients USING gin (search_phonetic gin_trgm_ops)",
+		}
+		for _, statement := range statements {
+			if err := db.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	var patients []models.Patient
+	return tenant.AllHospitals(db).Unscoped().
+		Where("search_name IS NULL OR search_name = ''").
+		FindInBatches(&patients, 500, func(tx *gorm.DB, batch int) error {
+			for _, patient := range patients {
+				searchName, searchPhonetic := patient.NameKeys()
+				if searchName == "" {
+					continue
+				}
+				if err := tenant.AllHospitals(db).Model(&patient).UpdateColumns(map[string]interface{}{
+					"search_name":     searchName,
+					"search_phonetic": searchPhonetic,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+// migratePatientRevisions gives patients registered before revisions were
+// kept a baseline revision: their current record, dated when it last
+// changed. Deleted patients also get a revision for the deletion.
+func migratePatientRevisions(db *gorm.DB) error {
+	var patients []models.Patient
+	return tenant.AllHospitals(db).Unscoped().
+		Where("NOT EXISTS (SELECT 1 FROM patient_revisions WHERE patient_revisions.patient_id = patients.id)").
+		FindInBatches(&patients, 500, func(tx *gorm.DB, batch int) error {
+			for _, patient := range patients {
+				revisions := []models.PatientRevision{{
+					HospitalID:    patient.HospitalID,
+					PatientID:     patient.ID,
+					Version:       1,
+					CreatedAt:     patient.UpdatedAt,
+					Action:        models.RevisionBaseline,
+					ChangedFields: []string{},
+				}}
+				if patient.DeletedAt.Valid {
+					revisions = append(revisions, models.PatientRevision{
+						HospitalID:    patient.HospitalID,
+						PatientID:     patient.ID,
+						Version:       2,
+						CreatedAt:     patient.DeletedAt.Time,
+						Action:        models.AuditPatientDelete,
+						ChangedFields: []string{},
+					})
+				}
+				for i := range revisions {
+					if err := revisions[i].SetSnapshot(patient.Snapshot()); err != nil {
+						return err
+					}
+				}
+				if err := tenant.AllHospitals(db).Create(&revisions).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+// migrateAuditLog adds triggers that reject updates and deletes of audit
+// entries, so that the audit log is append-only for the application's
+// database user too. The hash chain still detects changes made by anyone
+// able to drop the triggers.
+func migrateAuditLog(db *gorm.DB) error {
+	tables := []string{"audit_entries", "audit_entry_patients"}
+
+	var statements []string
+	switch db.Dialector.Name() {
+	case "postgres":
+		statements = append(statements, `CREATE OR REPLACE FUNCTION audit_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit log is append-only';
+END;
+$$ LANGUAGE plpgsql`)
+		for _, table := range tables {
+			statements = append(statements,
+				fmt.Sprintf("DROP TRIGGER IF EXISTS %s_append_only ON %s", table, table),
+				fmt.Sprintf("CREATE TRIGGER %s_append_only BEFORE UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION audit_append_only()", table, table),
+			)
+		}
+	case "sqlite":
+		for _, table := range tables {
+			for _, operation := range []string{"UPDATE", "DELETE"} {
+				statements = append(statements, fmt.Sprintf(
+					"CREATE TRIGGER IF NOT EXISTS %s_append_only_%s BEFORE %s ON %s BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END",
+					table, strings.ToLower(operation), operation, table))
+			}
+		}
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratePatientIdentifiers brings identifiers stored before they were
+// normalized into the form exact search compares: passports upper case,
+// Thai phone numbers in E.164 and emails in lower case. Phone numbers that
+// are not Thai are left as they are. Dates of birth are moved to midnight
+// UTC of their calendar date in the hospitals' time zone, so that date range
+// searches find them. The identifiers are encrypted, so the rows to change
+// cannot be selected in SQL; it runs once through migrateOnce.
+func migratePatientIdentifiers(db *gorm.DB) error {
+	var patients []models.Patient
+	return tenant.AllHospitals(db).Unscoped().
+		FindInBatches(&patients, 500, func(tx *gorm.DB, batch int) error {
+			for _, patient := range patients {
+				identifiersChanged := false
+				if passport := validation.NormalizePassport(string(patient.PassportID)); passport != string(patient.PassportID) {
+					patient.PassportID = encryption.EncryptedString(passport)
+					identifiersChanged = true
+				}
+				if phone, ok := validation.NormalizeThaiPhone(string(patient.PhoneNumber)); ok && phone != string(patient.PhoneNumber) {
+					patient.PhoneNumber = encryption.EncryptedString(phone)
+					identifiersChanged = true
+				}
+				if email := validation.NormalizeEmail(string(patient.Email)); email != string(patient.Email) {
+					patient.Email = encryption.EncryptedString(email)
+					identifiersChanged = true
+				}
+
+				changes := map[string]interface{}{}
+				if identifiersChanged {
+					var err error
+					if changes, err = encryptedColumns(&patient); err != nil {
+						return err
+					}
+				}
+				if birth := validation.DateOnly(patient.DateOfBirth.In(models.LocalTimeZone)); !birth.Equal(patient.DateOfBirth) {
+					changes["date_of_birth"] = birth
+				}
+				if len(changes) == 0 {
+					continue
+				}
+
+				if err := tenant.AllHospitals(db).Model(&patient).UpdateColumns(changes).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
@@ -0,0 +1,463 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/audit"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/encryption"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/tenant"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// legacyToken is the tokens table as it was before tokens were hashed
+type legacyToken struct {
+	gorm.Model
+	Token      string `gorm:"uniqueIndex"`
+	StaffID    uint
+	HospitalID uint
+	ExpiresAt  time.Time
+}
+
+func (legacyToken) TableName() string {
+	return "tokens"
+}
+
+func TestMigrateTokenHashes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+
+	Auth.TokenHashKey = []byte("test-hash-key")
+	defer func() { Auth.TokenHashKey = nil }()
+
+	db.AutoMigrate(&legacyToken{})
+	db.Create(&legacyToken{Token: "legacy-token-12345", StaffID: 1, HospitalID: 1, ExpiresAt: time.Now().Add(time.Hour)})
+
+	db.AutoMigrate(&models.Token{})
+	err = migrateTokenHashes(db)
+	assert.NoError(t, err)
+
+	assert.False(t, db.Migrator().HasColumn(&models.Token{}, "token"))
+
+	var token models.Token
+	err = db.Where("token_hash = ?", auth.HashToken(Auth.TokenHashKey, "legacy-token-12345")).First(&token).Error
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), token.StaffID)
+
+	// Running it again is a no-op
+	assert.NoError(t, migrateTokenHashes(db))
+}
+
+// setupTestEncryption encrypts with keys, the first being current.
+func setupTestEncryption(t *testing.T, keys ...encryption.Key) {
+	provider, err := encryption.NewLocalKeyProvider(keys)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	encryption.Default, err = encryption.NewCipher(provider, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatalf("Failed to set up encryption: %v", err)
+	}
+}
+
+var (
+	oldKey = encryption.Key{ID: "2024", Secret: bytes.Repeat([]byte{1}, 32)}
+	newKey = encryption.Key{ID: "2025", Secret: bytes.Repeat([]byte{3}, 32)}
+)
+
+func TestMigrateNameSearch(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	tenant.Register(db)
+	setupTestEncryption(t, oldKey)
+
+	db.AutoMigrate(&models.Patient{})
+	db.Create(&models.Patient{FirstNameTh: "สมชาย", FirstNameEn: "Somchai", HospitalID: 1})
+	// A patient saved before the search columns existed
+	db.Exec("UPDATE patients SET search_name = '', search_phonetic = ''")
+
+	assert.NoError(t, migrateNameSearch(db))
+
+	var patient models.Patient
+	tenant.AllHospitals(db).First(&patient)
+	assert.Equal(t, "สมชาย somchai", patient.SearchName)
+	assert.Equal(t, "somcai", patient.SearchPhonetic)
+}
+
+func TestMigratePatientIdentifiers(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	tenant.Register(db)
+	setupTestEncryption(t, oldKey)
+
+	db.AutoMigrate(&models.Patient{})
+	// Patients stored before identifiers were normalized and encrypted
+	db.Exec("INSERT INTO patients (passport_id, phone_number, email, date_of_birth, hospital_id) VALUES (?, ?, ?, ?, 1)",
+		"aa1234567", "089-123-4567", "Somchai@Example.com", time.Date(1990, 1, 1, 0, 0, 0, 0, models.LocalTimeZone))
+	db.Exec("INSERT INTO patients (phone_number, hospital_id) VALUES (?, 1)", "+1 415 555 0100")
+
+	assert.NoError(t, migratePatientIdentifiers(db))
+
+	var patients []models.Patient
+	tenant.AllHospitals(db).Order("id").Find(&patients)
+	assert.Equal(t, encryption.EncryptedString("AA1234567"), patients[0].PassportID)
+	assert.Equal(t, encryption.EncryptedString("+66891234567"), patients[0].PhoneNumber)
+	assert.Equal(t, encryption.EncryptedString("somchai@example.com"), patients[0].Email)
+	assert.True(t, time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC).Equal(patients[0].DateOfBirth))
+	// Not a Thai number
+	assert.Equal(t, encryption.EncryptedString("+1 415 555 0100"), patients[1].PhoneNumber)
+
+	// Normalized identifiers are encrypted and indexed
+	var raw struct {
+		PassportID      string
+		PassportIDIndex string
+	}
+	db.Raw("SELECT passport_id, passport_id_index FROM patients WHERE id = ?", patients[0].ID).Scan(&raw)
+	assert.True(t, strings.HasPrefix(raw.PassportID, encryption.Prefix))
+	index, _ := encryption.BlindIndex("passport_id", "AA1234567")
+	assert.Equal(t, index, raw.PassportIDIndex)
+}
+
+func TestMigrateStaffRoles(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	db.AutoMigrate(&models.Permission{}, &models.Role{}, &models.Hospital{}, &models.Staff{})
+	assert.NoError(t, SeedRoles(db))
+
+	// Staff from before roles: two in one hospital, and one in a hospital
+	// that already has an admin
+	var admin models.Role
+	db.Where("name = ?", models.RoleAdmin).First(&admin)
+	legacy := []models.Staff{
+		{Username: "first", HospitalID: 1},
+		{Username: "second", HospitalID: 1},
+		{Username: "other", HospitalID: 2},
+	}
+	db.Create(&legacy)
+	db.Create(&models.Staff{Username: "otheradmin", HospitalID: 2, Roles: []models.Role{admin}})
+
+	assert.NoError(t, migrateStaffRoles(db))
+
+	roles := func(username string) []string {
+		var staff models.Staff
+		db.Preload("Roles").Where("username = ?", username).First(&staff)
+		return staff.RoleNames()
+	}
+	assert.Equal(t, []string{models.RoleAdmin}, roles("first"))
+	assert.Equal(t, []string{models.RoleNurse}, roles("second"))
+	assert.Equal(t, []string{models.RoleNurse}, roles("other"))
+	assert.Equal(t, []string{models.RoleAdmin}, roles("otheradmin"))
+
+	var first models.Staff
+	db.Preload("Roles.Permissions").Where("username = ?", "first").First(&first)
+	assert.Contains(t, first.PermissionNames(), models.PermStaffManage)
+	assert.Contains(t, first.PermissionNames(), models.PermPatientRead)
+}
+
+func TestMigrateOnce(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	db.AutoMigrate(&models.SchemaMigration{})
+
+	runs := 0
+	migrate := func(tx *gorm.DB) error {
+		runs++
+		return nil
+	}
+	assert.NoError(t, migrateOnce(db, "test", migrate))
+	assert.NoError(t, migrateOnce(db, "test", migrate))
+	assert.Equal(t, 1, runs)
+
+	// A failed migration is not recorded and runs again
+	failing := func(tx *gorm.DB) error {
+		runs++
+		return errors.New("failed")
+	}
+	assert.Error(t, migrateOnce(db, "failing", failing))
+	assert.Error(t, migrateOnce(db, "failing", failing))
+	assert.Equal(t, 3, runs)
+}
+
+func TestMigratePatientEncryption(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	tenant.Register(db)
+	setupTestEncryption(t, oldKey)
+
+	db.AutoMigrate(&models.Patient{})
+	db.Exec("CREATE INDEX idx_patients_hospital_national_id ON patients (hospital_id, national_id)")
+	db.Exec("INSERT INTO patients (national_id, email, hospital_id) VALUES (?, ?, 1)", "1234567890121", "somchai@example.com")
+
+	assert.NoError(t, migratePatientEncryption(db))
+
+	var raw struct {
+		NationalID      string
+		NationalIDIndex string
+		Email           string
+	}
+	db.Raw("SELECT national_id, national_id_index, email FROM patients").Scan(&raw)
+	keyID, encrypted := encryption.KeyID(raw.NationalID)
+	assert.True(t, encrypted)
+	assert.Equal(t, "2024", keyID)
+	assert.NotContains(t, raw.Email, "somchai")
+	index, _ := encryption.BlindIndex("national_id", "1234567890121")
+	assert.Equal(t, index, raw.NationalIDIndex)
+	assert.False(t, db.Migrator().HasIndex(&models.Patient{}, "idx_patients_hospital_national_id"))
+
+	var patient models.Patient
+	tenant.AllHospitals(db).Where("national_id_index = ?", index).First(&patient)
+	assert.Equal(t, encryption.EncryptedString("1234567890121"), patient.NationalID)
+	assert.Equal(t, encryption.EncryptedString("somchai@example.com"), patient.Email)
+
+	// Running it again leaves the values alone
+	assert.NoError(t, migratePatientEncryption(db))
+	var again string
+	db.Raw("SELECT national_id FROM patients").Scan(&again)
+	assert.Equal(t, raw.NationalID, again)
+}
+
+func TestMigrateUniquePatientIndexes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	db.AutoMigrate(&models.Patient{})
+	// The blind indexes as they were before they were unique
+	plainIndexes := func() {
+		for _, column := range []string{"national_id_index", "passport_id_index"} {
+			db.Exec("DROP INDEX idx_patients_hospital_" + column)
+			db.Exec("CREATE INDEX idx_patients_hospital_" + column + " ON patients (hospital_id, " + column + ")")
+		}
+	}
+	unique := func(name string) bool {
+		indexes, _ := db.Migrator().GetIndexes(&models.Patient{})
+		for _, index := range indexes {
+			if index.Name() == name {
+				isUnique, _ := index.Unique()
+				return isUnique
+			}
+		}
+		return false
+	}
+	plainIndexes()
+
+	// Patients registered twice have to be merged first
+	db.Exec("INSERT INTO patients (national_id_index, hospital_id) VALUES ('a', 1), ('a', 1)")
+	assert.Error(t, migrateUniquePatientIndexes(db))
+	assert.False(t, unique("idx_patients_hospital_national_id_index"))
+	assert.True(t, db.Migrator().HasIndex(&models.Patient{}, "idx_patients_hospital_national_id_index"))
+
+	// A deleted patient may share the identifiers of another
+	db.Exec("UPDATE patients SET deleted_at = ? WHERE id = 2", time.Now())
+	assert.NoError(t, migrateUniquePatientIndexes(db))
+	assert.True(t, unique("idx_patients_hospital_national_id_index"))
+	assert.True(t, unique("idx_patients_hospital_passport_id_index"))
+	assert.Error(t, db.Exec("INSERT INTO patients (national_id_index, hospital_id) VALUES ('a', 1)").Error)
+	assert.NoError(t, db.Exec("INSERT INTO patients (national_id_index, hospital_id) VALUES ('a', 2), ('', 1), ('', 1)").Error)
+}
+
+// legacyServiceIdentity is the service_identities table as it was when
+// identities were matched by certificate name
+type legacyServiceIdentity struct {
+	gorm.Model
+	HospitalID      uint
+	Name            string
+	CertificateName string `gorm:"uniqueIndex"`
+	Scopes          string
+}
+
+func (legacyServiceIdentity) TableName() string {
+	return "service_identities"
+}
+
+func TestMigrateServiceIdentities(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+
+	db.AutoMigrate(&legacyServiceIdentity{})
+	db.Create(&legacyServiceIdentity{HospitalID: 1, Name: "Lab system", CertificateName: "lab.hospital-a.internal"})
+
+	db.AutoMigrate(&models.ServiceIdentity{})
+	assert.NoError(t, migrateServiceIdentities(db))
+	assert.False(t, db.Migrator().HasColumn(&models.ServiceIdentity{}, "certificate_name"))
+
+	// The old identity is kept but matches no certificate
+	var identity models.ServiceIdentity
+	assert.NoError(t, db.First(&identity).Error)
+	assert.Equal(t, "", identity.CertificateSubject)
+	assert.Nil(t, identity.VerifiedAt)
+
+	assert.NoError(t, db.Create(&models.ServiceIdentity{HospitalID: 1, CertificateIssuer: "CN=Hospital CA", CertificateSubject: "CN=lab-system"}).Error)
+	assert.NoError(t, migrateServiceIdentities(db))
+}
+
+func TestReencryptPatients(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	tenant.Register(db)
+	setupTestEncryption(t, oldKey)
+
+	db.AutoMigrate(&models.Patient{}, &models.PatientRevision{})
+	db.Create(&models.Patient{NationalID: "1234567890121", PhoneNumber: "+66891234567", HospitalID: 1})
+	db.Create(&models.Patient{FirstNameTh: "ไม่มีเลขประจำตัว", HospitalID: 1})
+	assert.NoError(t, migratePatientRevisions(db))
+
+	// Nothing to do under the current key
+	count, err := ReencryptPatients(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// A new key is added in front of the old one
+	setupTestEncryption(t, newKey, oldKey)
+	count, err = ReencryptPatients(db)
+	assert.NoError(t, err)
+	// One patient with identifiers and the revisions of both
+	assert.Equal(t, 3, count)
+
+	var stored []string
+	db.Raw("SELECT national_id FROM patients UNION SELECT phone_number FROM patients WHERE phone_number <> '' UNION SELECT snapshot FROM patient_revisions").Scan(&stored)
+	for _, value := range stored {
+		if value == "" {
+			continue
+		}
+		keyID, _ := encryption.KeyID(value)
+		assert.Equal(t, "2025", keyID)
+	}
+
+	// The old key is no longer needed
+	setupTestEncryption(t, newKey)
+	var patient models.Patient
+	assert.NoError(t, tenant.AllHospitals(db).First(&patient).Error)
+	assert.Equal(t, encryption.EncryptedString("1234567890121"), patient.NationalID)
+	assert.Equal(t, encryption.EncryptedString("+66891234567"), patient.PhoneNumber)
+	var revision models.PatientRevision
+	assert.NoError(t, tenant.AllHospitals(db).Where("patient_id = ?", patient.ID).First(&revision).Error)
+	snapshot, err := revision.PatientSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234567890121", snapshot.NationalID)
+
+	count, err = ReencryptPatients(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestReencryptPatientsConcurrentChange(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	tenant.Register(db)
+	setupTestEncryption(t, oldKey)
+
+	db.AutoMigrate(&models.Patient{})
+	patient := models.Patient{PhoneNumber: "+66891234567", HospitalID: 1}
+	db.Create(&patient)
+
+	setupTestEncryption(t, newKey, oldKey)
+	condition := "phone_number <> '' AND phone_number NOT LIKE ?"
+	arg := encryption.Prefix + encryption.Default.CurrentKeyID() + ":%"
+
+	// The patient is changed after the job found it: the change is kept
+	patient.PhoneNumber = "+66899999999"
+	assert.NoError(t, tenant.AllHospitals(db).Save(&patient).Error)
+	reencrypted, err := reencryptPatient(db, patient.ID, condition, arg)
+	assert.NoError(t, err)
+	assert.False(t, reencrypted)
+
+	var stored models.Patient
+	assert.NoError(t, tenant.AllHospitals(db).First(&stored, patient.ID).Error)
+	assert.Equal(t, encryption.EncryptedString("+66899999999"), stored.PhoneNumber)
+}
+
+func TestMigratePatientRevisions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	tenant.Register(db)
+	setupTestEncryption(t, oldKey)
+
+	db.AutoMigrate(&models.Patient{}, &models.PatientRevision{})
+	updatedAt := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	deletedAt := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	patients := []models.Patient{
+		{FirstNameTh: "สมชาย", NationalID: "1234567890121", HospitalID: 1},
+		{FirstNameTh: "สมหญิง", HospitalID: 2},
+	}
+	patients[0].UpdatedAt = updatedAt
+	patients[1].UpdatedAt = updatedAt
+	patients[1].DeletedAt = gorm.DeletedAt{Time: deletedAt, Valid: true}
+	tenant.AllHospitals(db).Create(&patients)
+
+	assert.NoError(t, migratePatientRevisions(db))
+	// Running it again adds nothing
+	assert.NoError(t, migratePatientRevisions(db))
+
+	var revisions []models.PatientRevision
+	tenant.AllHospitals(db).Order("patient_id, version").Find(&revisions)
+	if !assert.Len(t, revisions, 3) {
+		return
+	}
+
+	assert.Equal(t, models.RevisionBaseline, revisions[0].Action)
+	assert.Equal(t, uint(1), revisions[0].Version)
+	assert.True(t, updatedAt.Equal(revisions[0].CreatedAt))
+	snapshot, err := revisions[0].PatientSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234567890121", snapshot.NationalID)
+	assert.Equal(t, "สมชาย", snapshot.FirstNameTh)
+
+	assert.Equal(t, uint(2), revisions[1].HospitalID)
+	assert.Equal(t, models.AuditPatientDelete, revisions[2].Action)
+	assert.Equal(t, uint(2), revisions[2].Version)
+	assert.True(t, deletedAt.Equal(revisions[2].CreatedAt))
+}
+
+func TestMigrateAuditLog(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	db.AutoMigrate(&models.AuditEntry{}, &models.AuditEntryPatient{}, &models.AuditChain{})
+
+	assert.NoError(t, migrateAuditLog(db))
+	// Running it again on every start-up is harmless
+	assert.NoError(t, migrateAuditLog(db))
+
+	entry := models.AuditEntry{HospitalID: 1, Action: models.AuditPatientRead, PatientIDs: []uint{1}}
+	assert.NoError(t, audit.Record(db, &entry))
+
+	// Entries can be added but not changed or removed
+	assert.Error(t, db.Model(&entry).Update("action", models.AuditPatientSearch).Error)
+	assert.Error(t, db.Delete(&entry).Error)
+	assert.Error(t, db.Exec("DELETE FROM audit_entry_patients").Error)
+	assert.NoError(t, audit.Record(db, &models.AuditEntry{HospitalID: 1, Action: models.AuditPatientRead}))
+
+	result, err := audit.Verify(db, 1)
+	assert.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, uint64(2), result.Entries)
+}
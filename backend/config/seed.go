@@ -0,0 +1,31 @@
+package config
+
+import (
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"gorm.io/gorm"
+)
+
+// SeedRoles makes sure the built-in roles and their permissions exist.
+// It is safe to run on every start-up.
+func SeedRoles(db *gorm.DB) error {
+	for roleName, permissionNames := range models.DefaultRolePermissions {
+		var permissions []models.Permission
+		for _, name := range permissionNames {
+			permission := models.Permission{Name: name}
+			if err := db.Where("name = ?", name).FirstOrCreate(&permission).Error; err != nil {
+				return err
+			}
+			permissions = append(permissions, permission)
+		}
+
+		role := models.Role{Name: roleName}
+		if err := db.Where("name = ?", roleName).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+		if err := db.Model(&role).Association("Permissions").Replace(permissions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
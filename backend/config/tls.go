@@ -0,0 +1,139 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+type TLSConfig struct {
+	// CertFile and KeyFile make the server serve TLS on Addr itself instead
+	// of plain HTTP behind a proxy.
+	CertFile string
+	KeyFile  string
+	Addr     string
+
+	// ClientCAs verifies client certificates. ClientAuth decides whether
+	// clients may (tls.VerifyClientCertIfGiven) or must
+	// (tls.RequireAndVerifyClientCert) present one.
+	ClientCAs  *x509.CertPool
+	ClientAuth tls.ClientAuthType
+
+	// In trusted-proxy mode a TLS terminating proxy forwards the client
+	// certificate it verified in ClientCertHeader and the verification
+	// result in ClientVerifyHeader. The headers are only believed on
+	// requests coming from TrustedProxies.
+	ClientCertHeader   string
+	ClientVerifyHeader string
+	TrustedProxies     []*net.IPNet
+}
+
+var TLS = TLSConfig{
+	Addr:               ":8443",
+	ClientAuth:         tls.NoClientCert,
+	ClientVerifyHeader: "X-SSL-Client-Verify",
+}
+
+func LoadTLSConfig() {
+	TLS.CertFile = getEnv("TLS_CERT_FILE", "")
+	TLS.KeyFile = getEnv("TLS_KEY_FILE", "")
+	TLS.Addr = getEnv("TLS_ADDR", TLS.Addr)
+
+	if caFile := getEnv("TLS_CLIENT_CA_FILE", ""); caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatalf("Failed to read client CA bundle: %v", err)
+		}
+		TLS.ClientCAs = x509.NewCertPool()
+		if !TLS.ClientCAs.AppendCertsFromPEM(data) {
+			log.Fatalf("No certificates found in client CA bundle %s", caFile)
+		}
+		TLS.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	switch getEnv("TLS_CLIENT_AUTH", "") {
+	case "":
+	case "none":
+		TLS.ClientAuth = tls.NoClientCert
+	case "optional":
+		TLS.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		log.Fatalf("TLS_CLIENT_AUTH must be none, optional or require")
+	}
+	if TLS.ClientAuth != tls.NoClientCert && TLS.ClientCAs == nil {
+		log.Fatalf("TLS_CLIENT_AUTH requires TLS_CLIENT_CA_FILE")
+	}
+
+	TLS.ClientCertHeader = getEnv("CLIENT_CERT_HEADER", "")
+	TLS.ClientVerifyHeader = getEnv("CLIENT_VERIFY_HEADER", TLS.ClientVerifyHeader)
+	TLS.TrustedProxies = parseNetworks(getEnv("TRUSTED_PROXIES", ""))
+	if TLS.ClientCertHeader != "" && len(TLS.TrustedProxies) == 0 {
+		log.Fatalf("CLIENT_CERT_HEADER requires TRUSTED_PROXIES")
+	}
+}
+
+// Enabled reports whether the server terminates TLS itself.
+func (t *TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// ServerConfig is the tls.Config of the server when it terminates TLS.
+func (t *TLSConfig) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientCAs:  t.ClientCAs,
+		ClientAuth: t.ClientAuth,
+	}
+}
+
+// IsTrustedProxy reports whether a request from ip may carry forwarded
+// client certificates.
+func (t *TLSConfig) IsTrustedProxy(ip net.IP) bool {
+	for _, network := range t.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedProxyList returns the trusted proxies as CIDRs, for
+// gin.Engine.SetTrustedProxies: only requests from them may set the client IP
+// with X-Forwarded-For or X-Real-IP.
+func (t *TLSConfig) TrustedProxyList() []string {
+	proxies := []string{}
+	for _, network := range t.TrustedProxies {
+		proxies = append(proxies, network.String())
+	}
+	return proxies
+}
+
+// parseNetworks reads a comma separated list of CIDRs or single addresses.
+func parseNetworks(value string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Fatalf("Invalid trusted proxy %s: %v", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyPrefix marks API keys so they are easy to spot in logs and secret
+// scanners. The prefix plus the first characters of the secret are stored in
+// clear to tell keys apart.
+const apiKeyPrefix = "hk_"
+
+const apiKeyDisplayLength = len(apiKeyPrefix) + 8
+
+// ListAPIKeys returns the active API keys of the caller's hospital.
+func ListAPIKeys(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	var apiKeys []models.APIKey
+	if err := config.DB.Where("hospital_id = ?", hospitalID).Order("created_at DESC").Find(&apiKeys).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	responses := []models.APIKeyResponse{}
+	for _, apiKey := range apiKeys {
+		responses = append(responses, apiKey.ToResponse())
+	}
+
+	c.JSON(200, gin.H{"data": responses})
+}
+
+// CreateAPIKey issues a new API key for the caller's hospital. The key is
+// only returned in this response.
+func CreateAPIKey(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var request models.APIKeyCreateRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	scopes, ok := checkAPIKeyScopes(c, request.Scopes)
+	if !ok {
+		return
+	}
+	if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+		rejectFields(c, 400, "Validation failed", []models.FieldError{{Field: "expires_at", Rule: "future"}})
+		return
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	apiKey := models.APIKey{
+		HospitalID:  hospitalID.(uint),
+		Name:        request.Name,
+		Prefix:      key[:apiKeyDisplayLength],
+		KeyHash:     auth.HashToken(config.Auth.TokenHashKey, key),
+		Scopes:      strings.Join(scopes, ","),
+		ExpiresAt:   request.ExpiresAt,
+		CreatedByID: actorID,
+	}
+	if err := config.DB.Create(&apiKey).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save API key"})
+		return
+	}
+
+	recordAPIKeyEvent(c, models.SecurityEventAPIKeyCreated, apiKey, actorID)
+
+	c.JSON(201, gin.H{"data": models.APIKeyCreatedResponse{
+		APIKeyResponse: apiKey.ToResponse(),
+		Key:            key,
+	}})
+}
+
+// RotateAPIKey replaces the secret of an API key, keeping its name, scopes
+// and expiry. The old secret stops working immediately.
+func RotateAPIKey(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var apiKey models.APIKey
+	if err := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).First(&apiKey).Error; err != nil {
+		c.JSON(404, gin.H{"error": "API key not found"})
+		return
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	apiKey.Prefix = key[:apiKeyDisplayLength]
+	apiKey.KeyHash = auth.HashToken(config.Auth.TokenHashKey, key)
+	apiKey.LastUsedAt = nil
+	if err := config.DB.Model(&apiKey).Select("prefix", "key_hash", "last_used_at").Updates(&apiKey).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save API key"})
+		return
+	}
+
+	recordAPIKeyEvent(c, models.SecurityEventAPIKeyRotated, apiKey, actorID)
+
+	c.JSON(200, gin.H{"data": models.APIKeyCreatedResponse{
+		APIKeyResponse: apiKey.ToResponse(),
+		Key:            key,
+	}})
+}
+
+// RevokeAPIKey permanently disables an API key of the caller's hospital.
+func RevokeAPIKey(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var apiKey models.APIKey
+	if err := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).First(&apiKey).Error; err != nil {
+		c.JSON(404, gin.H{"error": "API key not found"})
+		return
+	}
+
+	if err := config.DB.Delete(&apiKey).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	recordAPIKeyEvent(c, models.SecurityEventAPIKeyRevoked, apiKey, actorID)
+
+	c.JSON(200, gin.H{"message": "API key revoked"})
+}
+
+func generateAPIKey() (string, error) {
+	secret, err := auth.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + secret, nil
+}
+
+// checkAPIKeyScopes rejects scopes an API key cannot hold or the caller
+// does not hold themselves, so that no one hands out more access than they
+// have, and removes duplicates. It writes the error response and returns
+// false if a scope is rejected.
+func checkAPIKeyScopes(c *gin.Context, requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		rejectFields(c, 400, "Validation failed", []models.FieldError{{Field: "scopes", Rule: "required"}})
+		return nil, false
+	}
+
+	allowed := map[string]bool{}
+	for _, scope := range models.APIKeyScopes {
+		allowed[scope] = true
+	}
+
+	seen := map[string]bool{}
+	var scopes []string
+	for _, scope := range requested {
+		if !allowed[scope] {
+			rejectFields(c, 400, "Validation failed", []models.FieldError{{
+				Field: "scopes",
+				Rule:  "oneof",
+				Param: strings.Join(models.APIKeyScopes, " "),
+			}})
+			return nil, false
+		}
+		if !middleware.HasPermission(c, scope) {
+			rejectFields(c, 403, "Cannot grant a permission you do not hold", []models.FieldError{{
+				Field: "scopes",
+				Rule:  "held",
+				Param: scope,
+			}})
+			return nil, false
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, true
+}
+
+func recordAPIKeyEvent(c *gin.Context, eventType string, apiKey models.APIKey, actorID uint) {
+	recordSecurityEvent(models.SecurityEvent{
+		Type:         eventType,
+		HospitalID:   apiKey.HospitalID,
+		ActorStaffID: &actorID,
+		ClientIP:     c.ClientIP(),
+		Detail:       apiKey.Prefix + " " + apiKey.Name,
+	})
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/validation"
+)
+
+// birthRange is the half-open range [from, to) of dates of birth the date
+// filters of a patient search allow. A zero bound is open.
+type birthRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// after narrows the range to dates on or after day.
+func (r *birthRange) after(day time.Time) {
+	if r.from.IsZero() || day.After(r.from) {
+		r.from = day
+	}
+}
+
+// before narrows the range to dates before day.
+func (r *birthRange) before(day time.Time) {
+	if r.to.IsZero() || day.Before(r.to) {
+		r.to = day
+	}
+}
+
+// birthRangeOf combines the date of birth, date range, birth year and age
+// filters of a search into one range of dates of birth, as stored by
+// validation.DateOnly. Ages are counted as of today in the hospitals' time
+// zone. It returns the rejected fields if any filter is invalid.
+func birthRangeOf(searchRequest models.PatientSearchRequest, now time.Time) (birthRange, []models.FieldError) {
+	var births birthRange
+	var fieldErrors []models.FieldError
+	var from, to time.Time
+
+	dates := []struct {
+		field string
+		value string
+		apply func(day time.Time)
+	}{
+		{"date_of_birth", searchRequest.DateOfBirth, func(day time.Time) {
+			births.after(day)
+			births.before(day.AddDate(0, 0, 1))
+		}},
+		{"dob_from", searchRequest.DOBFrom, func(day time.Time) {
+			from = day
+			births.after(day)
+		}},
+		{"dob_to", searchRequest.DOBTo, func(day time.Time) {
+			to = day
+			births.before(day.AddDate(0, 0, 1))
+		}},
+	}
+	for _, date := range dates {
+		if date.value == "" {
+			continue
+		}
+		day, err := validation.ParseDate(date.value)
+		if err != nil {
+			fieldErrors = append(fieldErrors, models.FieldError{
+				Field: date.field,
+				Rule:  "date",
+			})
+			continue
+		}
+		date.apply(day)
+	}
+
+	if searchRequest.BirthYear != 0 {
+		year := validation.GregorianYear(searchRequest.BirthYear)
+		births.after(time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC))
+		births.before(time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+
+	today := validation.DateOnly(now.In(models.LocalTimeZone))
+	// Someone is at least n years old if born on or before today n years ago.
+	if searchRequest.AgeMin != nil {
+		births.before(today.AddDate(-*searchRequest.AgeMin, 0, 1))
+	}
+	// and at most n years old if born after today n+1 years ago.
+	if searchRequest.AgeMax != nil {
+		births.after(today.AddDate(-*searchRequest.AgeMax-1, 0, 1))
+	}
+
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		fieldErrors = append(fieldErrors, models.FieldError{
+			Field: "dob_to",
+			Rule:  "gtefield",
+			Param: "dob_from",
+		})
+	}
+	if searchRequest.AgeMin != nil && searchRequest.AgeMax != nil && *searchRequest.AgeMin > *searchRequest.AgeMax {
+		fieldErrors = append(fieldErrors, models.FieldError{
+			Field: "age_max",
+			Rule:  "gtefield",
+			Param: "age_min",
+		})
+	}
+
+	return births, fieldErrors
+}
@@ -2,38 +2,134 @@ package controller
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth/oidctest"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/encryption"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/namesearch"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/tenant"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 // SetupTestDB initializes a test database with SQLite in-memory
 func SetupTestDB() (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
 
 	// Migrate the schema
 	db.AutoMigrate(&models.PatientResponse{})
-	db.AutoMigrate(&models.Hospital{}, &models.Staff{}, &models.Patient{})
-	db.AutoMigrate(&models.Token{})
+	db.AutoMigrate(&models.Permission{}, &models.Role{})
+	db.AutoMigrate(&models.Hospital{}, &models.Staff{}, &models.Patient{}, &models.HNSequence{}, &models.PatientRevision{})
+	db.AutoMigrate(&models.Token{}, &models.RevokedToken{}, &models.PasswordResetToken{})
+	db.AutoMigrate(&models.LoginAttempt{}, &models.SecurityEvent{})
+	db.AutoMigrate(&models.MFAChallenge{}, &models.RecoveryCode{})
+	db.AutoMigrate(&models.APIKey{}, &models.ServiceIdentity{})
+	db.AutoMigrate(&models.IdentityProvider{}, &models.ExternalIdentity{}, &models.OIDCLoginState{})
+	db.AutoMigrate(&models.AuditEntry{}, &models.AuditEntryPatient{}, &models.AuditChain{})
+
+	if err := config.SeedRoles(db); err != nil {
+		return nil, err
+	}
+
+	if err := setupTestEncryption(); err != nil {
+		return nil, err
+	}
+
+	if err := tenant.Register(db); err != nil {
+		return nil, err
+	}
 
 	config.DB = db
 	return db, nil
 }
 
+// setupTestEncryption encrypts patient identifiers under fixed test keys
+func setupTestEncryption() error {
+	provider, err := encryption.NewLocalKeyProvider([]encryption.Key{{ID: "test", Secret: bytes.Repeat([]byte{1}, 32)}})
+	if err != nil {
+		return err
+	}
+	encryption.Default, err = encryption.NewCipher(provider, bytes.Repeat([]byte{2}, 32))
+	return err
+}
+
+// SetupPostgresTestDB runs the migrations on a Postgres database, in a schema
+// of its own that is dropped when the test ends. Tests of Postgres-only code
+// are skipped unless TEST_POSTGRES_DSN is set to a key=value DSN.
+func SetupPostgresTestDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	// pg_trgm is created in the test schema unless public already has it
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+schema+",public"), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := setupTestEncryption(); err != nil {
+		t.Fatalf("Failed to setup encryption: %v", err)
+	}
+	if err := tenant.Register(db); err != nil {
+		t.Fatalf("Failed to register tenant scoping: %v", err)
+	}
+	if err := config.Migrate(db); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	config.DB = db
+	return db
+}
+
 // SeedTestData inserts test data for unit tests
 func SeedTestData(db *gorm.DB) error {
 	// Create test hospital
@@ -58,9 +154,38 @@ func SeedTestData(db *gorm.DB) error {
 		return err
 	}
 
+	var adminRole models.Role
+	if err := db.Where("name = ?", models.RoleAdmin).First(&adminRole).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&staff).Association("Roles").Append(&adminRole); err != nil {
+		return err
+	}
+
+	// Create staff without any role
+	noRoleStaff := models.Staff{
+		Username:   "noroleuser",
+		Password:   string(hashedPassword),
+		Name:       "No Role User",
+		HospitalID: hospital.ID,
+	}
+	if err := db.Create(&noRoleStaff).Error; err != nil {
+		return err
+	}
+
+	noRoleToken := models.Token{
+		TokenHash:  auth.HashToken(config.Auth.TokenHashKey, "norole-token-12345"),
+		StaffID:    noRoleStaff.ID,
+		HospitalID: hospital.ID,
+		ExpiresAt:  time.Now().Add(24 * time.Hour),
+	}
+	if err := db.Create(&noRoleToken).Error; err != nil {
+		return err
+	}
+
 	// Create test token
 	token := models.Token{
-		Token:      "test-token-12345",
+		TokenHash:  auth.HashToken(config.Auth.TokenHashKey, "test-token-12345"),
 		StaffID:    staff.ID,
 		HospitalID: hospital.ID,
 		ExpiresAt:  time.Now().Add(24 * time.Hour),
@@ -69,9 +194,36 @@ func SeedTestData(db *gorm.DB) error {
 		return err
 	}
 
+	// Create a registrar, who sees identifiers unmasked
+	registrarStaff := models.Staff{
+		Username:   "registraruser",
+		Password:   string(hashedPassword),
+		Name:       "Registrar User",
+		HospitalID: hospital.ID,
+	}
+	if err := db.Create(&registrarStaff).Error; err != nil {
+		return err
+	}
+	var registrarRole models.Role
+	if err := db.Where("name = ?", models.RoleRegistrar).First(&registrarRole).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&registrarStaff).Association("Roles").Append(&registrarRole); err != nil {
+		return err
+	}
+	registrarToken := models.Token{
+		TokenHash:  auth.HashToken(config.Auth.TokenHashKey, "registrar-token-12345"),
+		StaffID:    registrarStaff.ID,
+		HospitalID: hospital.ID,
+		ExpiresAt:  time.Now().Add(24 * time.Hour),
+	}
+	if err := db.Create(&registrarToken).Error; err != nil {
+		return err
+	}
+
 	// Create expired token for testing
 	expiredToken := models.Token{
-		Token:      "expired-token-12345",
+		TokenHash:  auth.HashToken(config.Auth.TokenHashKey, "expired-token-12345"),
 		StaffID:    staff.ID,
 		HospitalID: hospital.ID,
 		ExpiresAt:  time.Now().Add(-24 * time.Hour), // Expired
@@ -89,9 +241,9 @@ func SeedTestData(db *gorm.DB) error {
 			LastNameEn:  "Jaidee",
 			DateOfBirth: time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
 			PatientHN:   "HN001",
-			NationalID:  "1234567890123",
+			NationalID:  "1234567890121",
 			PassportID:  "",
-			PhoneNumber: "0891234567",
+			PhoneNumber: "+66891234567",
 			Email:       "somchai@example.com",
 			Gender:      "M",
 			HospitalID:  hospital.ID,
@@ -103,9 +255,9 @@ func SeedTestData(db *gorm.DB) error {
 			LastNameEn:  "Rakdee",
 			DateOfBirth: time.Date(1992, 5, 10, 0, 0, 0, 0, time.UTC),
 			PatientHN:   "HN002",
-			NationalID:  "1234567890124",
+			NationalID:  "1234567890139",
 			PassportID:  "",
-			PhoneNumber: "0891234568",
+			PhoneNumber: "+66891234568",
 			Email:       "somying@example.com",
 			Gender:      "F",
 			HospitalID:  hospital.ID,
@@ -124,16 +276,70 @@ func SeedTestData(db *gorm.DB) error {
 // SetupRouter creates a test router with routes
 func SetupRouter() *gin.Engine {
 	router := gin.Default()
-	router.GET("/patient/search/:id", GetPatient)
+	router.SetTrustedProxies(config.TLS.TrustedProxyList())
+
+	patients := router.Group("/")
+	patients.Use(middleware.ActorRequired())
+	{
+		patients.GET("/patient/search", middleware.RequirePermission(models.PermPatientRead), SearchPatients)
+		patients.GET("/patient/search/:id", middleware.RequirePermission(models.PermPatientRead), GetPatient)
+		patients.GET("/patient/hn/:hn", middleware.RequirePermission(models.PermPatientRead), GetPatientByHN)
+		patients.GET("/patient/:id", middleware.RequirePermission(models.PermPatientRead), ShowPatient)
+		patients.POST("/patient", middleware.RequirePermission(models.PermPatientWrite), CreatePatient)
+		patients.PUT("/patient/:id", middleware.RequirePermission(models.PermPatientWrite), UpdatePatient)
+		patients.PATCH("/patient/:id", middleware.RequirePermission(models.PermPatientWrite), PatchPatient)
+		patients.DELETE("/patient/:id", middleware.RequirePermission(models.PermPatientWrite), DeletePatient)
+		patients.POST("/patient/:id/restore", middleware.RequirePermission(models.PermPatientWrite), RestorePatient)
+		patients.POST("/patient/:id/reveal", middleware.RequirePermission(models.PermPatientReveal), RevealPatient)
+		patients.GET("/patient/:id/revisions", middleware.RequirePermission(models.PermPatientRead), ListPatientRevisions)
+		patients.GET("/patient/:id/revisions/diff", middleware.RequirePermission(models.PermPatientRead), DiffPatientRevisions)
+		patients.GET("/patient/:id/as-of", middleware.RequirePermission(models.PermPatientRead), GetPatientAsOf)
+	}
 
 	protected := router.Group("/")
 	protected.Use(middleware.AuthRequired())
 	{
-		protected.GET("/patient/search", SearchPatients)
+		protected.POST("/staff/create", middleware.RequirePermission(models.PermStaffManage), CreateStaff)
+		protected.GET("/roles", middleware.RequirePermission(models.PermStaffRead), ListRoles)
+		protected.PUT("/staff/:id/roles", middleware.RequirePermission(models.PermStaffManage), AssignStaffRoles)
+		protected.DELETE("/staff/:id/sessions", middleware.RequirePermission(models.PermStaffManage), RevokeStaffSessions)
+		protected.POST("/staff/:id/unlock", middleware.RequirePermission(models.PermStaffManage), UnlockStaff)
+		protected.POST("/staff/:id/password/reset", middleware.RequirePermission(models.PermStaffManage), IssuePasswordReset)
+		protected.DELETE("/staff/:id/mfa", middleware.RequirePermission(models.PermStaffManage), ResetStaffMFA)
+		protected.PUT("/hospital/mfa", middleware.RequirePermission(models.PermHospitalManage), SetHospitalMFA)
+		protected.GET("/hospital/hn-format", middleware.RequirePermission(models.PermHospitalManage), GetHospitalHNFormat)
+		protected.PUT("/hospital/hn-format", middleware.RequirePermission(models.PermHospitalManage), SetHospitalHNFormat)
+		protected.GET("/hospital/identity-providers", middleware.RequirePermission(models.PermHospitalManage), ListIdentityProviders)
+		protected.POST("/hospital/identity-providers", middleware.RequirePermission(models.PermHospitalManage), CreateIdentityProvider)
+		protected.DELETE("/hospital/identity-providers/:id", middleware.RequirePermission(models.PermHospitalManage), DeleteIdentityProvider)
+		protected.GET("/hospital/service-identities", middleware.RequirePermission(models.PermHospitalManage), ListServiceIdentities)
+		protected.POST("/hospital/service-identities", middleware.RequirePermission(models.PermHospitalManage), CreateServiceIdentity)
+		protected.DELETE("/hospital/service-identities/:id", middleware.RequirePermission(models.PermHospitalManage), DeleteServiceIdentity)
+		protected.GET("/api-keys", middleware.RequirePermission(models.PermAPIKeyManage), ListAPIKeys)
+		protected.POST("/api-keys", middleware.RequirePermission(models.PermAPIKeyManage), CreateAPIKey)
+		protected.POST("/api-keys/:id/rotate", middleware.RequirePermission(models.PermAPIKeyManage), RotateAPIKey)
+		protected.DELETE("/api-keys/:id", middleware.RequirePermission(models.PermAPIKeyManage), RevokeAPIKey)
+		protected.GET("/audit", middleware.RequirePermission(models.PermAuditRead), ListAuditEntries)
+		protected.GET("/audit/verify", middleware.RequirePermission(models.PermAuditRead), VerifyAuditLog)
+
+		protected.POST("/staff/logout", LogoutStaff)
+		protected.POST("/staff/password", ChangePassword)
+		protected.POST("/staff/mfa/enroll", EnrollMFA)
+		protected.POST("/staff/mfa/verify", VerifyMFA)
+		protected.DELETE("/staff/mfa", DisableMFA)
+		protected.GET("/staff/sessions", ListSessions)
+		protected.DELETE("/staff/sessions/:id", RevokeSession)
 	}
 
-	router.POST("/staff/create", CreateStaff)
+	router.POST("/staff/bootstrap", BootstrapStaff)
 	router.POST("/staff/login", LoginStaff)
+	router.POST("/staff/login/mfa", LoginMFA)
+	router.POST("/staff/token/refresh", RefreshToken)
+	router.GET("/.well-known/jwks.json", JWKS)
+	router.POST("/staff/password/reset", ResetPassword)
+	router.GET("/auth/oidc/:id/login", StartOIDCLogin)
+	router.POST("/auth/oidc/callback", OIDCCallback)
+	router.POST("/auth/client-certificate/verify", middleware.ClientCertificateRequired(), VerifyServiceIdentity)
 
 	return router
 }
@@ -156,7 +362,8 @@ func TestGetPatient(t *testing.T) {
 	// Test case 1: Valid patient ID
 	t.Run("Valid Patient ID", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/patient/search/1234567890123", nil)
+		req, _ := http.NewRequest("GET", "/patient/search/1234567890121", nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, 200, w.Code)
@@ -172,6 +379,7 @@ func TestGetPatient(t *testing.T) {
 	t.Run("Invalid Patient ID", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/patient/search/9999999999999", nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, 404, w.Code)
@@ -181,6 +389,45 @@ func TestGetPatient(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Contains(t, response, "error")
 	})
+
+	// Test case 3: No token
+	t.Run("Unauthenticated", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search/1234567890121", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 4: Patient of another hospital
+	t.Run("Other Hospital", func(t *testing.T) {
+		otherHospital := models.Hospital{Name: "Other Hospital", Location: "Elsewhere"}
+		db.Create(&otherHospital)
+		db.Create(&models.Patient{
+			FirstNameTh: "ผู้ป่วย",
+			NationalID:  "9876543210989",
+			PassportID:  "1234567890121",
+			HospitalID:  otherHospital.ID,
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search/9876543210989", nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 404, w.Code)
+
+		// The other hospital's passport number matches our patient's
+		// national ID, but only our patient comes back
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/patient/search/1234567890121", nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+
+		var response models.PatientResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "สมชาย", response.FirstNameTh)
+	})
 }
 
 // TestCreateStaff tests the CreateStaff function
@@ -204,7 +451,7 @@ func TestCreateStaff(t *testing.T) {
 
 		requestBody := models.StaffCreateRequest{
 			Username:   "newstaff",
-			Password:   "password123",
+			Password:   "Str0ngPassphrase",
 			Name:       "New Staff",
 			Email:      "newstaff@example.com",
 			HospitalID: 1,
@@ -213,6 +460,7 @@ func TestCreateStaff(t *testing.T) {
 		jsonBody, _ := json.Marshal(requestBody)
 		req, _ := http.NewRequest("POST", "/staff/create", bytes.NewBuffer(jsonBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
 
 		router.ServeHTTP(w, req)
 
@@ -224,6 +472,11 @@ func TestCreateStaff(t *testing.T) {
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 		assert.NoError(t, err)
 		assert.Equal(t, "newstaff", response.Data.Username)
+
+		// The password the admin chose has to be changed at first login
+		var created models.Staff
+		db.Where("username = ?", "newstaff").First(&created)
+		assert.True(t, created.MustChangePassword)
 	})
 
 	// Test case 2: Duplicate username
@@ -232,7 +485,7 @@ func TestCreateStaff(t *testing.T) {
 
 		requestBody := models.StaffCreateRequest{
 			Username:   "testuser", // Already exists
-			Password:   "password123",
+			Password:   "Str0ngPassphrase",
 			Name:       "Another Staff",
 			Email:      "another@example.com",
 			HospitalID: 1,
@@ -241,6 +494,7 @@ func TestCreateStaff(t *testing.T) {
 		jsonBody, _ := json.Marshal(requestBody)
 		req, _ := http.NewRequest("POST", "/staff/create", bytes.NewBuffer(jsonBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
 
 		router.ServeHTTP(w, req)
 
@@ -252,13 +506,13 @@ func TestCreateStaff(t *testing.T) {
 		assert.Contains(t, response["error"], "Username already exists")
 	})
 
-	// Test case 3: Invalid hospital
-	t.Run("Invalid Hospital", func(t *testing.T) {
+	// Test case 3: Admin of another hospital
+	t.Run("Other Hospital", func(t *testing.T) {
 		w := httptest.NewRecorder()
 
 		requestBody := models.StaffCreateRequest{
 			Username:   "validstaff",
-			Password:   "password123",
+			Password:   "Str0ngPassphrase",
 			Name:       "Valid Staff",
 			Email:      "valid@example.com",
 			HospitalID: 999, // Invalid hospital ID
@@ -267,8 +521,145 @@ func TestCreateStaff(t *testing.T) {
 		jsonBody, _ := json.Marshal(requestBody)
 		req, _ := http.NewRequest("POST", "/staff/create", bytes.NewBuffer(jsonBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 403, w.Code)
+
+		var response map[string]string
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Contains(t, response["error"], "Cannot create staff for another hospital")
+	})
+
+	// Test case 4: Unauthenticated request
+	t.Run("No Token Provided", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		requestBody := models.StaffCreateRequest{
+			Username:   "anonymousstaff",
+			Password:   "Str0ngPassphrase",
+			Name:       "Anonymous Staff",
+			HospitalID: 1,
+		}
+
+		jsonBody, _ := json.Marshal(requestBody)
+		req, _ := http.NewRequest("POST", "/staff/create", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 5: Password breaks the policy
+	t.Run("Weak Password", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		requestBody := models.StaffCreateRequest{
+			Username:   "weakstaff",
+			Password:   "password123",
+			Name:       "Weak Staff",
+			HospitalID: 1,
+		}
+
+		jsonBody, _ := json.Marshal(requestBody)
+		req, _ := http.NewRequest("POST", "/staff/create", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 400, w.Code)
+
+		var response struct {
+			Error   string              `json:"error"`
+			Details []models.FieldError `json:"details"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Contains(t, response.Error, "password policy")
+		assert.Contains(t, response.Details, models.FieldError{
+			Field:   "password",
+			Rule:    "password_policy",
+			Param:   "is too common",
+			Message: "is too common",
+		})
+	})
+
+	// Test case 6: Staff without staff:manage permission
+	t.Run("Missing Permission", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		requestBody := models.StaffCreateRequest{
+			Username:   "sneakystaff",
+			Password:   "Str0ngPassphrase",
+			Name:       "Sneaky Staff",
+			HospitalID: 1,
+		}
+
+		jsonBody, _ := json.Marshal(requestBody)
+		req, _ := http.NewRequest("POST", "/staff/create", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer norole-token-12345")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 403, w.Code)
+	})
+}
+
+// TestBootstrapStaff tests the first-admin bootstrap endpoint
+func TestBootstrapStaff(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	emptyHospital := models.Hospital{Name: "New Hospital", Location: "New Location"}
+	db.Create(&emptyHospital)
+
+	config.Auth.BootstrapToken = "bootstrap-secret"
+	defer func() { config.Auth.BootstrapToken = "" }()
+
+	router := SetupRouter()
+
+	bootstrap := func(token string, hospitalID uint, username string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+
+		requestBody := models.StaffCreateRequest{
+			Username:   username,
+			Password:   "Str0ngPassphrase",
+			Name:       "First Admin",
+			HospitalID: hospitalID,
+		}
+
+		jsonBody, _ := json.Marshal(requestBody)
+		req, _ := http.NewRequest("POST", "/staff/bootstrap", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Bootstrap-Token", token)
 
 		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test case 1: Wrong bootstrap token
+	t.Run("Invalid Bootstrap Token", func(t *testing.T) {
+		w := bootstrap("wrong-secret", emptyHospital.ID, "firstadmin")
+
+		assert.Equal(t, 403, w.Code)
+	})
+
+	// Test case 2: Hospital does not exist
+	t.Run("Invalid Hospital", func(t *testing.T) {
+		w := bootstrap("bootstrap-secret", 999, "firstadmin")
 
 		assert.Equal(t, 404, w.Code)
 
@@ -277,6 +668,43 @@ func TestCreateStaff(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Contains(t, response["error"], "Hospital not found")
 	})
+
+	// Test case 3: First admin of an empty hospital
+	t.Run("Valid Bootstrap", func(t *testing.T) {
+		w := bootstrap("bootstrap-secret", emptyHospital.ID, "firstadmin")
+
+		assert.Equal(t, 201, w.Code)
+
+		var response struct {
+			Data models.StaffResponse `json:"data"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "firstadmin", response.Data.Username)
+		assert.Equal(t, []string{models.RoleAdmin}, response.Data.Roles)
+
+		var created models.Staff
+		db.Where("username = ?", "firstadmin").First(&created)
+		assert.False(t, created.MustChangePassword)
+	})
+
+	// Test case 4: Hospital already has an admin
+	t.Run("Hospital Already Has Admin", func(t *testing.T) {
+		w := bootstrap("bootstrap-secret", emptyHospital.ID, "secondadmin")
+
+		assert.Equal(t, 409, w.Code)
+	})
+
+	// Test case 5: Staff without an admin, e.g. from before roles existed,
+	// do not block bootstrap
+	t.Run("Hospital Without Admin", func(t *testing.T) {
+		hospital := models.Hospital{Name: "Legacy Hospital", Location: "Elsewhere"}
+		db.Create(&hospital)
+		db.Create(&models.Staff{Username: "legacystaff", Password: "x", HospitalID: hospital.ID})
+
+		w := bootstrap("bootstrap-secret", hospital.ID, "legacyadmin")
+		assert.Equal(t, 201, w.Code)
+	})
 }
 
 // TestLoginStaff tests the LoginStaff function
@@ -471,4 +899,2718 @@ func TestSearchPatients(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, 0, len(response.Data))
 	})
+
+	// Test case 6: Staff without patient:read permission
+	t.Run("Missing Permission", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search?first_name=สมชาย", nil)
+		req.Header.Set("Authorization", "Bearer norole-token-12345")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 403, w.Code)
+
+		var response map[string]string
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Contains(t, response["error"], "Permission denied")
+	})
+
+	type searchResponse struct {
+		Data       []models.PatientResponse `json:"data"`
+		Pagination models.Pagination        `json:"pagination"`
+	}
+	search := func(query string) (*httptest.ResponseRecorder, searchResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search?"+query, nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+
+		var response searchResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	// Test case 7: Identifiers match whole values only
+	t.Run("Identifiers", func(t *testing.T) {
+		_, response := search("national_id=1234567890121")
+		assert.Equal(t, 1, len(response.Data))
+		assert.Equal(t, "สมชาย", response.Data[0].FirstNameTh)
+
+		// A few digits no longer list every patient containing them
+		_, response = search("national_id=123456")
+		assert.Equal(t, 0, len(response.Data))
+		_, response = search("email=example.com")
+		assert.Equal(t, 0, len(response.Data))
+
+		// Identifiers are normalized like when they are stored
+		_, response = search("phone_number=089-123-4568")
+		assert.Equal(t, 1, len(response.Data))
+		assert.Equal(t, "สมหญิง", response.Data[0].FirstNameTh)
+		_, response = search("email=SOMCHAI@Example.com")
+		assert.Equal(t, 1, len(response.Data))
+
+		assert.True(t, db.Migrator().HasIndex(&models.Patient{}, "idx_patients_hospital_national_id_index"))
+	})
+
+	// Seven patients in all, two of them named Somsri
+	for i, name := range []string{"Anong", "Somsri", "Chai", "Somsri", "Dao"} {
+		db.Create(&models.Patient{
+			FirstNameTh: "ผู้ป่วย",
+			FirstNameEn: name,
+			DateOfBirth: time.Date(1970+i, 1, 1, 0, 0, 0, 0, time.UTC),
+			HospitalID:  1,
+		})
+	}
+
+	// Test case 8: Page numbers
+	t.Run("Pages", func(t *testing.T) {
+		w, response := search("limit=3")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 3, len(response.Data))
+		assert.Equal(t, int64(7), response.Pagination.Total)
+		assert.Equal(t, 1, response.Pagination.Page)
+		assert.Equal(t, "id", response.Pagination.Sort)
+		assert.True(t, response.Pagination.HasMore)
+		assert.Equal(t, "สมชาย", response.Data[0].FirstNameTh)
+
+		_, response = search("limit=3&page=3")
+		assert.Equal(t, 1, len(response.Data))
+		assert.Equal(t, "Dao", response.Data[0].FirstNameEn)
+		assert.False(t, response.Pagination.HasMore)
+		assert.Empty(t, response.Pagination.NextCursor)
+
+		// Filters still apply and count
+		_, response = search("first_name=Somsri&limit=1")
+		assert.Equal(t, int64(2), response.Pagination.Total)
+		assert.Equal(t, 1, len(response.Data))
+	})
+
+	// Test case 9: Following cursors visits every patient once, in order
+	t.Run("Cursors", func(t *testing.T) {
+		var names []string
+		query := "sort=-first_name_en&limit=2"
+		for pages := 0; pages < 10; pages++ {
+			w, response := search(query)
+			assert.Equal(t, 200, w.Code)
+			if pages > 0 {
+				assert.Zero(t, response.Pagination.Page)
+			}
+			for _, patient := range response.Data {
+				names = append(names, patient.FirstNameEn)
+			}
+			if !response.Pagination.HasMore {
+				break
+			}
+			query = "sort=-first_name_en&limit=2&cursor=" + response.Pagination.NextCursor
+		}
+		assert.Equal(t, []string{"Somying", "Somsri", "Somsri", "Somchai", "Dao", "Chai", "Anong"}, names)
+
+		// Sorting by date of birth continues across time values
+		_, first := search("sort=date_of_birth&limit=4")
+		_, second := search("sort=date_of_birth&limit=4&cursor=" + first.Pagination.NextCursor)
+		assert.Equal(t, "Anong", first.Data[0].FirstNameEn)
+		assert.Equal(t, []string{"Somchai", "Somying"}, []string{second.Data[1].FirstNameEn, second.Data[2].FirstNameEn})
+	})
+
+	// Test case 10: Rejected page requests
+	t.Run("Invalid Page Requests", func(t *testing.T) {
+		w, _ := search("limit=101")
+		assert.Equal(t, 400, w.Code)
+
+		w, _ = search("sort=national_id")
+		assert.Equal(t, 400, w.Code)
+
+		_, response := search("limit=2")
+		cursor := response.Pagination.NextCursor
+
+		details := func(w *httptest.ResponseRecorder) []models.FieldError {
+			var response struct {
+				Details []models.FieldError `json:"details"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			return response.Details
+		}
+
+		w, _ = search("page=2&cursor=" + cursor)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []models.FieldError{{Field: "cursor", Rule: "excluded_with", Param: "page", Message: "must not be set together with page"}}, details(w))
+
+		// A cursor only continues the sort it was made for
+		w, _ = search("sort=-id&cursor=" + cursor)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []models.FieldError{{Field: "cursor", Rule: "cursor", Message: "is not a cursor of this search and sort order"}}, details(w))
+
+		w, _ = search("cursor=not-a-cursor")
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, "cursor", details(w)[0].Rule)
+	})
+
+	// Test case 11: Fuzzy name matching
+	t.Run("Fuzzy Match", func(t *testing.T) {
+		type matchResponse struct {
+			Data []struct {
+				FirstNameEn string  `json:"first_name_en"`
+				MatchScore  float64 `json:"match_score"`
+			} `json:"data"`
+			Pagination models.Pagination `json:"pagination"`
+		}
+		fuzzy := func(query string) (*httptest.ResponseRecorder, matchResponse) {
+			w, _ := search("match=fuzzy&" + query)
+			var response matchResponse
+			json.Unmarshal(w.Body.Bytes(), &response)
+			return w, response
+		}
+
+		// A substring search misses the transliteration variant
+		_, plain := search("first_name=Somchay")
+		assert.Equal(t, 0, len(plain.Data))
+
+		w, response := fuzzy("first_name=Somchay")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 1, len(response.Data))
+		assert.Equal(t, "Somchai", response.Data[0].FirstNameEn)
+		assert.Equal(t, 1.0, response.Data[0].MatchScore)
+		assert.Equal(t, "score", response.Pagination.Sort)
+
+		// Thai tone marks typed differently
+		_, response = fuzzy("last_name=ใจดี่")
+		assert.Equal(t, 1, len(response.Data))
+		assert.Equal(t, "Somchai", response.Data[0].FirstNameEn)
+
+		// Paged like other searches
+		_, response = fuzzy("first_name=Somsri&limit=1")
+		assert.Equal(t, int64(2), response.Pagination.Total)
+		assert.True(t, response.Pagination.HasMore)
+		assert.Equal(t, "Somsri", response.Data[0].FirstNameEn)
+
+		// Other filters still apply
+		_, response = fuzzy("first_name=Somchay&email=somying@example.com")
+		assert.Equal(t, 0, len(response.Data))
+
+		w, _ = fuzzy("email=somchai@example.com")
+		assert.Equal(t, 400, w.Code)
+		w, _ = fuzzy("first_name=Somchai&sort=id")
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"sort","rule":"excluded_with","param":"match=fuzzy"`)
+		w, _ = search("match=sounds-like&first_name=Somchai")
+		assert.Equal(t, 400, w.Code)
+
+		// Without pg_trgm only so many patients are scored
+		maxInMemoryMatches = 6
+		defer func() { maxInMemoryMatches = 10000 }()
+		w, _ = fuzzy("first_name=Somsri")
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"match","rule":"max_matches","param":"6"`)
+		_, response = fuzzy("first_name=Somsri&birth_year=1971")
+		assert.Equal(t, int64(1), response.Pagination.Total)
+	})
+
+	// Test case 12: Date of birth, birth year and age filters
+	t.Run("Date Of Birth", func(t *testing.T) {
+		names := func(response searchResponse) []string {
+			var names []string
+			for _, patient := range response.Data {
+				names = append(names, patient.FirstNameEn)
+			}
+			return names
+		}
+
+		// Buddhist Era dates are converted
+		for _, date := range []string{"1990-01-01", "2533-01-01", "1990-01-01T00:00:00%2B07:00"} {
+			w, response := search("date_of_birth=" + date)
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, []string{"Somchai"}, names(response), date)
+		}
+
+		// Both ends of the range are included
+		_, response := search("dob_from=1971-01-01&dob_to=2515-01-01")
+		assert.Equal(t, []string{"Somsri", "Chai"}, names(response))
+		_, response = search("dob_from=1990-01-01")
+		assert.Equal(t, []string{"Somchai", "Somying"}, names(response))
+
+		_, response = search("birth_year=2535")
+		assert.Equal(t, []string{"Somying"}, names(response))
+		_, response = search("birth_year=1992")
+		assert.Equal(t, []string{"Somying"}, names(response))
+		_, response = search("birth_year=1972&first_name=Somsri")
+		assert.Empty(t, names(response))
+
+		// Ages count whole years as of today in Thailand
+		today := time.Now().In(models.LocalTimeZone)
+		age := today.Year() - 1992
+		if today.Month() < time.May || today.Month() == time.May && today.Day() < 10 {
+			age--
+		}
+		_, response = search(fmt.Sprintf("age_min=%d&age_max=%d", age, age))
+		assert.Equal(t, []string{"Somying"}, names(response))
+		_, response = search(fmt.Sprintf("age_max=%d", age+2))
+		assert.Equal(t, []string{"Somchai", "Somying"}, names(response))
+		_, response = search(fmt.Sprintf("age_min=%d", age+1))
+		assert.Equal(t, 6, len(response.Data))
+
+		for _, query := range []string{
+			"date_of_birth=01/01/1990",
+			"date_of_birth=2534-02-29",
+			"dob_from=1972-01-01&dob_to=1971-01-01",
+			"dob_from=2515-01-01&dob_to=1971-01-01",
+			"age_min=40&age_max=30",
+			"age_min=-1",
+			"birth_year=99",
+		} {
+			w, _ := search(query)
+			assert.Equal(t, 400, w.Code, query)
+		}
+	})
+
+	// Test case 13: The validate tags are enforced, with messages in Thai
+	// or English
+	t.Run("Validate Tags", func(t *testing.T) {
+		reject := func(query string, acceptLanguage string) []models.FieldError {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/patient/search?"+query, nil)
+			req.Header.Set("Authorization", "Bearer test-token-12345")
+			req.Header.Set("Accept-Language", acceptLanguage)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, 400, w.Code, query)
+
+			var response struct {
+				Details []models.FieldError `json:"details"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			return response.Details
+		}
+
+		details := reject("national_id=1234567890123&email=example.com&first_name=ก", "")
+		assert.Equal(t, []models.FieldError{
+			{Field: "national_id", Rule: "thai_national_id", Message: "must be a valid 13 digit Thai national ID"},
+			{Field: "first_name", Rule: "min", Param: "2", Message: "must be at least 2 characters"},
+			{Field: "email", Rule: "email", Message: "must be a valid email address"},
+		}, details)
+
+		details = reject("email=example.com", "th-TH,th;q=0.9,en;q=0.8")
+		assert.Equal(t, "ต้องเป็นอีเมลที่ถูกต้อง", details[0].Message)
+		details = reject("age_min=200", "en-US,th;q=0.5")
+		assert.Equal(t, "must be at most 150", details[0].Message)
+		details = reject("dob_from=1990-01-02&dob_to=1990-01-01", "th")
+		assert.Equal(t, models.FieldError{Field: "dob_to", Rule: "gtefield", Param: "dob_from", Message: "ต้องไม่น้อยกว่า dob_from"}, details[0])
+		details = reject("sort=national_id", "fr, th;q=0.1")
+		assert.Equal(t, "ต้องเป็นค่าใดค่าหนึ่งต่อไปนี้: "+strings.Join(models.PatientSortFields, ", "), details[0].Message)
+	})
+}
+
+// TestAssignStaffRoles tests the role management endpoints
+func TestSearchPatientsPostgres(t *testing.T) {
+	// Setup
+	db := SetupPostgresTestDB(t)
+
+	err := SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	// Somchart is registered before Somchay but matches Somchai less well;
+	// Somsak and Chai fall below namesearch.MinScore
+	for _, name := range []string{"Somchart", "Somsak", "Somchay", "Chai"} {
+		db.Create(&models.Patient{
+			FirstNameTh: "ผู้ป่วย",
+			FirstNameEn: name,
+			DateOfBirth: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+			HospitalID:  1,
+		})
+	}
+
+	router := SetupRouter()
+
+	type matchResponse struct {
+		Data []struct {
+			FirstNameEn string  `json:"first_name_en"`
+			MatchScore  float64 `json:"match_score"`
+		} `json:"data"`
+		Pagination models.Pagination `json:"pagination"`
+	}
+	fuzzy := func(query string) (*httptest.ResponseRecorder, matchResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search?match=fuzzy&"+query, nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+
+		var response matchResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	// Test case 1: Trigram indexes
+	t.Run("Trigram Indexes", func(t *testing.T) {
+		assert.True(t, db.Migrator().HasIndex(&models.Patient{}, "idx_patients_search_name_trgm"))
+		assert.True(t, db.Migrator().HasIndex(&models.Patient{}, "idx_patients_search_phonetic_trgm"))
+	})
+
+	// Test case 2: Ranked by word similarity, cut off at MinScore
+	t.Run("Fuzzy Match", func(t *testing.T) {
+		w, response := fuzzy("first_name=Somchai")
+		assert.Equal(t, 200, w.Code)
+
+		var names []string
+		for _, match := range response.Data {
+			names = append(names, match.FirstNameEn)
+		}
+		assert.Equal(t, []string{"Somchai", "Somchay", "Somchart"}, names)
+		assert.Equal(t, int64(3), response.Pagination.Total)
+		assert.Equal(t, "score", response.Pagination.Sort)
+
+		// pg_trgm scores like the in-memory matcher used on SQLite
+		var patients []models.Patient
+		db.Where("first_name_en IN ?", names).Order("id").Find(&patients)
+		scores := map[string]float64{}
+		for _, patient := range patients {
+			scores[patient.FirstNameEn] = namesearch.Score("Somchai", patient.SearchName, patient.SearchPhonetic)
+		}
+		for _, match := range response.Data {
+			assert.InDelta(t, scores[match.FirstNameEn], match.MatchScore, 1e-4, match.FirstNameEn)
+			assert.GreaterOrEqual(t, match.MatchScore, namesearch.MinScore)
+		}
+		assert.Equal(t, 1.0, response.Data[0].MatchScore)
+		assert.Less(t, response.Data[2].MatchScore, response.Data[1].MatchScore)
+	})
+
+	// Test case 3: Pages
+	t.Run("Pages", func(t *testing.T) {
+		_, response := fuzzy("first_name=Somchai&limit=2")
+		assert.Equal(t, 2, len(response.Data))
+		assert.Equal(t, int64(3), response.Pagination.Total)
+		assert.True(t, response.Pagination.HasMore)
+
+		_, response = fuzzy("first_name=Somchai&limit=2&page=2")
+		assert.Equal(t, 1, len(response.Data))
+		assert.Equal(t, "Somchart", response.Data[0].FirstNameEn)
+		assert.Equal(t, int64(3), response.Pagination.Total)
+		assert.False(t, response.Pagination.HasMore)
+
+		// Other filters narrow the count as well
+		_, response = fuzzy("first_name=Somchai&birth_year=1980")
+		assert.Equal(t, int64(2), response.Pagination.Total)
+	})
+}
+
+func TestAssignStaffRoles(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	var noRoleStaff models.Staff
+	db.Where("username = ?", "noroleuser").First(&noRoleStaff)
+
+	// Test case 1: List roles
+	t.Run("List Roles", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/roles", nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data []models.RoleResponse `json:"data"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, len(models.DefaultRolePermissions), len(response.Data))
+	})
+
+	// Test case 2: Admin assigns a role
+	t.Run("Assign Role", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		jsonBody, _ := json.Marshal(models.StaffRoleAssignRequest{Roles: []string{models.RoleNurse}})
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/staff/%d/roles", noRoleStaff.ID), bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.StaffResponse `json:"data"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{models.RoleNurse}, response.Data.Roles)
+
+		// The staff member's existing sessions are revoked
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/patient/search?first_name=สมชาย", nil)
+		req.Header.Set("Authorization", "Bearer norole-token-12345")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 401, w.Code)
+
+		// The nurse role grants patient:read
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/patient/search?first_name=สมชาย", nil)
+		req.Header.Set("Authorization", "Bearer "+loginForTest(t, router, "noroleuser", "laptop"))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+	})
+
+	// Test case 3: Unknown role
+	t.Run("Unknown Role", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		jsonBody, _ := json.Marshal(models.StaffRoleAssignRequest{Roles: []string{"janitor"}})
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/staff/%d/roles", noRoleStaff.ID), bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 400, w.Code)
+	})
+
+	// Test case 4: Non-admin cannot assign roles
+	t.Run("Permission Denied", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		jsonBody, _ := json.Marshal(models.StaffRoleAssignRequest{Roles: []string{models.RoleAdmin}})
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/staff/%d/roles", noRoleStaff.ID), bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+loginForTest(t, router, "noroleuser", "laptop"))
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 403, w.Code)
+	})
+}
+
+// loginForTest logs in through the API and returns the issued token
+func loginForTest(t *testing.T, router *gin.Engine, username string, userAgent string) string {
+	return loginResponseForTest(t, router, username, userAgent).Token
+}
+
+// loginResponseForTest logs in through the API and returns the token response
+func loginResponseForTest(t *testing.T, router *gin.Engine, username string, userAgent string) models.TokenResponse {
+	w := httptest.NewRecorder()
+
+	requestBody := models.StaffLoginRequest{
+		Username:   username,
+		Password:   "password123",
+		HospitalID: 1,
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/staff/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("Login failed: %d %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Data models.TokenResponse `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.Data
+}
+
+// TestSessions tests logout and session management
+func TestSessions(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	listSessions := func(token string) (int, []models.SessionResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/staff/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Data []models.SessionResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Data
+	}
+
+	// Test case 1: List sessions
+	t.Run("List Sessions", func(t *testing.T) {
+		laptopToken := loginForTest(t, router, "noroleuser", "laptop")
+		loginForTest(t, router, "noroleuser", "phone")
+
+		code, sessions := listSessions(laptopToken)
+
+		assert.Equal(t, 200, code)
+		// Two logins plus the seeded token; expired tokens are not listed
+		assert.Equal(t, 3, len(sessions))
+
+		current := 0
+		for _, session := range sessions {
+			if session.Current {
+				current++
+				assert.Equal(t, "laptop", session.UserAgent)
+			}
+		}
+		assert.Equal(t, 1, current)
+	})
+
+	// Test case 2: Revoke one of your own sessions
+	t.Run("Revoke Session", func(t *testing.T) {
+		laptopToken := loginForTest(t, router, "noroleuser", "work-laptop")
+		phoneToken := loginForTest(t, router, "noroleuser", "work-phone")
+
+		_, sessions := listSessions(phoneToken)
+		var laptopSessionID uint
+		for _, session := range sessions {
+			if session.UserAgent == "work-laptop" {
+				laptopSessionID = session.ID
+			}
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/staff/sessions/%d", laptopSessionID), nil)
+		req.Header.Set("Authorization", "Bearer "+phoneToken)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+
+		code, _ := listSessions(laptopToken)
+		assert.Equal(t, 401, code)
+	})
+
+	// Test case 3: Cannot revoke another staff member's session
+	t.Run("Revoke Foreign Session", func(t *testing.T) {
+		var adminToken models.Token
+		db.Where("token_hash = ?", auth.HashToken(config.Auth.TokenHashKey, "test-token-12345")).First(&adminToken)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/staff/sessions/%d", adminToken.ID), nil)
+		req.Header.Set("Authorization", "Bearer norole-token-12345")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 404, w.Code)
+	})
+
+	// Test case 4: Logout revokes the current token
+	t.Run("Logout", func(t *testing.T) {
+		token := loginForTest(t, router, "noroleuser", "laptop")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/staff/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+
+		code, _ := listSessions(token)
+		assert.Equal(t, 401, code)
+	})
+
+	// Test case 5: Admin revokes every session of a staff member
+	t.Run("Admin Revokes All Sessions", func(t *testing.T) {
+		token := loginForTest(t, router, "noroleuser", "laptop")
+
+		var staff models.Staff
+		db.Where("username = ?", "noroleuser").First(&staff)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/staff/%d/sessions", staff.ID), nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+
+		code, _ := listSessions(token)
+		assert.Equal(t, 401, code)
+		code, _ = listSessions("norole-token-12345")
+		assert.Equal(t, 401, code)
+	})
+}
+
+// TestRefreshToken tests refresh token rotation and reuse detection
+func TestRefreshToken(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	refresh := func(refreshToken string) (int, models.TokenResponse) {
+		w := httptest.NewRecorder()
+
+		jsonBody, _ := json.Marshal(models.TokenRefreshRequest{RefreshToken: refreshToken})
+		req, _ := http.NewRequest("POST", "/staff/token/refresh", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Data models.TokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Data
+	}
+
+	search := func(accessToken string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search?first_name=สมชาย", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test case 1: Rotation issues a new pair and revokes the old one
+	t.Run("Rotate", func(t *testing.T) {
+		login := loginResponseForTest(t, router, "testuser", "laptop")
+		assert.NotEmpty(t, login.RefreshToken)
+		assert.True(t, login.RefreshExpiresAt.After(login.ExpiresAt))
+
+		code, rotated := refresh(login.RefreshToken)
+
+		assert.Equal(t, 200, code)
+		assert.NotEqual(t, login.Token, rotated.Token)
+		assert.NotEqual(t, login.RefreshToken, rotated.RefreshToken)
+		assert.Equal(t, "testuser", rotated.Staff.Username)
+		assert.Equal(t, 200, search(rotated.Token))
+		assert.Equal(t, 401, search(login.Token))
+	})
+
+	// Test case 2: Reusing a rotated refresh token revokes the family
+	t.Run("Reuse Revokes Family", func(t *testing.T) {
+		login := loginResponseForTest(t, router, "testuser", "laptop")
+
+		code, rotated := refresh(login.RefreshToken)
+		assert.Equal(t, 200, code)
+
+		code, _ = refresh(login.RefreshToken)
+		assert.Equal(t, 401, code)
+
+		assert.Equal(t, 401, search(rotated.Token))
+		code, _ = refresh(rotated.RefreshToken)
+		assert.Equal(t, 401, code)
+	})
+
+	// Test case 3: Unknown refresh token
+	t.Run("Invalid Refresh Token", func(t *testing.T) {
+		code, _ := refresh("not-a-refresh-token")
+
+		assert.Equal(t, 401, code)
+	})
+
+	// Test case 4: Expired refresh token
+	t.Run("Expired Refresh Token", func(t *testing.T) {
+		login := loginResponseForTest(t, router, "testuser", "laptop")
+		db.Model(&models.Token{}).
+			Where("refresh_token_hash = ?", auth.HashToken(config.Auth.TokenHashKey, login.RefreshToken)).
+			Update("refresh_expires_at", time.Now().Add(-time.Minute))
+
+		code, _ := refresh(login.RefreshToken)
+
+		assert.Equal(t, 401, code)
+	})
+}
+
+// TestJWTMode tests login, logout and the JWKS endpoint with JWT access tokens
+func TestJWTMode(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := auth.NewSigningKey("k1", auth.AlgEdDSA, edKey)
+	config.Auth.JWTKeys, _ = auth.NewKeySet(key)
+	defer func() { config.Auth.JWTKeys = nil }()
+
+	router := SetupRouter()
+
+	search := func(accessToken string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search?first_name=สมชาย", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test case 1: Login issues a JWT carrying roles
+	t.Run("Login Issues JWT", func(t *testing.T) {
+		token := loginForTest(t, router, "testuser", "laptop")
+
+		claims, err := config.Auth.JWTKeys.Verify(token, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{models.RoleAdmin}, claims.Roles)
+		assert.Contains(t, claims.Permissions, models.PermPatientRead)
+		assert.Equal(t, 200, search(token))
+	})
+
+	// Test case 2: Logout puts the JWT on the revocation list
+	t.Run("Logout", func(t *testing.T) {
+		token := loginForTest(t, router, "testuser", "laptop")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/staff/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 401, search(token))
+
+		var revokedCount int64
+		db.Model(&models.RevokedToken{}).Count(&revokedCount)
+		assert.Equal(t, int64(1), revokedCount)
+	})
+
+	// Test case 3: Changing roles revokes tokens carrying the old ones
+	t.Run("Role Change", func(t *testing.T) {
+		adminToken := loginForTest(t, router, "testuser", "laptop")
+		response := loginResponseForTest(t, router, "registraruser", "laptop")
+		assert.Equal(t, 200, search(response.Token))
+
+		var registrar models.Staff
+		db.Where("username = ?", "registraruser").First(&registrar)
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(models.StaffRoleAssignRequest{Roles: []string{}})
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/staff/%d/roles", registrar.ID), bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		assert.Equal(t, 401, search(response.Token))
+
+		// nor can the refresh token get a new one
+		w = httptest.NewRecorder()
+		jsonBody, _ = json.Marshal(models.TokenRefreshRequest{RefreshToken: response.RefreshToken})
+		req, _ = http.NewRequest("POST", "/staff/token/refresh", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 4: JWKS publishes the signing key
+	t.Run("JWKS", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+
+		var response auth.JWKSet
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(response.Keys))
+		assert.Equal(t, "k1", response.Keys[0].KeyID)
+	})
+}
+
+// TestLoginLockout tests failed-attempt tracking and admin unlock
+func TestLoginLockout(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	config.Auth.LockoutThreshold = 3
+	defer func() { config.Auth.LockoutThreshold = 5 }()
+
+	router := SetupRouter()
+
+	login := func(username string, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+
+		requestBody := models.StaffLoginRequest{
+			Username:   username,
+			Password:   password,
+			HospitalID: 1,
+		}
+
+		jsonBody, _ := json.Marshal(requestBody)
+		req, _ := http.NewRequest("POST", "/staff/login", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test case 1: Account locks after repeated failures
+	t.Run("Lock After Failures", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, 401, login("noroleuser", "wrongpassword").Code)
+		}
+
+		w := login("noroleuser", "password123")
+
+		assert.Equal(t, 429, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		var events []models.SecurityEvent
+		db.Where("type = ?", models.SecurityEventLoginLocked).Find(&events)
+		assert.Equal(t, 1, len(events))
+		assert.Equal(t, "noroleuser", events[0].Username)
+	})
+
+	// Test case 2: Unknown usernames lock the same way
+	t.Run("Unknown Username", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, 401, login("ghostuser", "wrongpassword").Code)
+		}
+
+		w := login("ghostuser", "wrongpassword")
+
+		assert.Equal(t, 429, w.Code)
+
+		var response map[string]string
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "Too many failed login attempts, try again later", response["error"])
+	})
+
+	// Test case 3: Lockout grows with further failures
+	t.Run("Exponential Backoff", func(t *testing.T) {
+		assert.Equal(t, config.Auth.LockoutBase, lockoutDuration(0))
+		assert.Equal(t, 4*config.Auth.LockoutBase, lockoutDuration(2))
+		assert.Equal(t, config.Auth.LockoutMax, lockoutDuration(30))
+	})
+
+	// Test case 4: Forwarded headers from clients do not change the IP
+	t.Run("Spoofed Forwarded For", func(t *testing.T) {
+		config.Auth.IPLockoutThreshold = 3
+		defer func() { config.Auth.IPLockoutThreshold = 20 }()
+
+		attempt := func(i int) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			jsonBody, _ := json.Marshal(models.StaffLoginRequest{
+				Username:   fmt.Sprintf("spoofer%d", i),
+				Password:   "wrongpassword",
+				HospitalID: 1,
+			})
+			req, _ := http.NewRequest("POST", "/staff/login", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+			req.RemoteAddr = "198.51.100.7:40000"
+			router.ServeHTTP(w, req)
+			return w
+		}
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, 401, attempt(i).Code)
+		}
+		assert.Equal(t, 429, attempt(3).Code)
+	})
+
+	// Test case 5: Admin unlock
+	t.Run("Admin Unlock", func(t *testing.T) {
+		var staff models.Staff
+		db.Where("username = ?", "noroleuser").First(&staff)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/staff/%d/unlock", staff.ID), nil)
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 200, login("noroleuser", "password123").Code)
+
+		var unlockEvents int64
+		db.Model(&models.SecurityEvent{}).Where("type = ?", models.SecurityEventLoginUnlocked).Count(&unlockEvents)
+		assert.Equal(t, int64(1), unlockEvents)
+	})
+}
+
+// TestPasswordFlows tests password change, admin reset and expiry
+func TestPasswordFlows(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	var staff models.Staff
+	db.Where("username = ?", "noroleuser").First(&staff)
+
+	postJSON := func(path string, token string, body interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	listSessions := func(token string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/staff/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test case 1: Change own password, other sessions are revoked
+	t.Run("Change Password", func(t *testing.T) {
+		otherToken := loginForTest(t, router, "noroleuser", "phone")
+		token := loginForTest(t, router, "noroleuser", "laptop")
+
+		w := postJSON("/staff/password", token, models.PasswordChangeRequest{
+			CurrentPassword: "password123",
+			NewPassword:     "N3wPassphrase",
+		})
+
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 200, listSessions(token))
+		assert.Equal(t, 401, listSessions(otherToken))
+
+		var updated models.Staff
+		db.First(&updated, staff.ID)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(updated.Password), []byte("N3wPassphrase")))
+	})
+
+	// Test case 2: Wrong current password
+	t.Run("Wrong Current Password", func(t *testing.T) {
+		w := postJSON("/staff/password", "test-token-12345", models.PasswordChangeRequest{
+			CurrentPassword: "wrongpassword",
+			NewPassword:     "N3wPassphrase",
+		})
+
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 3: Admin-issued one-time reset token
+	t.Run("Admin Reset", func(t *testing.T) {
+		w := postJSON(fmt.Sprintf("/staff/%d/password/reset", staff.ID), "test-token-12345", nil)
+
+		assert.Equal(t, 201, w.Code)
+
+		var response struct {
+			Data models.PasswordResetTokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NotEmpty(t, response.Data.ResetToken)
+		// Until it is redeemed the old password only allows changing it
+		var pending models.Staff
+		db.First(&pending, staff.ID)
+		assert.True(t, pending.MustChangePassword)
+
+		// Weak password is rejected without using up the token
+		w = postJSON("/staff/password/reset", "", models.PasswordResetRequest{
+			ResetToken:  response.Data.ResetToken,
+			NewPassword: "short",
+		})
+		assert.Equal(t, 400, w.Code)
+
+		w = postJSON("/staff/password/reset", "", models.PasswordResetRequest{
+			ResetToken:  response.Data.ResetToken,
+			NewPassword: "Res3tPassphrase",
+		})
+		assert.Equal(t, 200, w.Code)
+
+		// The token is single use
+		w = postJSON("/staff/password/reset", "", models.PasswordResetRequest{
+			ResetToken:  response.Data.ResetToken,
+			NewPassword: "An0therPassphrase",
+		})
+		assert.Equal(t, 400, w.Code)
+
+		login := postJSON("/staff/login", "", models.StaffLoginRequest{
+			Username:   "noroleuser",
+			Password:   "Res3tPassphrase",
+			HospitalID: 1,
+		})
+		assert.Equal(t, 200, login.Code)
+		var loginResponse struct {
+			Data models.TokenResponse `json:"data"`
+		}
+		json.Unmarshal(login.Body.Bytes(), &loginResponse)
+		assert.False(t, loginResponse.Data.PasswordChangeRequired)
+	})
+
+	// Test case 4: Expired passwords restrict the session to changing it
+	t.Run("Password Expiry", func(t *testing.T) {
+		config.Auth.PasswordPolicy.MaxAge = 24 * time.Hour
+		defer func() { config.Auth.PasswordPolicy.MaxAge = 0 }()
+
+		db.Model(&models.Staff{}).Where("id = ?", staff.ID).
+			Update("password_changed_at", time.Now().Add(-48*time.Hour))
+
+		w := postJSON("/staff/login", "", models.StaffLoginRequest{
+			Username:   "noroleuser",
+			Password:   "Res3tPassphrase",
+			HospitalID: 1,
+		})
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.TokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.True(t, response.Data.PasswordChangeRequired)
+
+		assert.Equal(t, 403, listSessions(response.Data.Token))
+
+		w = postJSON("/staff/password", response.Data.Token, models.PasswordChangeRequest{
+			CurrentPassword: "Res3tPassphrase",
+			NewPassword:     "Fr3shPassphrase",
+		})
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 200, listSessions(response.Data.Token))
+	})
+}
+
+// TestMFA tests TOTP enrollment, two-step login and hospital-wide MFA
+func TestMFA(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	sendJSON := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	login := func(username string) *httptest.ResponseRecorder {
+		return sendJSON("POST", "/staff/login", "", models.StaffLoginRequest{
+			Username:   username,
+			Password:   "password123",
+			HospitalID: 1,
+		})
+	}
+
+	startChallenge := func(t *testing.T) string {
+		w := login("noroleuser")
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.MFAChallengeResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.True(t, response.Data.MFARequired)
+		assert.NotEmpty(t, response.Data.Challenge)
+		return response.Data.Challenge
+	}
+
+	var secret string
+	var recoveryCodes []string
+
+	// Test case 1: Enrollment
+	t.Run("Enroll", func(t *testing.T) {
+		w := sendJSON("POST", "/staff/mfa/enroll", "norole-token-12345", nil)
+		assert.Equal(t, 200, w.Code)
+
+		var enrollResponse struct {
+			Data models.MFAEnrollResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &enrollResponse)
+		secret = enrollResponse.Data.Secret
+		assert.NotEmpty(t, secret)
+		assert.Contains(t, enrollResponse.Data.ProvisioningURI, "otpauth://totp/")
+
+		w = sendJSON("POST", "/staff/mfa/verify", "norole-token-12345", models.MFACodeRequest{Code: "abcdef"})
+		assert.Equal(t, 400, w.Code)
+
+		code, _ := auth.TOTPCode(secret, time.Now())
+		w = sendJSON("POST", "/staff/mfa/verify", "norole-token-12345", models.MFACodeRequest{Code: code})
+		assert.Equal(t, 200, w.Code)
+
+		var verifyResponse struct {
+			Data models.MFARecoveryCodesResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &verifyResponse)
+		recoveryCodes = verifyResponse.Data.RecoveryCodes
+		assert.Equal(t, config.Auth.MFARecoveryCodeCount, len(recoveryCodes))
+	})
+
+	// Test case 2: Login requires the second step
+	t.Run("Two-Step Login", func(t *testing.T) {
+		challenge := startChallenge(t)
+
+		w := sendJSON("POST", "/staff/login/mfa", "", models.MFALoginRequest{Challenge: challenge, Code: "abcdef"})
+		assert.Equal(t, 401, w.Code)
+
+		// The enrollment code's time step is used up, so take the next one
+		code, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+		w = sendJSON("POST", "/staff/login/mfa", "", models.MFALoginRequest{Challenge: challenge, Code: code})
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.TokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NotEmpty(t, response.Data.Token)
+
+		// Neither the challenge nor the code can be reused
+		w = sendJSON("POST", "/staff/login/mfa", "", models.MFALoginRequest{Challenge: challenge, Code: code})
+		assert.Equal(t, 401, w.Code)
+		w = sendJSON("POST", "/staff/login/mfa", "", models.MFALoginRequest{Challenge: startChallenge(t), Code: code})
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 3: Recovery codes are single use
+	t.Run("Recovery Code", func(t *testing.T) {
+		w := sendJSON("POST", "/staff/login/mfa", "", models.MFALoginRequest{Challenge: startChallenge(t), RecoveryCode: recoveryCodes[0]})
+		assert.Equal(t, 200, w.Code)
+
+		w = sendJSON("POST", "/staff/login/mfa", "", models.MFALoginRequest{Challenge: startChallenge(t), RecoveryCode: recoveryCodes[0]})
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 4: The password does not reset the count of wrong codes
+	t.Run("Wrong Codes Lock", func(t *testing.T) {
+		config.Auth.LockoutThreshold = 3
+		defer func() { config.Auth.LockoutThreshold = 5 }()
+		clearLoginFailures(1, "noroleuser")
+
+		for round := 0; round < 2; round++ {
+			challenge := startChallenge(t)
+			for i := 0; i < 2; i++ {
+				w := sendJSON("POST", "/staff/login/mfa", "", models.MFALoginRequest{Challenge: challenge, Code: "000000"})
+				if round == 1 && i == 1 {
+					assert.Equal(t, 429, w.Code)
+				} else {
+					assert.Equal(t, 401, w.Code)
+				}
+			}
+		}
+		assert.Equal(t, 429, login("noroleuser").Code)
+
+		var staff models.Staff
+		db.Where("username = ?", "noroleuser").First(&staff)
+		w := sendJSON("POST", fmt.Sprintf("/staff/%d/unlock", staff.ID), "test-token-12345", nil)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	// Test case 5: Admin resets a lost enrollment
+	t.Run("Admin Reset", func(t *testing.T) {
+		var staff models.Staff
+		db.Where("username = ?", "noroleuser").First(&staff)
+
+		w := sendJSON("DELETE", fmt.Sprintf("/staff/%d/mfa", staff.ID), "test-token-12345", nil)
+		assert.Equal(t, 200, w.Code)
+
+		w = login("noroleuser")
+		var response struct {
+			Data models.TokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NotEmpty(t, response.Data.Token)
+	})
+
+	// Test case 6: Hospital-wide MFA requirement
+	t.Run("Hospital Requires MFA", func(t *testing.T) {
+		required := true
+		w := sendJSON("PUT", "/hospital/mfa", "test-token-12345", models.HospitalMFARequest{Required: &required})
+		assert.Equal(t, 200, w.Code)
+
+		w = login("testuser")
+		var response struct {
+			Data models.TokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.True(t, response.Data.MFAEnrollmentRequired)
+
+		w = httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search?first_name=สมชาย", nil)
+		req.Header.Set("Authorization", "Bearer "+response.Data.Token)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+
+		w = sendJSON("POST", "/staff/mfa/enroll", response.Data.Token, nil)
+		assert.Equal(t, 200, w.Code)
+	})
+}
+
+// TestAPIKeys tests creating, using, rotating and revoking API keys
+func TestAPIKeys(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	sendJSON := func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	searchWithKey := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/patient/search?first_name=สมชาย", nil)
+		req.Header.Set("X-API-Key", key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var created models.APIKeyCreatedResponse
+
+	// Test case 1: Create a key and use it
+	t.Run("Create And Use", func(t *testing.T) {
+		w := sendJSON("POST", "/api-keys", models.APIKeyCreateRequest{
+			Name:   "Lab integration",
+			Scopes: []string{models.PermPatientRead},
+		})
+		assert.Equal(t, 201, w.Code)
+
+		var response struct {
+			Data models.APIKeyCreatedResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		created = response.Data
+		assert.True(t, len(created.Key) > len(created.Prefix))
+		assert.Equal(t, created.Key[:len(created.Prefix)], created.Prefix)
+		assert.Equal(t, []string{models.PermPatientRead}, created.Scopes)
+
+		w = searchWithKey(created.Key)
+		assert.Equal(t, 200, w.Code)
+
+		var apiKey models.APIKey
+		db.First(&apiKey, created.ID)
+		assert.NotNil(t, apiKey.LastUsedAt)
+		assert.NotEqual(t, created.Key, apiKey.KeyHash)
+	})
+
+	// Test case 2: Invalid requests
+	t.Run("Invalid Requests", func(t *testing.T) {
+		w := sendJSON("POST", "/api-keys", models.APIKeyCreateRequest{
+			Name:   "Too powerful",
+			Scopes: []string{models.PermStaffManage},
+		})
+		assert.Equal(t, 400, w.Code)
+
+		// Admins only reveal identifiers one patient at a time, so they
+		// cannot give a key unmasked access either
+		w = sendJSON("POST", "/api-keys", models.APIKeyCreateRequest{
+			Name:   "Unmasked export",
+			Scopes: []string{models.PermPatientRead, models.PermPatientPII},
+		})
+		assert.Equal(t, 403, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"scopes","rule":"held","param":"patient:pii"`)
+
+		past := time.Now().Add(-time.Hour)
+		w = sendJSON("POST", "/api-keys", models.APIKeyCreateRequest{
+			Name:      "Already expired",
+			Scopes:    []string{models.PermPatientRead},
+			ExpiresAt: &past,
+		})
+		assert.Equal(t, 400, w.Code)
+
+		w = searchWithKey("hk_not-a-real-key")
+		assert.Equal(t, 401, w.Code)
+
+		// API keys are not accepted on staff endpoints
+		w = httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api-keys", nil)
+		req.Header.Set("X-API-Key", created.Key)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 3: Expired key
+	t.Run("Expired Key", func(t *testing.T) {
+		db.Model(&models.APIKey{}).Where("id = ?", created.ID).Update("expires_at", time.Now().Add(-time.Minute))
+		w := searchWithKey(created.Key)
+		assert.Equal(t, 401, w.Code)
+		db.Model(&models.APIKey{}).Where("id = ?", created.ID).Update("expires_at", nil)
+	})
+
+	// Test case 4: Rotation invalidates the old key
+	t.Run("Rotate", func(t *testing.T) {
+		w := sendJSON("POST", fmt.Sprintf("/api-keys/%d/rotate", created.ID), nil)
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.APIKeyCreatedResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, created.ID, response.Data.ID)
+		assert.NotEqual(t, created.Key, response.Data.Key)
+
+		assert.Equal(t, 401, searchWithKey(created.Key).Code)
+		assert.Equal(t, 200, searchWithKey(response.Data.Key).Code)
+		created = response.Data
+	})
+
+	// Test case 5: Revocation
+	t.Run("Revoke", func(t *testing.T) {
+		w := sendJSON("DELETE", fmt.Sprintf("/api-keys/%d", created.ID), nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 401, searchWithKey(created.Key).Code)
+
+		w = sendJSON("GET", "/api-keys", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.NotContains(t, w.Body.String(), created.Prefix)
+
+		var count int64
+		db.Model(&models.SecurityEvent{}).Where("type LIKE ?", "api_key_%").Count(&count)
+		assert.Equal(t, int64(3), count)
+	})
+}
+
+// TestOIDCLogin tests single sign-on against a local mock identity provider
+func TestOIDCLogin(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	idp := oidctest.NewProvider("hospital-api", "client-secret")
+	defer idp.Close()
+
+	// The mock provider is a plain http server on localhost
+	config.Auth.OIDCAllowInsecure = true
+	defer func() { config.Auth.OIDCAllowInsecure = false }()
+
+	var providerID uint
+
+	// startLogin starts a login and returns the authorization URL and the
+	// cookie binding it to the browser
+	startLogin := func(t *testing.T) (string, *http.Cookie) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/auth/oidc/%d/login", providerID), nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.OIDCLoginResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+
+		var cookie *http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == OIDCLoginCookie {
+				cookie = c
+			}
+		}
+		if assert.NotNil(t, cookie) {
+			assert.True(t, cookie.HttpOnly)
+			assert.True(t, cookie.Secure)
+		}
+		return response.Data.AuthorizationURL, cookie
+	}
+
+	callback := func(request models.OIDCCallbackRequest, cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(request)
+		req, _ := http.NewRequest("POST", "/auth/oidc/callback", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	registerProvider := func(issuer string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(models.IdentityProviderRequest{
+			Name:          "Hospital AD",
+			Issuer:        issuer,
+			ClientID:      "hospital-api",
+			ClientSecret:  "client-secret",
+			RedirectURL:   "https://app.example.com/sso/callback",
+			Scopes:        []string{"email", "profile"},
+			AutoProvision: true,
+			DefaultRoles:  []string{models.RoleNurse},
+		})
+		req, _ := http.NewRequest("POST", "/hospital/identity-providers", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test case 1: Admin registers the provider
+	t.Run("Register Provider", func(t *testing.T) {
+		// Only https issuers on public hosts, unless explicitly allowed
+		config.Auth.OIDCAllowInsecure = false
+		assert.Equal(t, 400, registerProvider(idp.Issuer).Code)
+		assert.Equal(t, 400, registerProvider("https://169.254.169.254/latest").Code)
+		config.Auth.OIDCAllowInsecure = true
+
+		w := registerProvider(idp.Issuer)
+		assert.Equal(t, 201, w.Code)
+		assert.NotContains(t, w.Body.String(), "client-secret")
+
+		var response struct {
+			Data models.IdentityProviderResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		providerID = response.Data.ID
+		assert.Equal(t, []string{models.RoleNurse}, response.Data.DefaultRoles)
+	})
+
+	// login walks through the flow as the given identity and returns the
+	// callback response
+	login := func(t *testing.T, claims auth.IDTokenClaims) *httptest.ResponseRecorder {
+		authorizationURL, cookie := startLogin(t)
+		code, state, err := idp.Authorize(authorizationURL, claims)
+		assert.NoError(t, err)
+
+		w := callback(models.OIDCCallbackRequest{Code: code, State: state}, cookie)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		return w
+	}
+
+	// Test case 2: First login provisions a staff member
+	t.Run("Just-In-Time Provisioning", func(t *testing.T) {
+		w := login(t, auth.IDTokenClaims{
+			Subject:           "ad-1001",
+			PreferredUsername: "nurse.joy",
+			Name:              "Nurse Joy",
+			Email:             "joy@example.com",
+			EmailVerified:     true,
+		})
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.TokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NotEmpty(t, response.Data.Token)
+		assert.Equal(t, "nurse.joy", response.Data.Staff.Username)
+		assert.Equal(t, uint(1), response.Data.Staff.HospitalID)
+		assert.Equal(t, []string{models.RoleNurse}, response.Data.Staff.Roles)
+
+		// Second login maps to the same staff member
+		w = login(t, auth.IDTokenClaims{Subject: "ad-1001", PreferredUsername: "renamed"})
+		assert.Equal(t, 200, w.Code)
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "nurse.joy", response.Data.Staff.Username)
+
+		// The provisioned account has no usable password
+		w = httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(models.StaffLoginRequest{Username: "nurse.joy", Password: "", HospitalID: 1})
+		req, _ := http.NewRequest("POST", "/staff/login", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.NotEqual(t, 200, w.Code)
+	})
+
+	// Test case 3: Existing staff are matched by verified email
+	t.Run("Link Existing Staff", func(t *testing.T) {
+		w := login(t, auth.IDTokenClaims{Subject: "ad-1002", Email: "test@example.com", EmailVerified: false, PreferredUsername: "testuser"})
+		assert.Equal(t, 409, w.Code)
+
+		w = login(t, auth.IDTokenClaims{Subject: "ad-1003", Email: "TEST@example.com", EmailVerified: true})
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.TokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "testuser", response.Data.Staff.Username)
+	})
+
+	// Test case 4: States cannot be forged, replayed or completed elsewhere
+	t.Run("Invalid State", func(t *testing.T) {
+		_, cookie := startLogin(t)
+		w := callback(models.OIDCCallbackRequest{Code: "abc", State: "forged"}, cookie)
+		assert.Equal(t, 401, w.Code)
+
+		authorizationURL, cookie := startLogin(t)
+		code, state, _ := idp.Authorize(authorizationURL, auth.IDTokenClaims{Subject: "ad-1001"})
+		w = callback(models.OIDCCallbackRequest{Code: code, State: state}, cookie)
+		assert.Equal(t, 200, w.Code)
+		w = callback(models.OIDCCallbackRequest{Code: code, State: state}, cookie)
+		assert.Equal(t, 401, w.Code)
+
+		w = callback(models.OIDCCallbackRequest{Error: "access_denied"}, cookie)
+		assert.Equal(t, 401, w.Code)
+
+		// An attacker's login cannot be completed in the victim's browser
+		authorizationURL, _ = startLogin(t)
+		_, victimCookie := startLogin(t)
+		code, state, _ = idp.Authorize(authorizationURL, auth.IDTokenClaims{Subject: "ad-1001"})
+		w = callback(models.OIDCCallbackRequest{Code: code, State: state}, victimCookie)
+		assert.Equal(t, 401, w.Code)
+
+		authorizationURL, _ = startLogin(t)
+		code, state, _ = idp.Authorize(authorizationURL, auth.IDTokenClaims{Subject: "ad-1001"})
+		w = callback(models.OIDCCallbackRequest{Code: code, State: state}, nil)
+		assert.Equal(t, 401, w.Code)
+
+		// The provider's keys were fetched once for all logins
+		assert.Equal(t, 1, idp.JWKSRequests())
+	})
+
+	// Test case 5: Without provisioning unknown identities are refused
+	t.Run("Provisioning Disabled", func(t *testing.T) {
+		db.Model(&models.IdentityProvider{}).Where("id = ?", providerID).Update("auto_provision", false)
+
+		w := login(t, auth.IDTokenClaims{Subject: "ad-2000", PreferredUsername: "stranger"})
+		assert.Equal(t, 403, w.Code)
+	})
+}
+
+// TestServiceIdentities tests managing client certificate identities
+func TestServiceIdentities(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	sendJSON := func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Issue a client certificate from a throwaway CA
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Hospital CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "lab-system", Organization: []string{"Hospital A"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &clientKey.PublicKey, caKey)
+	client, _ := x509.ParseCertificate(clientDER)
+
+	previousTLS := config.TLS
+	defer func() { config.TLS = previousTLS }()
+	config.TLS.ClientCAs = x509.NewCertPool()
+	config.TLS.ClientCAs.AddCert(ca)
+
+	verify := func(code string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(models.ServiceIdentityVerifyRequest{Code: code})
+		req, _ := http.NewRequest("POST", "/auth/client-certificate/verify", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{client},
+			VerifiedChains:   [][]*x509.Certificate{{client, ca}},
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	request := models.ServiceIdentityRequest{
+		Name:        "Lab system",
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER})),
+		Scopes:      []string{models.PermPatientRead},
+	}
+
+	w := sendJSON("POST", "/hospital/service-identities", request)
+	assert.Equal(t, 201, w.Code)
+
+	var response struct {
+		Data models.ServiceIdentityCreatedResponse `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, "CN=Hospital CA", response.Data.CertificateIssuer)
+	assert.Equal(t, "CN=lab-system,O=Hospital A", response.Data.CertificateSubject)
+	assert.Nil(t, response.Data.VerifiedAt)
+	assert.NotEmpty(t, response.Data.VerificationCode)
+
+	// The same certificate cannot be registered twice by a hospital
+	w = sendJSON("POST", "/hospital/service-identities", request)
+	assert.Equal(t, 409, w.Code)
+
+	// Only client certificates of a trusted CA are accepted
+	otherCAKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	selfSignedDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &otherCAKey.PublicKey, otherCAKey)
+	w = sendJSON("POST", "/hospital/service-identities", models.ServiceIdentityRequest{
+		Name:        "Lab system",
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: selfSignedDER})),
+		Scopes:      []string{models.PermPatientRead},
+	})
+	assert.Equal(t, 400, w.Code)
+
+	scopesRequest := request
+	scopesRequest.Scopes = []string{models.PermStaffManage}
+	w = sendJSON("POST", "/hospital/service-identities", scopesRequest)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"scopes","rule":"oneof"`)
+
+	piiRequest := request
+	piiRequest.Scopes = []string{models.PermPatientPII}
+	w = sendJSON("POST", "/hospital/service-identities", piiRequest)
+	assert.Equal(t, 403, w.Code)
+
+	// Malformed requests are rejected field by field
+	w = sendJSON("POST", "/hospital/service-identities", map[string]interface{}{"name": 42})
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"name","rule":"type"`)
+
+	// Another hospital registers the same certificate first
+	otherHospital := models.Hospital{Name: "Other Hospital", Location: "Elsewhere"}
+	db.Create(&otherHospital)
+	claim := models.ServiceIdentity{
+		HospitalID:           otherHospital.ID,
+		Name:                 "Claimed lab system",
+		CertificateIssuer:    response.Data.CertificateIssuer,
+		CertificateSubject:   response.Data.CertificateSubject,
+		VerificationCodeHash: auth.HashToken(config.Auth.TokenHashKey, "claim-code"),
+		Scopes:               models.PermPatientRead,
+	}
+	assert.NoError(t, db.Create(&claim).Error)
+
+	// The holder of the certificate confirms the identity with its code
+	assert.Equal(t, 401, verify("wrong-code").Code)
+	w = verify(response.Data.VerificationCode)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 401, verify(response.Data.VerificationCode).Code)
+
+	// The claim of the other hospital can no longer be verified
+	assert.Equal(t, 409, verify("claim-code").Code)
+
+	// Without a certificate nothing can be verified
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/client-certificate/verify", strings.NewReader(`{"code":"claim-code"}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	w = sendJSON("GET", "/hospital/service-identities", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "CN=lab-system,O=Hospital A")
+	assert.Contains(t, w.Body.String(), `"verified_at":"`)
+
+	w = sendJSON("DELETE", fmt.Sprintf("/hospital/service-identities/%d", response.Data.ID), nil)
+	assert.Equal(t, 200, w.Code)
+
+	w = sendJSON("DELETE", fmt.Sprintf("/hospital/service-identities/%d", response.Data.ID), nil)
+	assert.Equal(t, 404, w.Code)
+}
+
+// TestPatientCRUD tests creating, updating, deleting and restoring patients
+func TestPatientCRUD(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	sendAs := func(token string, method string, path string, body interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		var reader *bytes.Buffer
+		if raw, ok := body.(string); ok {
+			reader = bytes.NewBufferString(raw)
+		} else {
+			jsonBody, _ := json.Marshal(body)
+			reader = bytes.NewBuffer(jsonBody)
+		}
+		req, _ := http.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(ChangeReasonHeader, "Corrected at the patient's request")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	// Patients are registered by a registrar, who sees identifiers unmasked
+	send := func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+		return sendAs("registrar-token-12345", method, path, body)
+	}
+
+	type errorResponse struct {
+		Error   string              `json:"error"`
+		Details []models.FieldError `json:"details"`
+	}
+	detailFields := func(w *httptest.ResponseRecorder) []string {
+		var response errorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		fields := []string{}
+		for _, detail := range response.Details {
+			fields = append(fields, detail.Field)
+		}
+		return fields
+	}
+
+	newPatient := models.PatientRequest{
+		FirstNameTh: "สมศักดิ์",
+		LastNameTh:  "มั่นคง",
+		FirstNameEn: "Somsak",
+		LastNameEn:  "Mankong",
+		DateOfBirth: time.Date(1985, 3, 15, 0, 0, 0, 0, time.UTC),
+		NationalID:  "1234567890147",
+		PhoneNumber: "0891234569",
+		Email:       "somsak@example.com",
+		Gender:      "M",
+	}
+
+	var created models.PatientResponse
+
+	// Test case 1: Create
+	t.Run("Create", func(t *testing.T) {
+		w := send("POST", "/patient", newPatient)
+		assert.Equal(t, 201, w.Code)
+
+		var response struct {
+			Data models.PatientResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		created = response.Data
+		assert.NotZero(t, created.ID)
+		assert.Equal(t, "HN000001", created.PatientHN)
+
+		var patient models.Patient
+		tenant.AllHospitals(db).First(&patient, created.ID)
+		assert.Equal(t, uint(1), patient.HospitalID)
+
+		// Identifiers and contact details are encrypted at rest
+		var stored struct {
+			NationalID  string
+			PhoneNumber string
+			Email       string
+		}
+		db.Raw("SELECT national_id, phone_number, email FROM patients WHERE id = ?", created.ID).Scan(&stored)
+		for _, value := range []string{stored.NationalID, stored.PhoneNumber, stored.Email} {
+			assert.True(t, strings.HasPrefix(value, encryption.Prefix), value)
+		}
+		assert.NotContains(t, stored.Email, "somsak")
+
+		w = send("GET", fmt.Sprintf("/patient/%d", created.ID), nil)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	// Test case 2: Field-level validation errors
+	t.Run("Validation Errors", func(t *testing.T) {
+		w := send("POST", "/patient", models.PatientRequest{
+			FirstNameTh: "ทดสอบ",
+			DateOfBirth: time.Date(1985, 3, 15, 0, 0, 0, 0, time.UTC),
+			NationalID:  "12345",
+			Email:       "not-an-email",
+			Gender:      "X",
+		})
+		assert.Equal(t, 400, w.Code)
+		assert.ElementsMatch(t, []string{"last_name_th", "national_id", "email", "gender"}, detailFields(w))
+
+		// Neither a national ID nor a passport ID
+		request := newPatient
+		request.NationalID = ""
+		w = send("POST", "/patient", request)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []string{"national_id"}, detailFields(w))
+
+		w = send("POST", "/patient", `{"first_name_th": 42}`)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []string{"first_name_th"}, detailFields(w))
+
+		request = newPatient
+		request.NationalID = "1234567890155"
+		request.DateOfBirth = time.Now().Add(24 * time.Hour)
+		w = send("POST", "/patient", request)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []string{"date_of_birth"}, detailFields(w))
+
+		// A passport needs its issuing country and must match its format
+		request = newPatient
+		request.NationalID = ""
+		request.PassportID = "AA1234567"
+		w = send("POST", "/patient", request)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []string{"passport_country"}, detailFields(w))
+
+		request.PassportID = "123456789"
+		request.PassportCountry = "THA"
+		w = send("POST", "/patient", request)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []string{"passport_id"}, detailFields(w))
+
+		request.PhoneNumber = "12345"
+		request.PassportID = "aa 1234567"
+		w = send("POST", "/patient", request)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []string{"phone_number"}, detailFields(w))
+
+		request.PhoneNumber = "+66 2 123 4567"
+		w = send("POST", "/patient", request)
+		assert.Equal(t, 201, w.Code)
+		var response struct {
+			Data models.PatientResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "AA1234567", response.Data.PassportID)
+		assert.Equal(t, "+6621234567", response.Data.PhoneNumber)
+	})
+
+	// Test case 3: Duplicate identifiers
+	t.Run("Duplicate", func(t *testing.T) {
+		w := send("POST", "/patient", newPatient)
+		assert.Equal(t, 409, w.Code)
+		assert.Equal(t, []string{"national_id"}, detailFields(w))
+
+		// The database rejects duplicates too, except of deleted patients
+		duplicate := models.Patient{FirstNameTh: "ซ้ำ", NationalID: encryption.EncryptedString(newPatient.NationalID), HospitalID: 1}
+		assert.ErrorIs(t, db.Create(&duplicate).Error, gorm.ErrDuplicatedKey)
+		duplicate.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		assert.NoError(t, db.Create(&duplicate).Error)
+
+		// A patient registered while the request was checked is a conflict,
+		// not a failure
+		racing := models.Patient{NationalID: "1234567890155"}
+		racing.SetBlindIndexes()
+		db.Callback().Create().Before("gorm:create").Register("test:racing_registration", func(tx *gorm.DB) {
+			if tx.Statement.Table == "patients" {
+				tx.Session(&gorm.Session{NewDB: true}).Exec("INSERT INTO patients (national_id_index, hospital_id) VALUES (?, 1)", racing.NationalIDIndex)
+			}
+		})
+		request := newPatient
+		request.NationalID = "1234567890155"
+		w = send("POST", "/patient", request)
+		db.Callback().Create().Remove("test:racing_registration")
+		assert.Equal(t, 409, w.Code)
+	})
+
+	// Test case 4: PUT replaces, PATCH merges
+	t.Run("Update", func(t *testing.T) {
+		request := newPatient
+		request.PhoneNumber = "089-999-9999"
+		request.Email = ""
+		w := send("PUT", fmt.Sprintf("/patient/%d", created.ID), request)
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Data models.PatientResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "+66899999999", response.Data.PhoneNumber)
+		assert.Equal(t, "", response.Data.Email)
+
+		w = send("PATCH", fmt.Sprintf("/patient/%d", created.ID), `{"email": "new@example.com"}`)
+		assert.Equal(t, 200, w.Code)
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "new@example.com", response.Data.Email)
+		assert.Equal(t, "+66899999999", response.Data.PhoneNumber)
+		assert.Equal(t, "Somsak", response.Data.FirstNameEn)
+
+		w = send("PATCH", fmt.Sprintf("/patient/%d", created.ID), `{"gender": "Q"}`)
+		assert.Equal(t, 400, w.Code)
+
+		// Taking another patient's national ID
+		w = send("PATCH", fmt.Sprintf("/patient/%d", created.ID), `{"national_id": "1234567890121"}`)
+		assert.Equal(t, 409, w.Code)
+	})
+
+	// Test case 5: Soft delete and restore
+	t.Run("Delete And Restore", func(t *testing.T) {
+		path := fmt.Sprintf("/patient/%d", created.ID)
+
+		w := send("POST", path+"/restore", nil)
+		assert.Equal(t, 409, w.Code)
+
+		w = send("DELETE", path, nil)
+		assert.Equal(t, 200, w.Code)
+
+		w = send("GET", path, nil)
+		assert.Equal(t, 404, w.Code)
+
+		var count int64
+		tenant.AllHospitals(db).Unscoped().Model(&models.Patient{}).Where("id = ?", created.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+
+		w = send("POST", path+"/restore", nil)
+		assert.Equal(t, 200, w.Code)
+
+		w = send("GET", path, nil)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	// Test case 6: Patients of other hospitals are out of reach
+	t.Run("Other Hospital", func(t *testing.T) {
+		otherHospital := models.Hospital{Name: "Other Hospital", Location: "Elsewhere"}
+		db.Create(&otherHospital)
+		otherPatient := models.Patient{FirstNameTh: "ผู้ป่วย", NationalID: "9876543210989", HospitalID: otherHospital.ID}
+		db.Create(&otherPatient)
+
+		path := fmt.Sprintf("/patient/%d", otherPatient.ID)
+		assert.Equal(t, 404, send("GET", path, nil).Code)
+		assert.Equal(t, 404, send("PATCH", path, `{"email": "x@example.com"}`).Code)
+		assert.Equal(t, 404, send("DELETE", path, nil).Code)
+
+		// The same national ID may exist in another hospital
+		request := newPatient
+		request.NationalID = "9876543210989"
+		assert.Equal(t, 201, send("POST", "/patient", request).Code)
+	})
+
+	// Test case 7: Read-only staff cannot write
+	t.Run("Permission Denied", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(newPatient)
+		req, _ := http.NewRequest("POST", "/patient", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer norole-token-12345")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	// Test case 8: Identifiers are masked without patient:pii and revealed
+	// with a reason
+	t.Run("Masking And Reveal", func(t *testing.T) {
+		path := fmt.Sprintf("/patient/%d", created.ID)
+		var response struct {
+			Data models.PatientResponse `json:"data"`
+		}
+
+		// The admin has patient:reveal but not patient:pii
+		w := sendAs("test-token-12345", "GET", path, nil)
+		assert.Equal(t, 200, w.Code)
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.True(t, response.Data.Masked)
+		assert.Equal(t, "1-xxxx-xxxxx-14-7", response.Data.NationalID)
+		assert.Equal(t, "+66xxxxx9999", response.Data.PhoneNumber)
+		assert.Equal(t, "n***@example.com", response.Data.Email)
+		assert.Equal(t, "Somsak", response.Data.FirstNameEn)
+
+		w = sendAs("test-token-12345", "GET", "/patient/search?national_id=1234567890147", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "1-xxxx-xxxxx-14-7")
+		assert.NotContains(t, w.Body.String(), "1234567890147")
+
+		w = send("GET", path, nil)
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.False(t, response.Data.Masked)
+		assert.Equal(t, "1234567890147", response.Data.NationalID)
+
+		// Revealing needs a reason
+		w = sendAs("test-token-12345", "POST", path+"/reveal", `{}`)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []string{"reason"}, detailFields(w))
+		w = sendAs("test-token-12345", "POST", path+"/reveal", `{"reason": "check"}`)
+		assert.Equal(t, 400, w.Code)
+
+		reason := "Confirming identity for insurance claim"
+		w = sendAs("test-token-12345", "POST", path+"/reveal", map[string]string{"reason": reason})
+		assert.Equal(t, 200, w.Code)
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.False(t, response.Data.Masked)
+		assert.Equal(t, "1234567890147", response.Data.NationalID)
+		assert.Equal(t, "new@example.com", response.Data.Email)
+
+		var event models.SecurityEvent
+		assert.NoError(t, db.Where("type = ?", models.SecurityEventPatientReveal).Last(&event).Error)
+		assert.Contains(t, event.Detail, reason)
+		assert.Contains(t, event.Detail, fmt.Sprintf("patient %d", created.ID))
+		assert.NotNil(t, event.ActorStaffID)
+
+		// Reveal is a separate permission
+		assert.Equal(t, 403, send("POST", path+"/reveal", map[string]string{"reason": reason}).Code)
+		assert.Equal(t, 403, sendAs("norole-token-12345", "POST", path+"/reveal", map[string]string{"reason": reason}).Code)
+		assert.Equal(t, 404, sendAs("test-token-12345", "POST", "/patient/99999/reveal", map[string]string{"reason": reason}).Code)
+	})
+}
+
+// TestHNGeneration tests HN formats, allocation and lookup by HN
+func TestHNGeneration(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	send := func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token-12345")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	createPatient := func(nationalID string) models.PatientResponse {
+		w := send("POST", "/patient", models.PatientRequest{
+			FirstNameTh: "ทดสอบ",
+			LastNameTh:  "ระบบ",
+			DateOfBirth: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+			NationalID:  nationalID,
+		})
+		assert.Equal(t, 201, w.Code)
+
+		var response struct {
+			Data models.PatientResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Data
+	}
+
+	// Test case 1: Format
+	t.Run("Format", func(t *testing.T) {
+		hospital := models.Hospital{HNPrefix: "HN", HNYear: models.HNYearBE, HNDigits: 6, HNCheckDigit: true}
+		assert.Equal(t, "HN690000013", hospital.FormatHN(2569, 1))
+		assert.Equal(t, 2569, hospital.HNSequenceYear(time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)))
+		// 31 December 2025 20:00 UTC is already 2026 in Bangkok
+		assert.Equal(t, 2569, hospital.HNSequenceYear(time.Date(2025, 12, 31, 20, 0, 0, 0, time.UTC)))
+
+		hospital = models.Hospital{HNPrefix: "", HNDigits: 4}
+		assert.Equal(t, "0042", hospital.FormatHN(hospital.HNSequenceYear(time.Now()), 42))
+		assert.Equal(t, "12345", hospital.FormatHN(0, 12345))
+
+		hospital = models.Hospital{HNPrefix: "HN", HNYear: models.HNYearBE, HNDigits: 6, HNCheckDigit: true}
+		sequence, ok := hospital.ParseHN(2569, "HN690000013")
+		assert.True(t, ok)
+		assert.Equal(t, uint(1), sequence)
+		for _, hn := range []string{"HN690000012", "HN680000013", "XX690000013", "HN001"} {
+			_, ok := hospital.ParseHN(2569, hn)
+			assert.False(t, ok, hn)
+		}
+	})
+
+	// Test case 2: Sequential HNs in the default format
+	t.Run("Default Format", func(t *testing.T) {
+		assert.Equal(t, "HN000001", createPatient("1101700203514").PatientHN)
+		assert.Equal(t, "HN000002", createPatient("1234567890147").PatientHN)
+	})
+
+	// Test case 3: Changing the format
+	t.Run("Change Format", func(t *testing.T) {
+		w := send("PUT", "/hospital/hn-format", models.HospitalHNFormatRequest{Prefix: "A", Year: "AD", Digits: 5})
+		assert.Equal(t, 400, w.Code)
+
+		w = send("PUT", "/hospital/hn-format", models.HospitalHNFormatRequest{Prefix: "A", Year: models.HNYearBE, Digits: 5, CheckDigit: true})
+		assert.Equal(t, 200, w.Code)
+
+		var hospital models.Hospital
+		db.First(&hospital, 1)
+		year := hospital.HNSequenceYear(time.Now())
+		assert.Equal(t, hospital.FormatHN(year, 1), createPatient("1234567890155").PatientHN)
+
+		w = send("GET", "/hospital/hn-format", nil)
+		assert.Equal(t, 200, w.Code)
+		var response struct {
+			Data models.HospitalHNFormatResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, hospital.FormatHN(year, 1), response.Data.Example)
+	})
+
+	// Test case 4: No gaps, per hospital and per year
+	t.Run("Allocation", func(t *testing.T) {
+		var hospital models.Hospital
+		db.First(&hospital, 1)
+		now := time.Now()
+		year := hospital.HNSequenceYear(now)
+
+		// A rolled back registration gives its number back
+		db.Transaction(func(tx *gorm.DB) error {
+			hn, err := allocateHN(tx, hospital.ID, now)
+			assert.NoError(t, err)
+			assert.Equal(t, hospital.FormatHN(year, 2), hn)
+			return fmt.Errorf("registration failed")
+		})
+		db.Transaction(func(tx *gorm.DB) error {
+			hn, _ := allocateHN(tx, hospital.ID, now)
+			assert.Equal(t, hospital.FormatHN(year, 2), hn)
+			return nil
+		})
+
+		// Next year starts again from 1
+		db.Transaction(func(tx *gorm.DB) error {
+			hn, _ := allocateHN(tx, hospital.ID, now.AddDate(1, 0, 0))
+			assert.Equal(t, hospital.FormatHN(year+1, 1), hn)
+			return nil
+		})
+
+		otherHospital := models.Hospital{Name: "Other Hospital", Location: "Elsewhere"}
+		db.Create(&otherHospital)
+		db.Transaction(func(tx *gorm.DB) error {
+			hn, _ := allocateHN(tx, otherHospital.ID, now)
+			assert.Equal(t, "HN000001", hn)
+			return nil
+		})
+	})
+
+	// Test case 5: Lookup by HN
+	t.Run("Lookup", func(t *testing.T) {
+		w := send("GET", "/patient/hn/HN000002", nil)
+		assert.Equal(t, 200, w.Code)
+		var response struct {
+			Data models.PatientResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "1-xxxx-xxxxx-14-7", response.Data.NationalID)
+		assert.True(t, response.Data.Masked)
+
+		w = send("GET", "/patient/hn/HN999999", nil)
+		assert.Equal(t, 404, w.Code)
+	})
+
+	// Test case 6: HNs given out before allocation are not handed out again
+	t.Run("Legacy HNs", func(t *testing.T) {
+		hospital := models.Hospital{Name: "Legacy Hospital", Location: "Elsewhere"}
+		db.Create(&hospital)
+		for _, hn := range []string{"HN000005", "HN000003", "H-17"} {
+			assert.NoError(t, db.Create(&models.Patient{FirstNameTh: "เก่า", PatientHN: hn, HospitalID: hospital.ID}).Error)
+		}
+
+		// A new sequence starts after the highest HN in its format
+		db.Transaction(func(tx *gorm.DB) error {
+			hn, err := allocateHN(tx, hospital.ID, time.Now())
+			assert.NoError(t, err)
+			assert.Equal(t, "HN000006", hn)
+			return nil
+		})
+
+		// and numbers taken since are skipped
+		assert.NoError(t, db.Create(&models.Patient{FirstNameTh: "เก่า", PatientHN: "HN000007", HospitalID: hospital.ID}).Error)
+		db.Transaction(func(tx *gorm.DB) error {
+			hn, err := allocateHN(tx, hospital.ID, time.Now())
+			assert.NoError(t, err)
+			assert.Equal(t, "HN000008", hn)
+			return nil
+		})
+	})
+
+	// Test case 7: HNs are unique within a hospital
+	t.Run("Unique", func(t *testing.T) {
+		err := db.Create(&models.Patient{FirstNameTh: "ซ้ำ", PatientHN: "HN000001", HospitalID: 1}).Error
+		assert.Error(t, err)
+
+		// but may repeat in another hospital, and legacy rows without an HN
+		// do not clash
+		assert.NoError(t, db.Create(&models.Patient{FirstNameTh: "อื่น", PatientHN: "HN000001", HospitalID: 2}).Error)
+		assert.NoError(t, db.Create(&models.Patient{FirstNameTh: "เก่า", HospitalID: 1}).Error)
+		assert.NoError(t, db.Create(&models.Patient{FirstNameTh: "เก่า", HospitalID: 1}).Error)
+	})
+}
+
+// TestAuditLog tests that patient access is recorded and can be queried
+// and verified
+func TestAuditLog(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	sendAs := func(token string, method string, path string, body interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(ChangeReasonHeader, "Corrected at the patient's request")
+		req.RemoteAddr = "10.1.2.3:4567"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	type auditPage struct {
+		Data       []models.AuditEntry `json:"data"`
+		Pagination models.Pagination   `json:"pagination"`
+	}
+	listAudit := func(query string) auditPage {
+		w := sendAs("test-token-12345", "GET", "/audit"+query, nil)
+		assert.Equal(t, 200, w.Code)
+		var page auditPage
+		json.Unmarshal(w.Body.Bytes(), &page)
+		return page
+	}
+	verify := func() models.AuditVerification {
+		w := sendAs("test-token-12345", "GET", "/audit/verify", nil)
+		assert.Equal(t, 200, w.Code)
+		var response struct {
+			Data models.AuditVerification `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Data
+	}
+
+	var staff, registrar models.Staff
+	db.Where("username = ?", "testuser").First(&staff)
+	db.Where("username = ?", "registraruser").First(&registrar)
+	var somchai models.Patient
+	tenant.AllHospitals(db).Where("patient_hn = ?", "HN001").First(&somchai)
+
+	// Test case 1: Reads are recorded with the caller, patients and filters
+	t.Run("Reads", func(t *testing.T) {
+		w := sendAs("registrar-token-12345", "GET", "/patient/search?last_name=Jaidee&national_id=1234567890121", nil)
+		assert.Equal(t, 200, w.Code)
+		w = sendAs("test-token-12345", "GET", fmt.Sprintf("/patient/%d", somchai.ID), nil)
+		assert.Equal(t, 200, w.Code)
+		w = sendAs("test-token-12345", "GET", "/patient/search/1234567890121", nil)
+		assert.Equal(t, 200, w.Code)
+		w = sendAs("test-token-12345", "GET", "/patient/hn/HN001", nil)
+		assert.Equal(t, 200, w.Code)
+		// A search without results is recorded too
+		w = sendAs("test-token-12345", "GET", "/patient/search?last_name=Nobody", nil)
+		assert.Equal(t, 200, w.Code)
+
+		page := listAudit("?sort=sequence")
+		if !assert.Len(t, page.Data, 5) {
+			return
+		}
+
+		search := page.Data[0]
+		assert.Equal(t, uint64(1), search.Sequence)
+		assert.Equal(t, models.AuditPatientSearch, search.Action)
+		assert.Equal(t, models.StaffActor(registrar.ID), search.Actor)
+		if assert.NotNil(t, search.StaffID) {
+			assert.Equal(t, registrar.ID, *search.StaffID)
+		}
+		assert.Equal(t, []uint{somchai.ID}, search.PatientIDs)
+		assert.Equal(t, "10.1.2.3", search.ClientIP)
+		assert.Equal(t, "Jaidee", search.Filters["last_name"])
+		// Identifiers are not copied into the log
+		assert.Equal(t, "1-xxxx-xxxxx-12-1", search.Filters["national_id"])
+
+		assert.Equal(t, models.AuditPatientRead, page.Data[1].Action)
+		assert.Equal(t, []uint{somchai.ID}, page.Data[1].PatientIDs)
+		assert.Equal(t, "1-xxxx-xxxxx-12-1", page.Data[2].Filters["id"])
+		assert.Equal(t, models.AuditPatientRead, page.Data[3].Action)
+		assert.Empty(t, page.Data[4].PatientIDs)
+		assert.Equal(t, search.Hash, page.Data[1].PrevHash)
+	})
+
+	// Test case 2: Writes and reveals are recorded
+	t.Run("Writes", func(t *testing.T) {
+		w := sendAs("registrar-token-12345", "POST", "/patient", models.PatientRequest{
+			FirstNameTh: "ทดสอบ",
+			LastNameTh:  "บันทึก",
+			DateOfBirth: time.Date(1985, 3, 1, 0, 0, 0, 0, time.UTC),
+			NationalID:  "1234567890147",
+		})
+		assert.Equal(t, 201, w.Code)
+		var response struct {
+			Data models.PatientResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		path := fmt.Sprintf("/patient/%d", response.Data.ID)
+
+		assert.Equal(t, 200, sendAs("registrar-token-12345", "PATCH", path, map[string]string{"last_name_th": "แก้ไข"}).Code)
+		assert.Equal(t, 200, sendAs("test-token-12345", "POST", path+"/reveal", map[string]string{"reason": "Confirming identity for a claim"}).Code)
+		assert.Equal(t, 200, sendAs("registrar-token-12345", "DELETE", path, nil).Code)
+		assert.Equal(t, 200, sendAs("registrar-token-12345", "POST", path+"/restore", nil).Code)
+
+		page := listAudit(fmt.Sprintf("?patient_id=%d", response.Data.ID))
+		actions := []string{}
+		for _, entry := range page.Data {
+			actions = append(actions, entry.Action)
+		}
+		// Newest first
+		assert.Equal(t, []string{
+			models.AuditPatientRestore,
+			models.AuditPatientDelete,
+			models.AuditPatientReveal,
+			models.AuditPatientUpdate,
+			models.AuditPatientCreate,
+		}, actions)
+		assert.Equal(t, "Confirming identity for a claim", page.Data[2].Reason)
+		assert.Equal(t, "Corrected at the patient's request", page.Data[1].Reason)
+
+		// A write that fails records nothing
+		before := listAudit("").Pagination.Total
+		w = sendAs("registrar-token-12345", "POST", "/patient", models.PatientRequest{
+			FirstNameTh: "ซ้ำ",
+			LastNameTh:  "ซ้ำ",
+			DateOfBirth: time.Date(1985, 3, 1, 0, 0, 0, 0, time.UTC),
+			NationalID:  "1234567890147",
+		})
+		assert.Equal(t, 409, w.Code)
+		assert.Equal(t, before, listAudit("").Pagination.Total)
+	})
+
+	// Test case 3: Query filters
+	t.Run("Query", func(t *testing.T) {
+		page := listAudit(fmt.Sprintf("?staff_id=%d&action=%s", registrar.ID, models.AuditPatientSearch))
+		assert.Len(t, page.Data, 1)
+
+		page = listAudit("?actor=" + models.StaffActor(staff.ID))
+		assert.Equal(t, int64(5), page.Pagination.Total)
+
+		page = listAudit(fmt.Sprintf("?patient_id=%d&limit=2", somchai.ID))
+		assert.Equal(t, int64(4), page.Pagination.Total)
+		assert.Len(t, page.Data, 2)
+		assert.True(t, page.Pagination.HasMore)
+
+		page = listAudit("?from=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+		assert.Empty(t, page.Data)
+		page = listAudit("?to=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+		assert.Equal(t, int64(10), page.Pagination.Total)
+
+		w := sendAs("test-token-12345", "GET", "/audit?from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z", nil)
+		assert.Equal(t, 400, w.Code)
+		var rejected struct {
+			Details []models.FieldError `json:"details"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &rejected)
+		assert.Equal(t, []models.FieldError{{Field: "to", Rule: "gtfield", Param: "from", Message: "must be after from"}}, rejected.Details)
+
+		// Compliance needs audit:read
+		assert.Equal(t, 403, sendAs("registrar-token-12345", "GET", "/audit", nil).Code)
+		assert.Equal(t, 403, sendAs("registrar-token-12345", "GET", "/audit/verify", nil).Code)
+		assert.Contains(t, models.DefaultRolePermissions[models.RoleCompliance], models.PermAuditRead)
+	})
+
+	// Test case 4: The hash chain detects tampering
+	t.Run("Verify", func(t *testing.T) {
+		result := verify()
+		assert.True(t, result.Valid)
+		assert.Equal(t, uint64(10), result.Entries)
+		assert.NotEmpty(t, result.HeadHash)
+
+		db.Exec("UPDATE audit_entries SET actor = 'staff:999' WHERE sequence = 3")
+		result = verify()
+		assert.False(t, result.Valid)
+		if assert.NotNil(t, result.BrokenAt) {
+			assert.Equal(t, uint64(3), *result.BrokenAt)
+		}
+	})
+}
+
+// TestPatientRevisions tests the revision history of patients
+func TestPatientRevisions(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	router := SetupRouter()
+
+	sendAs := func(token string, method string, path string, body interface{}, reason string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		if reason != "" {
+			req.Header.Set(ChangeReasonHeader, reason)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	send := func(method string, path string, body interface{}, reason string) *httptest.ResponseRecorder {
+		return sendAs("registrar-token-12345", method, path, body, reason)
+	}
+	// Revisions are ordered by time, which must move on between changes
+	tick := func() time.Time {
+		time.Sleep(5 * time.Millisecond)
+		at := time.Now()
+		time.Sleep(5 * time.Millisecond)
+		return at
+	}
+	atQuery := func(at time.Time) string {
+		return url.QueryEscape(at.UTC().Format(time.RFC3339Nano))
+	}
+
+	var registrar models.Staff
+	db.Where("username = ?", "registraruser").First(&registrar)
+
+	beforeCreate := tick()
+	w := send("POST", "/patient", models.PatientRequest{
+		FirstNameTh: "มาลี",
+		LastNameTh:  "ศรีสุข",
+		LastNameEn:  "Srisuk",
+		DateOfBirth: time.Date(1991, 7, 15, 0, 0, 0, 0, time.UTC),
+		NationalID:  "1234567890147",
+		PhoneNumber: "0812345678",
+	}, "Walk-in registration")
+	assert.Equal(t, 201, w.Code)
+	var created struct {
+		Data models.PatientResponse `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	path := fmt.Sprintf("/patient/%d", created.Data.ID)
+
+	afterCreate := tick()
+	w = send("PATCH", path, map[string]string{"last_name_en": "Wongsuk", "phone_number": "0898765432"}, "Married, new phone")
+	assert.Equal(t, 200, w.Code)
+	// Saving the same values again is not a revision
+	w = send("PUT", path, models.PatientRequest{
+		FirstNameTh: "มาลี",
+		LastNameTh:  "ศรีสุข",
+		LastNameEn:  "Wongsuk",
+		DateOfBirth: time.Date(1991, 7, 15, 0, 0, 0, 0, time.UTC),
+		NationalID:  "1234567890147",
+		PhoneNumber: "0898765432",
+	}, "Resubmitted the same form")
+	assert.Equal(t, 200, w.Code)
+
+	afterUpdate := tick()
+	assert.Equal(t, 200, send("DELETE", path, nil, "Duplicate registration").Code)
+	afterDelete := tick()
+	assert.Equal(t, 200, send("POST", path+"/restore", nil, "Not a duplicate").Code)
+
+	// Test case 1: List revisions
+	t.Run("List", func(t *testing.T) {
+		w := send("GET", path+"/revisions", nil, "")
+		assert.Equal(t, 200, w.Code)
+		var response struct {
+			Data       []models.PatientRevision `json:"data"`
+			Pagination models.Pagination        `json:"pagination"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if !assert.Len(t, response.Data, 4) {
+			return
+		}
+		assert.Equal(t, int64(4), response.Pagination.Total)
+
+		restore, del, update, create := response.Data[0], response.Data[1], response.Data[2], response.Data[3]
+		assert.Equal(t, uint(4), restore.Version)
+		assert.Equal(t, models.AuditPatientRestore, restore.Action)
+		assert.Equal(t, "Not a duplicate", restore.Reason)
+		assert.Equal(t, models.AuditPatientDelete, del.Action)
+		assert.Equal(t, "Duplicate registration", del.Reason)
+
+		assert.Equal(t, uint(2), update.Version)
+		assert.Equal(t, models.AuditPatientUpdate, update.Action)
+		assert.Equal(t, []string{"last_name_en", "phone_number"}, update.ChangedFields)
+		assert.Equal(t, "Married, new phone", update.Reason)
+		assert.Equal(t, models.StaffActor(registrar.ID), update.Actor)
+		if assert.NotNil(t, update.StaffID) {
+			assert.Equal(t, registrar.ID, *update.StaffID)
+		}
+
+		assert.Equal(t, uint(1), create.Version)
+		assert.Equal(t, models.AuditPatientCreate, create.Action)
+		assert.Contains(t, create.ChangedFields, "national_id")
+		assert.Contains(t, create.ChangedFields, "patient_hn")
+		assert.NotContains(t, create.ChangedFields, "email")
+
+		// Snapshots hold identifiers and are stored encrypted
+		var raw string
+		db.Raw("SELECT snapshot FROM patient_revisions WHERE patient_id = ? AND version = 1", created.Data.ID).Scan(&raw)
+		assert.True(t, strings.HasPrefix(raw, encryption.Prefix))
+		assert.NotContains(t, w.Body.String(), "1234567890147")
+
+		assert.Equal(t, 404, send("GET", "/patient/99999/revisions", nil, "").Code)
+		assert.Equal(t, 403, sendAs("norole-token-12345", "GET", path+"/revisions", nil, "").Code)
+	})
+
+	// Test case 2: Diff two revisions
+	t.Run("Diff", func(t *testing.T) {
+		type diffResponse struct {
+			Data struct {
+				From    uint                 `json:"from"`
+				To      uint                 `json:"to"`
+				Changes []models.FieldChange `json:"changes"`
+				Masked  bool                 `json:"masked"`
+			} `json:"data"`
+		}
+
+		w := send("GET", path+"/revisions/diff?from=1&to=2", nil, "")
+		assert.Equal(t, 200, w.Code)
+		var response diffResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.False(t, response.Data.Masked)
+		assert.Equal(t, []models.FieldChange{
+			{Field: "last_name_en", From: "Srisuk", To: "Wongsuk"},
+			{Field: "phone_number", From: "+66812345678", To: "+66898765432"},
+		}, response.Data.Changes)
+
+		// Without patient:pii identifiers are masked
+		w = sendAs("test-token-12345", "GET", path+"/revisions/diff?from=1&to=2", nil, "")
+		assert.Equal(t, 200, w.Code)
+		response = diffResponse{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.True(t, response.Data.Masked)
+		assert.Equal(t, models.FieldChange{Field: "phone_number", From: "+66xxxxx5678", To: "+66xxxxx5432"}, response.Data.Changes[1])
+
+		// Delete and restore change no fields
+		w = send("GET", path+"/revisions/diff?from=2&to=4", nil, "")
+		response = diffResponse{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Empty(t, response.Data.Changes)
+
+		assert.Equal(t, 404, send("GET", path+"/revisions/diff?from=1&to=9", nil, "").Code)
+		assert.Equal(t, 400, send("GET", path+"/revisions/diff?from=1", nil, "").Code)
+	})
+
+	// Test case 3: Read the record as of a time
+	t.Run("As Of", func(t *testing.T) {
+		asOf := func(at time.Time) (int, models.PatientResponse, models.PatientRevision) {
+			w := send("GET", path+"/as-of?at="+atQuery(at), nil, "")
+			var response struct {
+				Data     models.PatientResponse `json:"data"`
+				Revision models.PatientRevision `json:"revision"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			return w.Code, response.Data, response.Revision
+		}
+
+		code, _, _ := asOf(beforeCreate)
+		assert.Equal(t, 404, code)
+
+		code, patient, revision := asOf(afterCreate)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, uint(1), revision.Version)
+		assert.Equal(t, "Srisuk", patient.LastNameEn)
+		assert.Equal(t, "+66812345678", patient.PhoneNumber)
+		assert.Equal(t, created.Data.ID, patient.ID)
+		assert.Equal(t, created.Data.PatientHN, patient.PatientHN)
+
+		code, patient, revision = asOf(afterUpdate)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, uint(2), revision.Version)
+		assert.Equal(t, "Wongsuk", patient.LastNameEn)
+
+		code, _, _ = asOf(afterDelete)
+		assert.Equal(t, 404, code)
+
+		code, patient, revision = asOf(time.Now())
+		assert.Equal(t, 200, code)
+		assert.Equal(t, uint(4), revision.Version)
+		assert.Equal(t, "Wongsuk", patient.LastNameEn)
+
+		// Masked like any patient response
+		w := sendAs("test-token-12345", "GET", path+"/as-of?at="+atQuery(afterCreate), nil, "")
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "1-xxxx-xxxxx-14-7")
+		assert.NotContains(t, w.Body.String(), "1234567890147")
+
+		assert.Equal(t, 400, send("GET", path+"/as-of", nil, "").Code)
+		assert.Equal(t, 400, send("GET", path+"/as-of?at=yesterday", nil, "").Code)
+	})
+
+	// Test case 4: Reasons are audited and bounded
+	t.Run("Reasons", func(t *testing.T) {
+		var entry models.AuditEntry
+		tenant.AllHospitals(db).Where("action = ?", models.AuditPatientUpdate).Order("sequence").First(&entry)
+		assert.Equal(t, "Married, new phone", entry.Reason)
+
+		var count int64
+		tenant.AllHospitals(db).Model(&models.AuditEntry{}).Where("action = ?", models.AuditPatientHistory).Count(&count)
+		assert.NotZero(t, count)
+
+		w := send("PATCH", path, map[string]string{"last_name_en": "Other"}, strings.Repeat("x", 501))
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), ChangeReasonHeader)
+
+		// Changes, deletes and restores need a reason
+		w = send("PATCH", path, map[string]string{"last_name_en": "Other"}, "")
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), `"rule":"required"`)
+		w = send("DELETE", path, nil, "   ")
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), `"rule":"required"`)
+		w = send("POST", path+"/restore", nil, "typo")
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), `"rule":"min","param":"10"`)
+		var stored models.Patient
+		tenant.AllHospitals(db).First(&stored, created.Data.ID)
+		assert.Equal(t, "Wongsuk", stored.LastNameEn)
+	})
 }
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/tenant"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// allocateHN hands out the next HN of a hospital. It must run in the
+// transaction that creates the patient: the sequence row stays locked until
+// the transaction ends, so concurrent registrations wait for each other, and
+// a registration that rolls back returns its number. HNs are therefore
+// unique and without gaps, apart from numbers already taken by patients
+// registered before HNs were allocated, which are skipped.
+func allocateHN(tx *gorm.DB, hospitalID uint, now time.Time) (string, error) {
+	var hospital models.Hospital
+	if err := tx.First(&hospital, hospitalID).Error; err != nil {
+		return "", err
+	}
+
+	sequence := models.HNSequence{HospitalID: hospital.ID, Year: hospital.HNSequenceYear(now)}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&sequence)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 1 {
+		if err := seedHNSequence(tx, hospital, sequence); err != nil {
+			return "", err
+		}
+	}
+
+	current := tx.Model(&models.HNSequence{}).Where("hospital_id = ? AND year = ?", sequence.HospitalID, sequence.Year)
+	for {
+		if err := current.Update("value", gorm.Expr("value + 1")).Error; err != nil {
+			return "", err
+		}
+		if err := tx.Where("hospital_id = ? AND year = ?", sequence.HospitalID, sequence.Year).First(&sequence).Error; err != nil {
+			return "", err
+		}
+
+		hn := hospital.FormatHN(sequence.Year, sequence.Value)
+		var taken int64
+		if err := tenant.AllHospitals(tx).Unscoped().Model(&models.Patient{}).
+			Where("hospital_id = ? AND patient_hn = ?", hospital.ID, hn).
+			Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return hn, nil
+		}
+	}
+}
+
+// seedHNSequence starts a new sequence after the highest HN in its format
+// and year that the hospital's patients already have, so that the first
+// numbers do not run into HNs given out before.
+func seedHNSequence(tx *gorm.DB, hospital models.Hospital, sequence models.HNSequence) error {
+	var hns []string
+	if err := tenant.AllHospitals(tx).Unscoped().Model(&models.Patient{}).
+		Where("hospital_id = ? AND patient_hn LIKE ?", hospital.ID, hospital.HNPrefix+"%").
+		Pluck("patient_hn", &hns).Error; err != nil {
+		return err
+	}
+
+	var highest uint
+	for _, hn := range hns {
+		if value, ok := hospital.ParseHN(sequence.Year, hn); ok && value > highest {
+			highest = value
+		}
+	}
+	if highest == 0 {
+		return nil
+	}
+	return tx.Model(&models.HNSequence{}).
+		Where("hospital_id = ? AND year = ?", sequence.HospitalID, sequence.Year).
+		Update("value", highest).Error
+}
+
+// GetPatientByHN looks up a patient of the caller's hospital by HN.
+func GetPatientByHN(c *gin.Context) {
+	var patient models.Patient
+	if err := tenantDB(c).Where("patient_hn = ?", c.Param("hn")).First(&patient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "Patient not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to load patient"})
+		return
+	}
+	if !recordAccess(c, auditEntry(c, models.AuditPatientRead, []uint{patient.ID})) {
+		return
+	}
+
+	c.JSON(200, gin.H{"data": patientResponse(c, &patient)})
+}
+
+// GetHospitalHNFormat shows the HN format of the caller's hospital.
+func GetHospitalHNFormat(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	var hospital models.Hospital
+	if err := config.DB.First(&hospital, hospitalID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Hospital not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": hospital.HNFormatResponse()})
+}
+
+// SetHospitalHNFormat changes the format of the HNs the caller's hospital
+// hands out from now on. Existing HNs keep their format.
+func SetHospitalHNFormat(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var request models.HospitalHNFormatRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	var hospital models.Hospital
+	if err := config.DB.First(&hospital, hospitalID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Hospital not found"})
+		return
+	}
+
+	if err := config.DB.Model(&hospital).Updates(map[string]interface{}{
+		"hn_prefix":      request.Prefix,
+		"hn_year":        request.Year,
+		"hn_digits":      request.Digits,
+		"hn_check_digit": request.CheckDigit,
+	}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update hospital"})
+		return
+	}
+
+	response := hospital.HNFormatResponse()
+	recordSecurityEvent(models.SecurityEvent{
+		Type:         models.SecurityEventHospitalHN,
+		HospitalID:   hospital.ID,
+		ActorStaffID: &actorID,
+		ClientIP:     c.ClientIP(),
+		Detail:       "HN format " + response.Example,
+	})
+
+	c.JSON(200, gin.H{"data": response})
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func userAttemptKey(hospitalID uint, username string) string {
+	return fmt.Sprintf("user:%d:%s", hospitalID, strings.ToLower(username))
+}
+
+func ipAttemptKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+// loginLockedUntil returns the latest lock expiry among the given attempt
+// keys, or nil if none of them is locked.
+func loginLockedUntil(keys ...string) *time.Time {
+	var attempts []models.LoginAttempt
+	config.DB.Where("attempt_key IN ?", keys).Find(&attempts)
+
+	now := time.Now()
+	var lockedUntil *time.Time
+	for _, attempt := range attempts {
+		if attempt.IsLocked(now) && (lockedUntil == nil || attempt.LockedUntil.After(*lockedUntil)) {
+			lockedUntil = attempt.LockedUntil
+		}
+	}
+	return lockedUntil
+}
+
+// recordLoginFailure counts a failed login against key and locks the key
+// once threshold is reached. It reports whether this failure locked it.
+func recordLoginFailure(key string, threshold int) (bool, error) {
+	now := time.Now()
+
+	var attempt models.LoginAttempt
+	if err := config.DB.Where(models.LoginAttempt{AttemptKey: key}).FirstOrCreate(&attempt).Error; err != nil {
+		return false, err
+	}
+
+	if attempt.Failures > 0 && attempt.LastFailedAt.Before(now.Add(-config.Auth.LockoutWindow)) && !attempt.IsLocked(now) {
+		if err := config.DB.Model(&attempt).Update("failures", 0).Error; err != nil {
+			return false, err
+		}
+	}
+
+	if err := config.DB.Model(&attempt).Updates(map[string]interface{}{
+		"failures":       gorm.Expr("failures + 1"),
+		"last_failed_at": now,
+	}).Error; err != nil {
+		return false, err
+	}
+	if err := config.DB.First(&attempt, attempt.ID).Error; err != nil {
+		return false, err
+	}
+
+	if attempt.Failures < threshold {
+		return false, nil
+	}
+
+	lockedUntil := now.Add(lockoutDuration(attempt.Failures - threshold))
+	if err := config.DB.Model(&attempt).Update("locked_until", lockedUntil).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lockoutDuration doubles the base lockout for every failure past the
+// threshold, capped at the configured maximum.
+func lockoutDuration(failuresPastThreshold int) time.Duration {
+	duration := float64(config.Auth.LockoutBase) * math.Pow(2, float64(failuresPastThreshold))
+	if duration > float64(config.Auth.LockoutMax) {
+		return config.Auth.LockoutMax
+	}
+	return time.Duration(duration)
+}
+
+// registerLoginFailure updates the per-username and per-IP counters of a
+// failed login and audits any lock it causes.
+func registerLoginFailure(c *gin.Context, request models.StaffLoginRequest) {
+	thresholds := map[string]int{
+		userAttemptKey(request.HospitalID, request.Username): config.Auth.LockoutThreshold,
+		ipAttemptKey(c.ClientIP()):                           config.Auth.IPLockoutThreshold,
+	}
+
+	for key, threshold := range thresholds {
+		locked, err := recordLoginFailure(key, threshold)
+		if err != nil || !locked {
+			continue
+		}
+
+		recordSecurityEvent(models.SecurityEvent{
+			Type:       models.SecurityEventLoginLocked,
+			HospitalID: request.HospitalID,
+			Username:   request.Username,
+			ClientIP:   c.ClientIP(),
+			Detail:     key,
+		})
+	}
+}
+
+func clearLoginFailures(hospitalID uint, username string) error {
+	return config.DB.Unscoped().
+		Where("attempt_key = ?", userAttemptKey(hospitalID, username)).
+		Delete(&models.LoginAttempt{}).Error
+}
+
+// UnlockStaff lets an admin clear the lockout of a staff member of the same
+// hospital.
+func UnlockStaff(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var staff models.Staff
+	if err := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).First(&staff).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+
+	if err := clearLoginFailures(staff.HospitalID, staff.Username); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to unlock staff"})
+		return
+	}
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:         models.SecurityEventLoginUnlocked,
+		HospitalID:   staff.HospitalID,
+		StaffID:      &staff.ID,
+		ActorStaffID: &actorID,
+		Username:     staff.Username,
+		ClientIP:     c.ClientIP(),
+	})
+
+	c.JSON(200, gin.H{"message": "Staff unlocked"})
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Languages field error messages are written in.
+const (
+	languageEnglish = "en"
+	languageThai    = "th"
+)
+
+// fieldMessages are the messages of field errors by rule, in English and
+// Thai. %s stands for the rule's parameter. Rules whose message depends on
+// the type of the field, such as min, have a _number variant for numbers.
+var fieldMessages = map[string]map[string]string{
+	"required": {
+		languageEnglish: "is required",
+		languageThai:    "จำเป็นต้องระบุ",
+	},
+	"required_without": {
+		languageEnglish: "is required when %s is empty",
+		languageThai:    "จำเป็นต้องระบุเมื่อไม่ได้ระบุ %s",
+	},
+	"required_with": {
+		languageEnglish: "is required when %s is set",
+		languageThai:    "จำเป็นต้องระบุเมื่อระบุ %s",
+	},
+	"len": {
+		languageEnglish: "must be exactly %s characters",
+		languageThai:    "ต้องมีความยาว %s ตัวอักษรพอดี",
+	},
+	"min": {
+		languageEnglish: "must be at least %s characters",
+		languageThai:    "ต้องมีอย่างน้อย %s ตัวอักษร",
+	},
+	"max": {
+		languageEnglish: "must be at most %s characters",
+		languageThai:    "ต้องมีไม่เกิน %s ตัวอักษร",
+	},
+	"min_number": {
+		languageEnglish: "must be at least %s",
+		languageThai:    "ต้องไม่น้อยกว่า %s",
+	},
+	"max_number": {
+		languageEnglish: "must be at most %s",
+		languageThai:    "ต้องไม่มากกว่า %s",
+	},
+	"gtefield": {
+		languageEnglish: "must not be less than %s",
+		languageThai:    "ต้องไม่น้อยกว่า %s",
+	},
+	"gtfield": {
+		languageEnglish: "must be after %s",
+		languageThai:    "ต้องมากกว่า %s",
+	},
+	"numeric": {
+		languageEnglish: "must contain only digits",
+		languageThai:    "ต้องเป็นตัวเลขเท่านั้น",
+	},
+	"alphanum": {
+		languageEnglish: "must contain only letters and digits",
+		languageThai:    "ต้องเป็นตัวอักษรหรือตัวเลขเท่านั้น",
+	},
+	"excludesall": {
+		languageEnglish: "must not contain any of %q",
+		languageThai:    "ต้องไม่มีอักขระ %q",
+	},
+	"email": {
+		languageEnglish: "must be a valid email address",
+		languageThai:    "ต้องเป็นอีเมลที่ถูกต้อง",
+	},
+	"oneof": {
+		languageEnglish: "must be one of %s",
+		languageThai:    "ต้องเป็นค่าใดค่าหนึ่งต่อไปนี้: %s",
+	},
+	"type": {
+		languageEnglish: "must be a %s",
+		languageThai:    "ต้องเป็นชนิด %s",
+	},
+	"thai_national_id": {
+		languageEnglish: "must be a valid 13 digit Thai national ID",
+		languageThai:    "ต้องเป็นเลขประจำตัวประชาชน 13 หลักที่ถูกต้อง",
+	},
+	"passport": {
+		languageEnglish: "must be a valid passport number for its issuing country",
+		languageThai:    "ต้องเป็นเลขหนังสือเดินทางที่ถูกต้องตามประเทศที่ออก",
+	},
+	"iso3166_1_alpha3": {
+		languageEnglish: "must be an ISO 3166-1 alpha-3 country code, e.g. THA",
+		languageThai:    "ต้องเป็นรหัสประเทศ ISO 3166-1 alpha-3 เช่น THA",
+	},
+	"phone_th": {
+		languageEnglish: "must be a Thai phone number",
+		languageThai:    "ต้องเป็นหมายเลขโทรศัพท์ในประเทศไทย",
+	},
+	"gender": {
+		languageEnglish: "must be one of %s",
+		languageThai:    "ต้องเป็นค่าใดค่าหนึ่งต่อไปนี้: %s",
+	},
+	"date": {
+		languageEnglish: "must be a date such as 1990-01-31 or 2533-01-31",
+		languageThai:    "ต้องเป็นวันที่ เช่น 1990-01-31 หรือ พ.ศ. 2533-01-31",
+	},
+	"past": {
+		languageEnglish: "must not be in the future",
+		languageThai:    "ต้องไม่เป็นวันในอนาคต",
+	},
+	"public_https_url": {
+		languageEnglish: "must be an https URL of a public host",
+		languageThai:    "ต้องเป็น URL แบบ https ของโฮสต์สาธารณะ",
+	},
+	"future": {
+		languageEnglish: "must be in the future",
+		languageThai:    "ต้องเป็นเวลาในอนาคต",
+	},
+	"excluded_with": {
+		languageEnglish: "must not be set together with %s",
+		languageThai:    "ต้องไม่ระบุพร้อมกับ %s",
+	},
+	"cursor": {
+		languageEnglish: "is not a cursor of this search and sort order",
+		languageThai:    "ไม่ใช่ cursor ของการค้นหาและการเรียงลำดับนี้",
+	},
+	"password_policy": {
+		languageEnglish: "%s",
+		languageThai:    "ไม่เป็นไปตามนโยบายรหัสผ่าน: %s",
+	},
+	"held": {
+		languageEnglish: "must be permissions you hold, and you do not hold %s",
+		languageThai:    "ต้องเป็นสิทธิ์ที่คุณมีเท่านั้น แต่คุณไม่มีสิทธิ์ %s",
+	},
+	"max_matches": {
+		languageEnglish: "can only be fuzzy for searches of at most %s patients on this database; add filters",
+		languageThai:    "ค้นหาแบบใกล้เคียงได้เฉพาะการค้นหาที่มีผู้ป่วยไม่เกิน %s รายบนฐานข้อมูลนี้ กรุณาเพิ่มเงื่อนไข",
+	},
+	"unique": {
+		languageEnglish: "is already used by another patient",
+		languageThai:    "ถูกใช้โดยผู้ป่วยรายอื่นแล้ว",
+	},
+	"": {
+		languageEnglish: "is invalid",
+		languageThai:    "ไม่ถูกต้อง",
+	},
+}
+
+// fieldMessage returns the message of a rule in language. Lists in param,
+// separated by spaces as in oneof, are written with commas.
+func fieldMessage(language string, rule string, param string) string {
+	messages, ok := fieldMessages[rule]
+	if !ok {
+		messages = fieldMessages[""]
+	}
+	message := messages[language]
+	if !strings.Contains(message, "%") {
+		return message
+	}
+	if rule == "oneof" || rule == "gender" {
+		param = strings.Join(strings.Fields(param), ", ")
+	}
+	return fmt.Sprintf(message, param)
+}
+
+// requestLanguage picks the language of messages from the Accept-Language
+// header: Thai if the client prefers it to English, English otherwise.
+func requestLanguage(c *gin.Context) string {
+	type preference struct {
+		language string
+		quality  float64
+	}
+	var preferences []preference
+	for _, entry := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if primary != languageThai && primary != languageEnglish {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			preferences = append(preferences, preference{primary, quality})
+		}
+	}
+
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].quality > preferences[j].quality
+	})
+	if len(preferences) > 0 {
+		return preferences[0].language
+	}
+	return languageEnglish
+}
+
+// rejectFields writes an error response listing the rejected fields, with
+// the messages not set yet written in the caller's language.
+func rejectFields(c *gin.Context, status int, message string, fieldErrors []models.FieldError) {
+	language := requestLanguage(c)
+	for i, fieldErr := range fieldErrors {
+		if fieldErr.Message == "" {
+			fieldErrors[i].Message = fieldMessage(language, fieldErr.Rule, fieldErr.Param)
+		}
+	}
+	c.JSON(status, gin.H{"error": message, "details": fieldErrors})
+}
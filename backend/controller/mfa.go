@@ -0,0 +1,405 @@
+package controller
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// startMFAChallenge answers a correct password of a staff member with TOTP
+// enabled: instead of a token they get a challenge to complete at
+// POST /staff/login/mfa.
+func startMFAChallenge(c *gin.Context, staff models.Staff) {
+	challenge, err := auth.GenerateToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate MFA challenge"})
+		return
+	}
+
+	record := models.MFAChallenge{
+		ChallengeHash: auth.HashToken(config.Auth.TokenHashKey, challenge),
+		StaffID:       staff.ID,
+		ExpiresAt:     time.Now().Add(config.Auth.MFAChallengeTTL),
+	}
+	if err := config.DB.Create(&record).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save MFA challenge"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": models.MFAChallengeResponse{
+		MFARequired: true,
+		Challenge:   challenge,
+		ExpiresAt:   record.ExpiresAt,
+	}})
+}
+
+// LoginMFA completes a two-step login with a TOTP code or a recovery code.
+func LoginMFA(c *gin.Context) {
+	var request models.MFALoginRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+	if request.Code == "" && request.RecoveryCode == "" {
+		c.JSON(400, gin.H{"error": "A code or recovery code is required"})
+		return
+	}
+
+	var challenge models.MFAChallenge
+	challengeHash := auth.HashToken(config.Auth.TokenHashKey, request.Challenge)
+	if err := config.DB.Where("challenge_hash = ?", challengeHash).First(&challenge).Error; err != nil ||
+		challenge.ExpiresAt.Before(time.Now()) {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA challenge"})
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.Preload("Roles.Permissions").Preload("Hospital").First(&staff, challenge.StaffID).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA challenge"})
+		return
+	}
+
+	if loginLockedUntil(userAttemptKey(staff.HospitalID, staff.Username)) != nil {
+		c.JSON(429, gin.H{"error": "Too many failed login attempts, try again later"})
+		return
+	}
+
+	var verified bool
+	if request.Code != "" {
+		verified = useTOTPCode(staff, request.Code)
+	} else {
+		verified = useRecoveryCode(c, staff, request.RecoveryCode)
+	}
+
+	if !verified {
+		challenge.Attempts++
+		if challenge.Attempts >= config.Auth.MFAChallengeTries {
+			config.DB.Unscoped().Delete(&challenge)
+		} else {
+			config.DB.Model(&challenge).Update("attempts", challenge.Attempts)
+		}
+		registerLoginFailure(c, models.StaffLoginRequest{Username: staff.Username, HospitalID: staff.HospitalID})
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	// A challenge completes exactly one login.
+	if result := config.DB.Unscoped().Delete(&challenge); result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA challenge"})
+		return
+	}
+
+	clearLoginFailures(staff.HospitalID, staff.Username)
+
+	response, err := issueToken(config.DB, c, staff, "")
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save token"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": response})
+}
+
+// useTOTPCode checks a TOTP code and records its time step so it cannot be
+// used again.
+func useTOTPCode(staff models.Staff, code string) bool {
+	step, ok := auth.ValidateTOTP(staff.TOTPSecret, code, time.Now(), staff.TOTPLastStep)
+	if !ok {
+		return false
+	}
+
+	result := config.DB.Model(&models.Staff{}).
+		Where("id = ? AND totp_last_step < ?", staff.ID, step).
+		Update("totp_last_step", step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func useRecoveryCode(c *gin.Context, staff models.Staff, code string) bool {
+	codeHash := hashRecoveryCode(code)
+	result := config.DB.Model(&models.RecoveryCode{}).
+		Where("staff_id = ? AND code_hash = ? AND used_at IS NULL", staff.ID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:       models.SecurityEventMFARecoveryUse,
+		HospitalID: staff.HospitalID,
+		StaffID:    &staff.ID,
+		Username:   staff.Username,
+		ClientIP:   c.ClientIP(),
+	})
+	return true
+}
+
+func hashRecoveryCode(code string) string {
+	return auth.HashToken(config.Auth.TokenHashKey, strings.ToLower(strings.TrimSpace(code)))
+}
+
+// EnrollMFA starts TOTP enrollment by generating a new secret. MFA is only
+// enabled once a code from it is verified at POST /staff/mfa/verify.
+func EnrollMFA(c *gin.Context) {
+	staffID, exists := c.Get("staff_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Staff ID not found in context"})
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.First(&staff, staffID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+	if staff.TOTPEnabled {
+		c.JSON(409, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate MFA secret"})
+		return
+	}
+	if err := config.DB.Model(&staff).Update("totp_secret", secret).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save MFA secret"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": models.MFAEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(config.Auth.MFAIssuer, staff.Username, secret),
+	}})
+}
+
+// VerifyMFA completes enrollment with a first TOTP code and returns a fresh
+// set of recovery codes. They are only shown this once.
+func VerifyMFA(c *gin.Context) {
+	staffID, exists := c.Get("staff_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Staff ID not found in context"})
+		return
+	}
+
+	var request models.MFACodeRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.First(&staff, staffID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+	if staff.TOTPEnabled {
+		c.JSON(409, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+	if staff.TOTPSecret == "" {
+		c.JSON(400, gin.H{"error": "MFA enrollment has not been started"})
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(staff.TOTPSecret, request.Code, time.Now(), staff.TOTPLastStep)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(config.Auth.MFARecoveryCodeCount)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	tx := config.DB.Begin()
+
+	if err := tx.Model(&staff).Updates(map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to enable MFA"})
+		return
+	}
+
+	if err := replaceRecoveryCodes(tx, staff.ID, codes); err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to save recovery codes"})
+		return
+	}
+
+	tx.Commit()
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:       models.SecurityEventMFAEnabled,
+		HospitalID: staff.HospitalID,
+		StaffID:    &staff.ID,
+		Username:   staff.Username,
+		ClientIP:   c.ClientIP(),
+	})
+
+	c.JSON(200, gin.H{"data": models.MFARecoveryCodesResponse{RecoveryCodes: codes}})
+}
+
+func replaceRecoveryCodes(db *gorm.DB, staffID uint, codes []string) error {
+	if err := db.Unscoped().Where("staff_id = ?", staffID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		recoveryCode := models.RecoveryCode{StaffID: staffID, CodeHash: hashRecoveryCode(code)}
+		if err := db.Create(&recoveryCode).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisableMFA turns off the caller's own MFA after checking a current code.
+// It is refused when the hospital requires MFA.
+func DisableMFA(c *gin.Context) {
+	staffID, exists := c.Get("staff_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Staff ID not found in context"})
+		return
+	}
+
+	var request models.MFACodeRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.Preload("Hospital").First(&staff, staffID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+	if !staff.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+	if staff.Hospital.RequireMFA {
+		c.JSON(403, gin.H{"error": "Your hospital requires MFA"})
+		return
+	}
+	if !useTOTPCode(staff, request.Code) {
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err := clearMFA(staff.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to disable MFA"})
+		return
+	}
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:       models.SecurityEventMFADisabled,
+		HospitalID: staff.HospitalID,
+		StaffID:    &staff.ID,
+		Username:   staff.Username,
+		ClientIP:   c.ClientIP(),
+	})
+
+	c.JSON(200, gin.H{"message": "MFA disabled"})
+}
+
+// ResetStaffMFA lets an admin remove the MFA enrollment of a staff member of
+// the same hospital, e.g. after a lost phone without recovery codes.
+func ResetStaffMFA(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var staff models.Staff
+	if err := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).First(&staff).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+
+	if err := clearMFA(staff.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reset MFA"})
+		return
+	}
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:         models.SecurityEventMFADisabled,
+		HospitalID:   staff.HospitalID,
+		StaffID:      &staff.ID,
+		ActorStaffID: &actorID,
+		Username:     staff.Username,
+		ClientIP:     c.ClientIP(),
+	})
+
+	c.JSON(200, gin.H{"message": "MFA reset"})
+}
+
+func clearMFA(staffID uint) error {
+	tx := config.DB.Begin()
+
+	if err := tx.Model(&models.Staff{}).Where("id = ?", staffID).Updates(map[string]interface{}{
+		"totp_secret":    "",
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Unscoped().Where("staff_id = ?", staffID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// SetHospitalMFA lets a hospital admin require MFA for all of its staff.
+// Staff who have not enrolled can then only use their session to enroll.
+func SetHospitalMFA(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var request models.HospitalMFARequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	var hospital models.Hospital
+	if err := config.DB.First(&hospital, hospitalID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Hospital not found"})
+		return
+	}
+
+	if err := config.DB.Model(&hospital).Update("require_mfa", *request.Required).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update hospital"})
+		return
+	}
+
+	detail := "MFA optional"
+	if *request.Required {
+		detail = "MFA required"
+	}
+	recordSecurityEvent(models.SecurityEvent{
+		Type:         models.SecurityEventHospitalMFA,
+		HospitalID:   hospital.ID,
+		ActorStaffID: &actorID,
+		ClientIP:     c.ClientIP(),
+		Detail:       detail,
+	})
+
+	c.JSON(200, gin.H{"data": gin.H{"hospital_id": hospital.ID, "require_mfa": hospital.RequireMFA}})
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/namesearch"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxInMemoryMatches is how many patients matchPatientsInMemory scores at
+// most. Searches whose other filters leave more are refused rather than
+// loaded whole or ranked from a truncated list.
+var maxInMemoryMatches = 10000
+
+var errTooManyMatches = errors.New("too many patients to match in memory")
+
+// scoredPatient is a patient loaded with its fuzzy match score.
+type scoredPatient struct {
+	models.Patient
+	MatchScore float64
+}
+
+// searchPatientsFuzzy is SearchPatients with match=fuzzy: the name filters
+// are joined into one name that is compared with the patients' names by
+// trigram similarity, and the results are ordered by score.
+func searchPatientsFuzzy(c *gin.Context, searchRequest models.PatientSearchRequest, births birthRange) {
+	var parts []string
+	for _, part := range []string{searchRequest.FirstName, searchRequest.MiddleName, searchRequest.LastName} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		rejectFields(c, 400, "Validation failed", []models.FieldError{{
+			Field: "first_name",
+			Rule:  "required_with",
+			Param: "match=fuzzy",
+		}})
+		return
+	}
+	// Fuzzy matches are ordered by score and paged by page number.
+	var fieldErrors []models.FieldError
+	if searchRequest.Sort != "" {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "sort", Rule: "excluded_with", Param: "match=fuzzy"})
+	}
+	if searchRequest.Cursor != "" {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "cursor", Rule: "excluded_with", Param: "match=fuzzy"})
+	}
+	if len(fieldErrors) > 0 {
+		rejectFields(c, 400, "Validation failed", fieldErrors)
+		return
+	}
+	name := strings.Join(parts, " ")
+
+	limit := searchRequest.Limit
+	if limit == 0 {
+		limit = models.DefaultPageSize
+	}
+	pagination := models.Pagination{Page: max(searchRequest.Page, 1), Limit: limit, Sort: "score"}
+	offset := (pagination.Page - 1) * limit
+
+	var (
+		matches []scoredPatient
+		err     error
+	)
+	if tenantDB(c).Dialector.Name() == "postgres" {
+		matches, pagination.Total, err = matchPatientsTrgm(c, searchRequest, births, name, offset, limit+1)
+	} else {
+		matches, pagination.Total, err = matchPatientsInMemory(c, searchRequest, births, name, offset, limit+1)
+	}
+	if errors.Is(err, errTooManyMatches) {
+		rejectFields(c, 400, "Too many patients to match", []models.FieldError{{
+			Field: "match",
+			Rule:  "max_matches",
+			Param: strconv.Itoa(maxInMemoryMatches),
+		}})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to search patients"})
+		return
+	}
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+		pagination.HasMore = true
+	}
+
+	patientIDs := make([]uint, 0, len(matches))
+	responses := make([]models.PatientMatchResponse, 0, len(matches))
+	for _, match := range matches {
+		patientIDs = append(patientIDs, match.Patient.ID)
+		responses = append(responses, models.PatientMatchResponse{
+			PatientResponse: patientResponse(c, &match.Patient),
+			MatchScore:      match.MatchScore,
+		})
+	}
+	if !recordAccess(c, auditEntry(c, models.AuditPatientSearch, patientIDs)) {
+		return
+	}
+
+	c.JSON(200, gin.H{"data": responses, "pagination": pagination})
+}
+
+// matchPatientsTrgm scores the names in Postgres with pg_trgm. The <%
+// operator uses the trigram indexes on the search columns; its threshold is
+// set to namesearch.MinScore for the transaction.
+func matchPatientsTrgm(c *gin.Context, searchRequest models.PatientSearchRequest, births birthRange, name string, offset int, limit int) ([]scoredPatient, int64, error) {
+	normalized, phonetic := namesearch.Normalize(name), namesearch.PhoneticKey(name)
+
+	var matches []scoredPatient
+	var total int64
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		threshold := strconv.FormatFloat(namesearch.MinScore, 'f', -1, 64)
+		if err := tx.Exec("SELECT set_config('pg_trgm.word_similarity_threshold', ?, true)", threshold).Error; err != nil {
+			return err
+		}
+
+		query := tx.Model(&models.Patient{}).
+			Scopes(patientFilters(searchRequest, births)).
+			Where("? <% search_name OR ? <% search_phonetic", normalized, phonetic).
+			Session(&gorm.Session{})
+		if err := query.Count(&total).Error; err != nil {
+			return err
+		}
+
+		return query.
+			Select("patients.*, GREATEST(word_similarity(?, search_name), word_similarity(?, search_phonetic)) AS match_score", normalized, phonetic).
+			Order("match_score DESC, id ASC").
+			Offset(offset).
+			Limit(limit).
+			Find(&matches).Error
+	})
+	return matches, total, err
+}
+
+// matchPatientsInMemory scores the names in Go, for databases without
+// pg_trgm such as SQLite in tests. It loads every patient that passes the
+// other filters, and returns errTooManyMatches if that is more than
+// maxInMemoryMatches.
+func matchPatientsInMemory(c *gin.Context, searchRequest models.PatientSearchRequest, births birthRange, name string, offset int, limit int) ([]scoredPatient, int64, error) {
+	var patients []models.Patient
+	if err := tenantDB(c).Scopes(patientFilters(searchRequest, births)).Order("id").Limit(maxInMemoryMatches + 1).Find(&patients).Error; err != nil {
+		return nil, 0, err
+	}
+	if len(patients) > maxInMemoryMatches {
+		return nil, 0, errTooManyMatches
+	}
+
+	ranked := namesearch.Rank(patients, func(patient models.Patient) float64 {
+		return namesearch.Score(name, patient.SearchName, patient.SearchPhonetic)
+	})
+
+	var matches []scoredPatient
+	for i := offset; i < len(ranked) && i < offset+limit; i++ {
+		matches = append(matches, scoredPatient{Patient: ranked[i].Item, MatchScore: ranked[i].Score})
+	}
+	return matches, int64(len(ranked)), nil
+}
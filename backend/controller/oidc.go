@@ -0,0 +1,423 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errNoSSOAccount     = errors.New("no staff account for this identity")
+	errSSOUsernameTaken = errors.New("username already in use")
+)
+
+// OIDCLoginCookie binds a single sign-on login to the browser that started
+// it: the callback is only accepted with the cookie set by StartOIDCLogin, so
+// a user cannot be logged in to an account someone else started a login for.
+const OIDCLoginCookie = "oidc_login"
+
+// oidcClients keeps one client per identity provider, so that discovery
+// documents and signing keys are fetched once rather than on every login.
+var oidcClients = struct {
+	sync.Mutex
+	byProvider map[uint]*auth.OIDCClient
+}{byProvider: map[uint]*auth.OIDCClient{}}
+
+func oidcClient(provider models.IdentityProvider) *auth.OIDCClient {
+	oidcClients.Lock()
+	defer oidcClients.Unlock()
+
+	client, found := oidcClients.byProvider[provider.ID]
+	if found && client.Issuer == provider.Issuer && client.ClientID == provider.ClientID &&
+		client.ClientSecret == provider.ClientSecret && client.RedirectURL == provider.RedirectURL {
+		return client
+	}
+
+	client = &auth.OIDCClient{
+		Issuer:        provider.Issuer,
+		ClientID:      provider.ClientID,
+		ClientSecret:  provider.ClientSecret,
+		RedirectURL:   provider.RedirectURL,
+		Scopes:        provider.ScopeList(),
+		HTTPClient:    auth.NewOIDCHTTPClient(config.Auth.OIDCHTTPTimeout, config.Auth.OIDCAllowInsecure),
+		JWKSCacheTTL:  config.Auth.OIDCJWKSCacheTTL,
+		AllowInsecure: config.Auth.OIDCAllowInsecure,
+	}
+	oidcClients.byProvider[provider.ID] = client
+	return client
+}
+
+// StartOIDCLogin begins a single sign-on login with one of the identity
+// providers of a hospital. The client sends the user to the returned URL;
+// the provider redirects back to the client, which posts the code and state
+// to OIDCCallback from the same browser.
+func StartOIDCLogin(c *gin.Context) {
+	var provider models.IdentityProvider
+	if err := config.DB.First(&provider, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Identity provider not found"})
+		return
+	}
+
+	state, err := auth.GenerateToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, err := auth.GenerateToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start login"})
+		return
+	}
+	codeVerifier, err := auth.GenerateToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start login"})
+		return
+	}
+	browserBinding, err := auth.GenerateToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	authorizationURL, err := oidcClient(provider).AuthCodeURL(state, nonce, codeVerifier)
+	if err != nil {
+		log.Printf("Failed to reach identity provider %d: %v", provider.ID, err)
+		c.JSON(502, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	now := time.Now()
+	config.DB.Unscoped().Where("expires_at < ?", now).Delete(&models.OIDCLoginState{})
+
+	loginState := models.OIDCLoginState{
+		StateHash:    auth.HashToken(config.Auth.TokenHashKey, state),
+		BindingHash:  auth.HashToken(config.Auth.TokenHashKey, browserBinding),
+		ProviderID:   provider.ID,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    now.Add(config.Auth.OIDCStateTTL),
+	}
+	if err := config.DB.Create(&loginState).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save login state"})
+		return
+	}
+
+	setOIDCLoginCookie(c, browserBinding, int(config.Auth.OIDCStateTTL.Seconds()))
+	c.JSON(200, gin.H{"data": models.OIDCLoginResponse{
+		AuthorizationURL: authorizationURL,
+		ExpiresAt:        loginState.ExpiresAt,
+	}})
+}
+
+func setOIDCLoginCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCLoginCookie, value, maxAge, "/auth/oidc", "", true, true)
+}
+
+// OIDCCallback completes a single sign-on login: it redeems the code, checks
+// the ID token and logs in the linked, matched or newly provisioned staff
+// member exactly like a password login would. The code and state are posted
+// by the client, never read from a URL, and the response is not cached.
+func OIDCCallback(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	var request models.OIDCCallbackRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	browserBinding, _ := c.Cookie(OIDCLoginCookie)
+	setOIDCLoginCookie(c, "", -1)
+
+	if request.Error != "" {
+		c.JSON(401, gin.H{"error": "Login rejected by identity provider: " + request.Error})
+		return
+	}
+
+	// A state completes exactly one login, in the browser that started it.
+	var loginState models.OIDCLoginState
+	stateHash := auth.HashToken(config.Auth.TokenHashKey, request.State)
+	if err := config.DB.Where("state_hash = ?", stateHash).First(&loginState).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+	if result := config.DB.Unscoped().Delete(&loginState); result.Error != nil || result.RowsAffected == 0 ||
+		loginState.ExpiresAt.Before(time.Now()) {
+		c.JSON(401, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+	if browserBinding == "" || subtle.ConstantTimeCompare(
+		[]byte(auth.HashToken(config.Auth.TokenHashKey, browserBinding)), []byte(loginState.BindingHash)) != 1 {
+		c.JSON(401, gin.H{"error": "Login was started in another browser"})
+		return
+	}
+
+	var provider models.IdentityProvider
+	if err := config.DB.First(&provider, loginState.ProviderID).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+
+	client := oidcClient(provider)
+	idToken, err := client.Exchange(request.Code, loginState.CodeVerifier)
+	if err != nil {
+		log.Printf("Failed to redeem code at identity provider %d: %v", provider.ID, err)
+		c.JSON(401, gin.H{"error": "Failed to redeem authorization code"})
+		return
+	}
+	claims, err := client.VerifyIDToken(idToken, loginState.Nonce, time.Now())
+	if err != nil {
+		log.Printf("Rejected ID token from identity provider %d: %v", provider.ID, err)
+		c.JSON(401, gin.H{"error": "Invalid ID token"})
+		return
+	}
+
+	staffID, err := resolveSSOStaff(c, provider, claims)
+	if err == errNoSSOAccount {
+		c.JSON(403, gin.H{"error": "No staff account for this identity"})
+		return
+	}
+	if err == errSSOUsernameTaken {
+		c.JSON(409, gin.H{"error": "Username already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to provision staff"})
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.Preload("Roles.Permissions").Preload("Hospital").First(&staff, staffID).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load staff"})
+		return
+	}
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:       models.SecurityEventSSOLogin,
+		HospitalID: staff.HospitalID,
+		StaffID:    &staff.ID,
+		Username:   staff.Username,
+		ClientIP:   c.ClientIP(),
+		Detail:     provider.Name,
+	})
+
+	if staff.TOTPEnabled {
+		startMFAChallenge(c, staff)
+		return
+	}
+
+	response, err := issueToken(config.DB, c, staff, "")
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save token"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": response})
+}
+
+// resolveSSOStaff finds the staff member an ID token belongs to: one already
+// linked to the subject, else one of the provider's hospital with the same
+// verified email, else a new one if the provider allows provisioning.
+func resolveSSOStaff(c *gin.Context, provider models.IdentityProvider, claims auth.IDTokenClaims) (uint, error) {
+	var identity models.ExternalIdentity
+	err := config.DB.Where("provider_id = ? AND subject = ?", provider.ID, claims.Subject).First(&identity).Error
+	if err == nil {
+		var staff models.Staff
+		if err := config.DB.Where("id = ? AND hospital_id = ?", identity.StaffID, provider.HospitalID).First(&staff).Error; err != nil {
+			return 0, errNoSSOAccount
+		}
+		return staff.ID, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+
+	if claims.Email != "" && claims.EmailVerified {
+		var staff models.Staff
+		if err := config.DB.Where("hospital_id = ? AND LOWER(email) = ?", provider.HospitalID, strings.ToLower(claims.Email)).First(&staff).Error; err == nil {
+			identity = models.ExternalIdentity{ProviderID: provider.ID, Subject: claims.Subject, StaffID: staff.ID}
+			if err := config.DB.Create(&identity).Error; err != nil {
+				return 0, err
+			}
+			recordSSOEvent(c, models.SecurityEventSSOLinked, provider, staff)
+			return staff.ID, nil
+		}
+	}
+
+	if !provider.AutoProvision {
+		return 0, errNoSSOAccount
+	}
+	return provisionSSOStaff(c, provider, claims)
+}
+
+// provisionSSOStaff creates a staff member for a first-time single sign-on
+// login. They get no password, so they can only log in through SSO.
+func provisionSSOStaff(c *gin.Context, provider models.IdentityProvider, claims auth.IDTokenClaims) (uint, error) {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Email
+	}
+	if username == "" {
+		username = claims.Subject
+	}
+	name := claims.Name
+	if name == "" {
+		name = username
+	}
+
+	tx := config.DB.Begin()
+
+	var existingStaff models.Staff
+	if err := tx.Where("username = ?", username).First(&existingStaff).Error; err == nil {
+		tx.Rollback()
+		return 0, errSSOUsernameTaken
+	}
+
+	roles, err := findRoles(tx, provider.DefaultRoleList())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	staff := models.Staff{
+		Username:   username,
+		Name:       name,
+		Email:      claims.Email,
+		HospitalID: provider.HospitalID,
+		Roles:      roles,
+	}
+	if err := tx.Create(&staff).Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	identity := models.ExternalIdentity{ProviderID: provider.ID, Subject: claims.Subject, StaffID: staff.ID}
+	if err := tx.Create(&identity).Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	recordSSOEvent(c, models.SecurityEventSSOProvisioned, provider, staff)
+	return staff.ID, nil
+}
+
+func recordSSOEvent(c *gin.Context, eventType string, provider models.IdentityProvider, staff models.Staff) {
+	recordSecurityEvent(models.SecurityEvent{
+		Type:       eventType,
+		HospitalID: staff.HospitalID,
+		StaffID:    &staff.ID,
+		Username:   staff.Username,
+		ClientIP:   c.ClientIP(),
+		Detail:     provider.Name,
+	})
+}
+
+// ListIdentityProviders returns the identity providers of the caller's
+// hospital.
+func ListIdentityProviders(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	var providers []models.IdentityProvider
+	if err := config.DB.Where("hospital_id = ?", hospitalID).Order("name").Find(&providers).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list identity providers"})
+		return
+	}
+
+	responses := []models.IdentityProviderResponse{}
+	for _, provider := range providers {
+		responses = append(responses, provider.ToResponse())
+	}
+
+	c.JSON(200, gin.H{"data": responses})
+}
+
+// CreateIdentityProvider registers an OpenID Connect provider for the
+// caller's hospital.
+func CreateIdentityProvider(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	var request models.IdentityProviderRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+	if auth.CheckIssuer(request.Issuer, config.Auth.OIDCAllowInsecure) != nil {
+		rejectFields(c, 400, "Validation failed", []models.FieldError{{Field: "issuer", Rule: "public_https_url"}})
+		return
+	}
+
+	roles, err := findRoles(config.DB, request.DefaultRoles)
+	if err == errUnknownRole {
+		c.JSON(400, gin.H{"error": "Unknown role"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load roles"})
+		return
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+
+	provider := models.IdentityProvider{
+		HospitalID:    hospitalID.(uint),
+		Name:          request.Name,
+		Issuer:        request.Issuer,
+		ClientID:      request.ClientID,
+		ClientSecret:  request.ClientSecret,
+		RedirectURL:   request.RedirectURL,
+		Scopes:        strings.Join(request.Scopes, ","),
+		AutoProvision: request.AutoProvision,
+		DefaultRoles:  strings.Join(roleNames, ","),
+	}
+	if err := config.DB.Create(&provider).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save identity provider"})
+		return
+	}
+
+	c.JSON(201, gin.H{"data": provider.ToResponse()})
+}
+
+// DeleteIdentityProvider removes an identity provider of the caller's
+// hospital. Staff linked to it keep their accounts.
+func DeleteIdentityProvider(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	result := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).Delete(&models.IdentityProvider{})
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete identity provider"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "Identity provider not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Identity provider deleted"})
+}
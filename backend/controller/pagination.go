@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the position after the last row of a page: the row's sort
+// value and ID. It is only valid for the sort it was made with.
+type pageCursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+// paginate loads the page of the rows matched by query that request asks
+// for. sortFields are the columns of T that may be sorted by; rows with the
+// same sort value are ordered by ID, which makes the order total and lets a
+// cursor continue exactly after the last row. It writes the error response
+// and returns false if the request is rejected.
+func paginate[T any](c *gin.Context, query *gorm.DB, request models.PageRequest, sortFields []string, defaultSort string) ([]T, models.Pagination, bool) {
+	var rows []T
+
+	sort := request.Sort
+	if sort == "" {
+		sort = defaultSort
+	}
+	column, descending := strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	if !slices.Contains(sortFields, column) {
+		rejectFields(c, 400, "Validation failed", []models.FieldError{{
+			Field: "sort",
+			Rule:  "oneof",
+			Param: strings.Join(sortFields, " "),
+		}})
+		return nil, models.Pagination{}, false
+	}
+	if request.Page > 0 && request.Cursor != "" {
+		rejectFields(c, 400, "Validation failed", []models.FieldError{{
+			Field: "cursor",
+			Rule:  "excluded_with",
+			Param: "page",
+		}})
+		return nil, models.Pagination{}, false
+	}
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = models.DefaultPageSize
+	}
+	pagination := models.Pagination{Limit: limit, Sort: sort}
+
+	statement := &gorm.Statement{DB: query}
+	if err := statement.Parse(new(T)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load results"})
+		return nil, models.Pagination{}, false
+	}
+	sortField := statement.Schema.LookUpField(column)
+	idField := statement.Schema.PrioritizedPrimaryField
+
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&pagination.Total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load results"})
+		return nil, models.Pagination{}, false
+	}
+
+	operator, direction := ">", "ASC"
+	if descending {
+		operator, direction = "<", "DESC"
+	}
+
+	page := query
+	if request.Cursor != "" {
+		cursor, value, err := decodeCursor(request.Cursor, sort, sortField)
+		if err != nil {
+			rejectFields(c, 400, "Validation failed", []models.FieldError{{Field: "cursor", Rule: "cursor"}})
+			return nil, models.Pagination{}, false
+		}
+		page = page.Where(
+			fmt.Sprintf("%s %s ? OR (%s = ? AND %s %s ?)", sortField.DBName, operator, sortField.DBName, idField.DBName, operator),
+			value, value, cursor.ID,
+		)
+	} else {
+		pagination.Page = max(request.Page, 1)
+		page = page.Offset((pagination.Page - 1) * limit)
+	}
+
+	// One row more than asked for tells whether there is a next page.
+	if err := page.
+		Order(sortField.DBName + " " + direction).
+		Order(idField.DBName + " " + direction).
+		Limit(limit + 1).
+		Find(&rows).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load results"})
+		return nil, models.Pagination{}, false
+	}
+
+	if len(rows) > limit {
+		rows = rows[:limit]
+		pagination.HasMore = true
+
+		last := reflect.ValueOf(&rows[limit-1]).Elem()
+		cursor, err := encodeCursor(c, sort, sortField, idField, last)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load results"})
+			return nil, models.Pagination{}, false
+		}
+		pagination.NextCursor = cursor
+	}
+
+	return rows, pagination, true
+}
+
+func encodeCursor(c *gin.Context, sort string, sortField *schema.Field, idField *schema.Field, row reflect.Value) (string, error) {
+	sortValue, _ := sortField.ValueOf(c.Request.Context(), row)
+	value, err := json.Marshal(sortValue)
+	if err != nil {
+		return "", err
+	}
+
+	idValue, _ := idField.ValueOf(c.Request.Context(), row)
+	id, _ := idValue.(uint)
+
+	data, err := json.Marshal(pageCursor{Sort: sort, Value: value, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor reads a cursor made for sort and returns it with its sort
+// value converted to the type of sortField.
+func decodeCursor(encoded string, sort string, sortField *schema.Field) (pageCursor, interface{}, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, nil, errInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort {
+		return cursor, nil, errInvalidCursor
+	}
+
+	value := reflect.New(sortField.FieldType)
+	if err := json.Unmarshal(cursor.Value, value.Interface()); err != nil {
+		return cursor, nil, errInvalidCursor
+	}
+	return cursor, value.Elem().Interface(), nil
+}
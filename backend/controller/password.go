@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkPasswordPolicy writes a 400 response listing the rules the password
+// in field breaks and returns false if the password is not acceptable.
+func checkPasswordPolicy(c *gin.Context, field string, password string, username string) bool {
+	err := config.Auth.PasswordPolicy.Check(password, username)
+	if err == nil {
+		return true
+	}
+
+	var policyErr *auth.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		c.JSON(500, gin.H{"error": "Failed to check password"})
+		return false
+	}
+	fieldErrors := make([]models.FieldError, 0, len(policyErr.Violations))
+	for _, violation := range policyErr.Violations {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: field, Rule: "password_policy", Param: violation})
+	}
+	rejectFields(c, 400, "Password does not meet the password policy", fieldErrors)
+	return false
+}
+
+// ChangePassword changes the caller's own password and signs out every other
+// session.
+func ChangePassword(c *gin.Context) {
+	staffID, exists := c.Get("staff_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Staff ID not found in context"})
+		return
+	}
+	tokenID, _ := c.Get("token_id")
+
+	var request models.PasswordChangeRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.First(&staff, staffID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(staff.Password), []byte(request.CurrentPassword)); err != nil {
+		c.JSON(401, gin.H{"error": "Invalid credentials"})
+		return
+	}
+	if request.NewPassword == request.CurrentPassword {
+		c.JSON(400, gin.H{"error": "New password must be different from the current password"})
+		return
+	}
+	if !checkPasswordPolicy(c, "new_password", request.NewPassword, staff.Username) {
+		return
+	}
+
+	if err := setPassword(&staff, request.NewPassword); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	if _, err := revokeTokens(config.DB.Where("staff_id = ? AND id <> ?", staff.ID, tokenID)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke other sessions"})
+		return
+	}
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:       models.SecurityEventPasswordChanged,
+		HospitalID: staff.HospitalID,
+		StaffID:    &staff.ID,
+		Username:   staff.Username,
+		ClientIP:   c.ClientIP(),
+	})
+
+	c.JSON(200, gin.H{"message": "Password changed"})
+}
+
+// IssuePasswordReset lets an admin issue a one-time password reset token for
+// a staff member of the same hospital. Earlier unused tokens are invalidated,
+// and until the staff member sets a new password they have to change it
+// before doing anything else.
+func IssuePasswordReset(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var staff models.Staff
+	if err := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).First(&staff).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+
+	resetToken, err := auth.GenerateToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate reset token"})
+		return
+	}
+
+	tx := config.DB.Begin()
+
+	if err := tx.Where("staff_id = ? AND used_at IS NULL", staff.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to issue reset token"})
+		return
+	}
+
+	token := models.PasswordResetToken{
+		TokenHash: auth.HashToken(config.Auth.TokenHashKey, resetToken),
+		StaffID:   staff.ID,
+		ExpiresAt: time.Now().Add(config.Auth.PasswordResetTTL),
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to issue reset token"})
+		return
+	}
+
+	if err := tx.Model(&staff).Update("must_change_password", true).Error; err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to issue reset token"})
+		return
+	}
+
+	tx.Commit()
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:         models.SecurityEventPasswordResetIssued,
+		HospitalID:   staff.HospitalID,
+		StaffID:      &staff.ID,
+		ActorStaffID: &actorID,
+		Username:     staff.Username,
+		ClientIP:     c.ClientIP(),
+	})
+
+	c.JSON(201, gin.H{"data": models.PasswordResetTokenResponse{
+		ResetToken: resetToken,
+		ExpiresAt:  token.ExpiresAt,
+	}})
+}
+
+// ResetPassword sets a new password with a one-time reset token. Every
+// session of the staff member is revoked and any login lockout is cleared.
+func ResetPassword(c *gin.Context) {
+	var request models.PasswordResetRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	var token models.PasswordResetToken
+	tokenHash := auth.HashToken(config.Auth.TokenHashKey, request.ResetToken)
+	if err := config.DB.Where("token_hash = ? AND used_at IS NULL", tokenHash).First(&token).Error; err != nil ||
+		token.ExpiresAt.Before(time.Now()) {
+		c.JSON(400, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.First(&staff, token.StaffID).Error; err != nil {
+		c.JSON(400, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	if !checkPasswordPolicy(c, "new_password", request.NewPassword, staff.Username) {
+		return
+	}
+
+	// Claim the token before using it so it cannot be used twice.
+	result := config.DB.Model(&token).Where("used_at IS NULL").Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(400, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	if err := setPassword(&staff, request.NewPassword); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	if _, err := revokeTokens(config.DB.Where("staff_id = ?", staff.ID)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	clearLoginFailures(staff.HospitalID, staff.Username)
+
+	recordSecurityEvent(models.SecurityEvent{
+		Type:       models.SecurityEventPasswordReset,
+		HospitalID: staff.HospitalID,
+		StaffID:    &staff.ID,
+		Username:   staff.Username,
+		ClientIP:   c.ClientIP(),
+	})
+
+	c.JSON(200, gin.H{"message": "Password reset"})
+}
+
+func setPassword(staff *models.Staff, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return config.DB.Model(staff).Updates(map[string]interface{}{
+		"password":             string(hashedPassword),
+		"password_changed_at":  now,
+		"must_change_password": false,
+	}).Error
+}
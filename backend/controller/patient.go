@@ -1,63 +1,85 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/audit"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/encryption"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/masking"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/validation"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// tenantDB returns the database scoped to the caller's hospital. Queries of
+// hospital scoped models made without it fail with tenant.ErrMissingHospital.
+func tenantDB(c *gin.Context) *gorm.DB {
+	return config.DB.WithContext(c.Request.Context())
+}
+
+// GetPatient looks up a patient of the caller's hospital by national ID or
+// passport ID.
 func GetPatient(c *gin.Context) {
 	id := c.Param("id")
+	nationalIDIndex, err := encryption.BlindIndex("national_id", id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load patient"})
+		return
+	}
+	passportIDIndex, err := encryption.BlindIndex("passport_id", validation.NormalizePassport(id))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load patient"})
+		return
+	}
 
 	var patient models.Patient
-	if err := config.DB.
-		Where("national_id = ? OR passport_id = ?", id, id).
+	if err := tenantDB(c).
+		Where("national_id_index = ? OR passport_id_index = ?", nationalIDIndex, passportIDIndex).
 		First(&patient).Error; err != nil {
-		c.JSON(404, gin.H{"error": "Patient not found"})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "Patient not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to load patient"})
 		return
 	}
 
-	response := models.PatientResponse{
-		FirstNameTh:  patient.FirstNameTh,
-		MiddleNameTh: patient.MiddleNameTh,
-		LastNameTh:   patient.LastNameTh,
-		FirstNameEn:  patient.FirstNameEn,
-		MiddleNameEn: patient.MiddleNameEn,
-		LastNameEn:   patient.LastNameEn,
-		DateOfBirth:  patient.DateOfBirth,
-		PatientHN:    patient.PatientHN,
-		NationalID:   patient.NationalID,
-		PassportID:   patient.PassportID,
-		PhoneNumber:  patient.PhoneNumber,
-		Email:        patient.Email,
-		Gender:       patient.Gender,
+	entry := auditEntry(c, models.AuditPatientRead, []uint{patient.ID})
+	entry.Filters = map[string]string{"id": masking.NationalID(id)}
+	if !recordAccess(c, entry) {
+		return
 	}
 
-	c.JSON(200, response)
+	c.JSON(200, patientResponse(c, &patient))
 }
 
+// SearchPatients returns one page of the caller's hospital's patients
+// matching the filters, by default oldest registration first.
 func SearchPatients(c *gin.Context) {
-	hospitalID, exists := c.Get("hospital_id")
-	if !exists {
-		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+	var searchRequest models.PatientSearchRequest
+	if !bindQuery(c, &searchRequest) {
 		return
 	}
 
-	var searchRequest models.PatientSearchRequest
-	if err := c.ShouldBindQuery(&searchRequest); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	births, fieldErrors := birthRangeOf(searchRequest, time.Now())
+	if len(fieldErrors) > 0 {
+		rejectFields(c, 400, "Validation failed", fieldErrors)
 		return
 	}
 
-	query := config.DB.Model(&models.Patient{}).Where("hospital_id = ?", hospitalID)
-
-	if searchRequest.NationalID != "" {
-		query = query.Where("national_id LIKE ?", "%"+searchRequest.NationalID+"%")
-	}
-	if searchRequest.PassportID != "" {
-		query = query.Where("passport_id LIKE ?", "%"+searchRequest.PassportID+"%")
+	if searchRequest.Match == models.MatchFuzzy {
+		searchPatientsFuzzy(c, searchRequest, births)
+		return
 	}
+
+	query := tenantDB(c).Model(&models.Patient{}).Scopes(patientFilters(searchRequest, births))
+
 	if searchRequest.FirstName != "" {
 		query = query.Where("first_name_th LIKE ? OR first_name_en LIKE ?",
 			"%"+searchRequest.FirstName+"%", "%"+searchRequest.FirstName+"%")
@@ -70,45 +92,397 @@ func SearchPatients(c *gin.Context) {
 		query = query.Where("last_name_th LIKE ? OR last_name_en LIKE ?",
 			"%"+searchRequest.LastName+"%", "%"+searchRequest.LastName+"%")
 	}
-	if searchRequest.DateOfBirth != nil {
-		query = query.Where("date_of_birth = ?", searchRequest.DateOfBirth)
+
+	patients, pagination, ok := paginate[models.Patient](c, query, searchRequest.PageRequest, models.PatientSortFields, "id")
+	if !ok {
+		return
 	}
-	if searchRequest.PhoneNumber != "" {
-		query = query.Where("phone_number LIKE ?", "%"+searchRequest.PhoneNumber+"%")
+
+	patientIDs := make([]uint, 0, len(patients))
+	responses := make([]models.PatientResponse, 0, len(patients))
+	for _, patient := range patients {
+		patientIDs = append(patientIDs, patient.ID)
+		responses = append(responses, patientResponse(c, &patient))
 	}
-	if searchRequest.Email != "" {
-		query = query.Where("email LIKE ?", "%"+searchRequest.Email+"%")
+	if !recordAccess(c, auditEntry(c, models.AuditPatientSearch, patientIDs)) {
+		return
 	}
 
-	// Execute query
-	var patients []models.Patient
-	if err := query.Find(&patients).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(200, gin.H{"data": []models.PatientResponse{}})
-			return
+	c.JSON(200, gin.H{"data": responses, "pagination": pagination})
+}
+
+// patientFilters applies the search filters other than the names, which
+// depend on the match mode. Identifiers are compared whole by their blind
+// indexes, in the form normalizePatientRequest stores them in, so that they
+// cannot be enumerated by typing a few digits.
+func patientFilters(searchRequest models.PatientSearchRequest, births birthRange) func(*gorm.DB) *gorm.DB {
+	return func(query *gorm.DB) *gorm.DB {
+		phone := searchRequest.PhoneNumber
+		if normalized, ok := validation.NormalizeThaiPhone(phone); ok {
+			phone = normalized
+		}
+		identifiers := []struct {
+			column string
+			value  string
+		}{
+			{"national_id", searchRequest.NationalID},
+			{"passport_id", validation.NormalizePassport(searchRequest.PassportID)},
+			{"phone_number", phone},
+			{"email", validation.NormalizeEmail(searchRequest.Email)},
 		}
-		c.JSON(500, gin.H{"error": "Failed to search patients"})
+		for _, identifier := range identifiers {
+			if identifier.value == "" {
+				continue
+			}
+			index, err := encryption.BlindIndex(identifier.column, identifier.value)
+			if err != nil {
+				query.AddError(err)
+				return query
+			}
+			query = query.Where(identifier.column+"_index = ?", index)
+		}
+
+		if !births.from.IsZero() {
+			query = query.Where("date_of_birth >= ?", births.from)
+		}
+		if !births.to.IsZero() {
+			query = query.Where("date_of_birth < ?", births.to)
+		}
+		return query
+	}
+}
+
+// ShowPatient returns a patient of the caller's hospital by ID.
+func ShowPatient(c *gin.Context) {
+	patient, found := loadPatient(c, tenantDB(c))
+	if !found {
+		return
+	}
+	if !recordAccess(c, auditEntry(c, models.AuditPatientRead, []uint{patient.ID})) {
 		return
 	}
 
-	var responses []models.PatientResponse
-	for _, patient := range patients {
-		responses = append(responses, models.PatientResponse{
-			FirstNameTh:  patient.FirstNameTh,
-			MiddleNameTh: patient.MiddleNameTh,
-			LastNameTh:   patient.LastNameTh,
-			FirstNameEn:  patient.FirstNameEn,
-			MiddleNameEn: patient.MiddleNameEn,
-			LastNameEn:   patient.LastNameEn,
-			DateOfBirth:  patient.DateOfBirth,
-			PatientHN:    patient.PatientHN,
-			NationalID:   patient.NationalID,
-			PassportID:   patient.PassportID,
-			PhoneNumber:  patient.PhoneNumber,
-			Email:        patient.Email,
-			Gender:       patient.Gender,
-		})
-	}
-
-	c.JSON(200, gin.H{"data": responses})
+	c.JSON(200, gin.H{"data": patientResponse(c, &patient)})
+}
+
+// RevealPatient returns a patient with the identifiers and contact details
+// unmasked, for callers without patient:pii. The reason is required and the
+// reveal is recorded, as a security event and in the audit log, before
+// anything is returned.
+func RevealPatient(c *gin.Context) {
+	var request models.PatientRevealRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	patient, found := loadPatient(c, tenantDB(c))
+	if !found {
+		return
+	}
+
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+	actor, _ := c.Get("actor")
+	event := models.SecurityEvent{
+		Type:         models.SecurityEventPatientReveal,
+		HospitalID:   patient.HospitalID,
+		ActorStaffID: &actorID,
+		ClientIP:     c.ClientIP(),
+		Detail:       fmt.Sprintf("patient %d revealed by %v: %s", patient.ID, actor, request.Reason),
+	}
+	entry := auditEntry(c, models.AuditPatientReveal, []uint{patient.ID})
+	entry.Reason = request.Reason
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, &entry)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to record reveal"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": patient.ToResponse()})
+}
+
+// patientResponse is the patient as the caller may see it: identifiers and
+// contact details are masked unless the caller has patient:pii.
+func patientResponse(c *gin.Context, patient *models.Patient) models.PatientResponse {
+	response := patient.ToResponse()
+	if canSeeIdentifiers(c) {
+		return response
+	}
+	return response.Mask()
+}
+
+// canSeeIdentifiers tells whether the caller sees identifiers and contact
+// details unmasked.
+func canSeeIdentifiers(c *gin.Context) bool {
+	return middleware.HasPermission(c, models.PermPatientPII)
+}
+
+// CreatePatient registers a patient in the caller's hospital.
+func CreatePatient(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	var request models.PatientRequest
+	if !bindJSON(c, &request) || !checkChangeReason(c, false) {
+		return
+	}
+
+	patient := models.Patient{HospitalID: hospitalID.(uint)}
+	patient.Apply(normalizePatientRequest(request))
+	if !checkPatient(c, patient) {
+		return
+	}
+
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		hn, err := allocateHN(tx, patient.HospitalID, time.Now())
+		if err != nil {
+			return err
+		}
+		patient.PatientHN = hn
+		if err := tx.Create(&patient).Error; err != nil {
+			return err
+		}
+		return recordChange(c, tx, models.AuditPatientCreate, &patient)
+	})
+	if rejectDuplicatePatient(c, patient, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create patient"})
+		return
+	}
+
+	c.JSON(201, gin.H{"data": patientResponse(c, &patient)})
+}
+
+// UpdatePatient replaces every field of a patient.
+func UpdatePatient(c *gin.Context) {
+	patient, found := loadPatient(c, tenantDB(c))
+	if !found {
+		return
+	}
+
+	var request models.PatientRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	savePatient(c, patient, request)
+}
+
+// PatchPatient changes only the fields present in the body.
+func PatchPatient(c *gin.Context) {
+	patient, found := loadPatient(c, tenantDB(c))
+	if !found {
+		return
+	}
+
+	request := patient.ToRequest()
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	savePatient(c, patient, request)
+}
+
+func savePatient(c *gin.Context, patient models.Patient, request models.PatientRequest) {
+	if !checkChangeReason(c, true) {
+		return
+	}
+
+	patient.Apply(normalizePatientRequest(request))
+	if !checkPatient(c, patient) {
+		return
+	}
+
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&patient).Error; err != nil {
+			return err
+		}
+		return recordChange(c, tx, models.AuditPatientUpdate, &patient)
+	})
+	if rejectDuplicatePatient(c, patient, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update patient"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": patientResponse(c, &patient)})
+}
+
+// DeletePatient soft deletes a patient. It can be undone with RestorePatient.
+func DeletePatient(c *gin.Context) {
+	patient, found := loadPatient(c, tenantDB(c))
+	if !found || !checkChangeReason(c, true) {
+		return
+	}
+
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&patient).Error; err != nil {
+			return err
+		}
+		return recordChange(c, tx, models.AuditPatientDelete, &patient)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete patient"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Patient deleted"})
+}
+
+// RestorePatient brings back a soft deleted patient.
+func RestorePatient(c *gin.Context) {
+	patient, found := loadPatient(c, tenantDB(c).Unscoped())
+	if !found || !checkChangeReason(c, true) {
+		return
+	}
+	if !patient.DeletedAt.Valid {
+		c.JSON(409, gin.H{"error": "Patient is not deleted"})
+		return
+	}
+
+	// The restored patient must not clash with one registered since.
+	if !checkPatient(c, patient) {
+		return
+	}
+
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&patient).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return recordChange(c, tx, models.AuditPatientRestore, &patient)
+	})
+	if rejectDuplicatePatient(c, patient, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to restore patient"})
+		return
+	}
+	patient.DeletedAt = gorm.DeletedAt{}
+
+	c.JSON(200, gin.H{"data": patientResponse(c, &patient)})
+}
+
+// normalizePatientRequest stores identifiers in one form so that lookups and
+// the uniqueness checks match however they were typed: passports upper case
+// without separators, phone numbers in E.164, emails in lower case and dates
+// of birth as the calendar date at midnight UTC.
+func normalizePatientRequest(request models.PatientRequest) models.PatientRequest {
+	request.DateOfBirth = validation.DateOnly(request.DateOfBirth)
+	request.PassportID = validation.NormalizePassport(request.PassportID)
+	request.Email = validation.NormalizeEmail(request.Email)
+	if phone, ok := validation.NormalizeThaiPhone(request.PhoneNumber); ok {
+		request.PhoneNumber = phone
+	}
+	return request
+}
+
+// loadPatient loads the patient named by the :id parameter, writing a 404
+// response if there is none in the caller's hospital.
+func loadPatient(c *gin.Context, db *gorm.DB) (models.Patient, bool) {
+	var patient models.Patient
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Patient not found"})
+		return patient, false
+	}
+
+	if err := db.First(&patient, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "Patient not found"})
+			return patient, false
+		}
+		c.JSON(500, gin.H{"error": "Failed to load patient"})
+		return patient, false
+	}
+	return patient, true
+}
+
+// checkPatient applies the rules that need more than the binding tags: a
+// date of birth in the past and national and passport IDs unique within the
+// hospital. HNs are unique by construction, see allocateHN. It
+// writes the error response and returns false if the patient is rejected.
+func checkPatient(c *gin.Context, patient models.Patient) bool {
+	if patient.DateOfBirth.After(time.Now()) {
+		rejectFields(c, 400, "Validation failed", []models.FieldError{{
+			Field: "date_of_birth",
+			Rule:  "past",
+		}})
+		return false
+	}
+
+	conflicts, err := patientConflicts(c, patient)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to check patient"})
+		return false
+	}
+	if len(conflicts) > 0 {
+		rejectFields(c, 409, "Patient already exists", conflicts)
+		return false
+	}
+	return true
+}
+
+// patientConflicts returns the identifiers of patient that another patient
+// of the hospital, not deleted, already has.
+func patientConflicts(c *gin.Context, patient models.Patient) ([]models.FieldError, error) {
+	if err := patient.SetBlindIndexes(); err != nil {
+		return nil, err
+	}
+	identifiers := []struct {
+		field string
+		index string
+	}{
+		{"national_id", patient.NationalIDIndex},
+		{"passport_id", patient.PassportIDIndex},
+	}
+
+	conflicts := []models.FieldError{}
+	for _, identifier := range identifiers {
+		if identifier.index == "" {
+			continue
+		}
+
+		var count int64
+		if err := tenantDB(c).Model(&models.Patient{}).
+			Where(identifier.field+"_index = ? AND id <> ?", identifier.index, patient.ID).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			conflicts = append(conflicts, models.FieldError{
+				Field: identifier.field,
+				Rule:  "unique",
+			})
+		}
+	}
+	return conflicts, nil
+}
+
+// rejectDuplicatePatient writes the 409 response for a write that broke the
+// unique indexes of patients, because another patient with the same
+// identifiers was saved after checkPatient looked. It returns false if err
+// is any other error.
+func rejectDuplicatePatient(c *gin.Context, patient models.Patient, err error) bool {
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		return false
+	}
+	conflicts, err := patientConflicts(c, patient)
+	if err != nil {
+		conflicts = []models.FieldError{}
+	}
+	rejectFields(c, 409, "Patient already exists", conflicts)
+	return true
 }
@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/audit"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ChangeReasonHeader carries the reason for a change to a patient, which is
+// kept with its revision and audit entry.
+const ChangeReasonHeader = "X-Change-Reason"
+
+// minChangeReasonLength and maxChangeReasonLength bound the reason for a
+// change, like the reason for a reveal.
+const (
+	minChangeReasonLength = 10
+	maxChangeReasonLength = 500
+)
+
+// checkChangeReason rejects a change whose reason is missing, too short or
+// too long. A reason is required to change, delete or restore a patient and
+// optional when registering one. It writes the error response and returns
+// false if the change is rejected.
+func checkChangeReason(c *gin.Context, required bool) bool {
+	reason := strings.TrimSpace(c.GetHeader(ChangeReasonHeader))
+	length := len([]rune(reason))
+
+	var fieldErr models.FieldError
+	switch {
+	case reason == "" && !required:
+		return true
+	case reason == "":
+		fieldErr = models.FieldError{Field: ChangeReasonHeader, Rule: "required"}
+	case length < minChangeReasonLength:
+		fieldErr = models.FieldError{Field: ChangeReasonHeader, Rule: "min", Param: strconv.Itoa(minChangeReasonLength)}
+	case length > maxChangeReasonLength:
+		fieldErr = models.FieldError{Field: ChangeReasonHeader, Rule: "max", Param: strconv.Itoa(maxChangeReasonLength)}
+	default:
+		return true
+	}
+	rejectFields(c, 400, "Validation failed", []models.FieldError{fieldErr})
+	return false
+}
+
+// recordChange records a change to a patient in its revisions and the
+// audit log, with the reason from ChangeReasonHeader. It runs in the
+// transaction of the change, so that none is kept without the others.
+func recordChange(c *gin.Context, tx *gorm.DB, action string, patient *models.Patient) error {
+	if err := recordRevision(c, tx, action, patient); err != nil {
+		return err
+	}
+	entry := auditEntry(c, action, []uint{patient.ID})
+	entry.Reason = strings.TrimSpace(c.GetHeader(ChangeReasonHeader))
+	return audit.Record(tx, &entry)
+}
+
+// recordRevision stores the patient's record after a change as its next
+// revision, with the fields changed since the previous one. Updates that
+// change nothing add no revision. It must run in the transaction of the
+// change: the patient's row is locked by then, so versions are handed out
+// one at a time.
+func recordRevision(c *gin.Context, tx *gorm.DB, action string, patient *models.Patient) error {
+	var previous models.PatientRevision
+	if err := tx.Where("patient_id = ?", patient.ID).Order("version DESC").Limit(1).Find(&previous).Error; err != nil {
+		return err
+	}
+
+	snapshot := patient.Snapshot()
+	changedFields := []string{}
+	if previous.Version == 0 {
+		for _, change := range (models.PatientSnapshot{}).Changes(snapshot) {
+			changedFields = append(changedFields, change.Field)
+		}
+	} else if action == models.AuditPatientUpdate {
+		previousSnapshot, err := previous.PatientSnapshot()
+		if err != nil {
+			return err
+		}
+		for _, change := range previousSnapshot.Changes(snapshot) {
+			changedFields = append(changedFields, change.Field)
+		}
+		if len(changedFields) == 0 {
+			return nil
+		}
+	}
+
+	revision := models.PatientRevision{
+		HospitalID:    patient.HospitalID,
+		PatientID:     patient.ID,
+		Version:       previous.Version + 1,
+		Action:        action,
+		Actor:         c.GetString("actor"),
+		Reason:        strings.TrimSpace(c.GetHeader(ChangeReasonHeader)),
+		ChangedFields: changedFields,
+	}
+	if staffID, ok := c.Get("staff_id"); ok {
+		if id, ok := staffID.(uint); ok {
+			revision.StaffID = &id
+		}
+	}
+	if err := revision.SetSnapshot(snapshot); err != nil {
+		return err
+	}
+	return tx.Create(&revision).Error
+}
+
+// ListPatientRevisions returns one page of a patient's revisions, newest
+// first. Deleted patients keep their history.
+func ListPatientRevisions(c *gin.Context) {
+	var request models.PageRequest
+	if !bindQuery(c, &request) {
+		return
+	}
+
+	patient, found := loadPatient(c, tenantDB(c).Unscoped())
+	if !found {
+		return
+	}
+
+	query := tenantDB(c).Model(&models.PatientRevision{}).Where("patient_id = ?", patient.ID)
+	revisions, pagination, ok := paginate[models.PatientRevision](c, query, request, models.PatientRevisionSortFields, "-version")
+	if !ok {
+		return
+	}
+	if !recordAccess(c, auditEntry(c, models.AuditPatientHistory, []uint{patient.ID})) {
+		return
+	}
+
+	c.JSON(200, gin.H{"data": revisions, "pagination": pagination})
+}
+
+// DiffPatientRevisions lists the fields that differ between two revisions
+// of a patient. Identifiers are masked as in patientResponse.
+func DiffPatientRevisions(c *gin.Context) {
+	var request models.PatientRevisionDiffRequest
+	if !bindQuery(c, &request) {
+		return
+	}
+
+	patient, found := loadPatient(c, tenantDB(c).Unscoped())
+	if !found {
+		return
+	}
+
+	var revisions []models.PatientRevision
+	if err := tenantDB(c).
+		Where("patient_id = ? AND version IN ?", patient.ID, []uint{request.From, request.To}).
+		Find(&revisions).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load revisions"})
+		return
+	}
+	snapshots := map[uint]models.PatientSnapshot{}
+	for _, revision := range revisions {
+		snapshot, err := revision.PatientSnapshot()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load revisions"})
+			return
+		}
+		snapshots[revision.Version] = snapshot
+	}
+	from, fromFound := snapshots[request.From]
+	to, toFound := snapshots[request.To]
+	if !fromFound || !toFound {
+		c.JSON(404, gin.H{"error": "Revision not found"})
+		return
+	}
+
+	changes := from.Changes(to)
+	masked := !canSeeIdentifiers(c)
+	if masked {
+		for i, change := range changes {
+			if mask, ok := identifierMasks[change.Field]; ok {
+				changes[i].From = maskValue(mask, change.From)
+				changes[i].To = maskValue(mask, change.To)
+			}
+		}
+	}
+	if !recordAccess(c, auditEntry(c, models.AuditPatientHistory, []uint{patient.ID})) {
+		return
+	}
+
+	c.JSON(200, gin.H{"data": gin.H{
+		"from":    request.From,
+		"to":      request.To,
+		"changes": changes,
+		"masked":  masked,
+	}})
+}
+
+func maskValue(mask func(string) string, value any) any {
+	if s, ok := value.(string); ok {
+		return mask(s)
+	}
+	return value
+}
+
+// GetPatientAsOf returns a patient's record as it was at a point in time,
+// from the last revision made by then.
+func GetPatientAsOf(c *gin.Context) {
+	var request models.PatientAsOfRequest
+	if !bindQuery(c, &request) {
+		return
+	}
+
+	patient, found := loadPatient(c, tenantDB(c).Unscoped())
+	if !found {
+		return
+	}
+
+	var revision models.PatientRevision
+	if err := tenantDB(c).
+		Where("patient_id = ? AND created_at <= ?", patient.ID, request.At).
+		Order("version DESC").
+		First(&revision).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "Patient was not registered at that time"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to load revisions"})
+		return
+	}
+	if revision.Action == models.AuditPatientDelete {
+		c.JSON(404, gin.H{"error": "Patient was deleted at that time"})
+		return
+	}
+
+	snapshot, err := revision.PatientSnapshot()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load revisions"})
+		return
+	}
+	if !recordAccess(c, auditEntry(c, models.AuditPatientHistory, []uint{patient.ID})) {
+		return
+	}
+
+	asOf := snapshot.Patient(patient.ID, patient.HospitalID)
+	c.JSON(200, gin.H{"data": patientResponse(c, &asOf), "revision": revision})
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errUnknownRole = errors.New("unknown role")
+
+// findRoles loads the roles with the given names, failing with errUnknownRole
+// if any of them does not exist.
+func findRoles(db *gorm.DB, names []string) ([]models.Role, error) {
+	var roles []models.Role
+	if len(names) == 0 {
+		return roles, nil
+	}
+
+	if err := db.Where("name IN ?", names).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	if len(roles) != len(names) {
+		return nil, errUnknownRole
+	}
+	return roles, nil
+}
+
+func ListRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := config.DB.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list roles"})
+		return
+	}
+
+	responses := []models.RoleResponse{}
+	for _, role := range roles {
+		responses = append(responses, role.ToResponse())
+	}
+
+	c.JSON(200, gin.H{"data": responses})
+}
+
+// AssignStaffRoles replaces the roles of a staff member. Admins can only
+// manage staff of their own hospital. The staff member's sessions are
+// revoked, since JWT access tokens carry the roles they were issued with.
+func AssignStaffRoles(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	staffID, _ := c.Get("staff_id")
+
+	var request models.StaffRoleAssignRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).First(&staff).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+
+	roles, err := findRoles(config.DB, request.Roles)
+	if err == errUnknownRole {
+		c.JSON(400, gin.H{"error": "Unknown role"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load roles"})
+		return
+	}
+
+	staff.Roles = roles
+	if staff.ID == staffID && !staff.HasRole(models.RoleAdmin) {
+		c.JSON(400, gin.H{"error": "Cannot remove your own admin role"})
+		return
+	}
+
+	if err := config.DB.Model(&staff).Association("Roles").Replace(roles); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to assign roles"})
+		return
+	}
+	if _, err := revokeTokens(config.DB.Where("staff_id = ?", staff.ID)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": staff.ToResponse()})
+}
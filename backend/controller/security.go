@@ -0,0 +1,16 @@
+package controller
+
+import (
+	"log"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+)
+
+// recordSecurityEvent stores an authentication event. Failing to record it
+// must not fail the request, so errors are only logged.
+func recordSecurityEvent(event models.SecurityEvent) {
+	if err := config.DB.Create(&event).Error; err != nil {
+		log.Printf("Failed to record security event %s: %v", event.Type, err)
+	}
+}
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListServiceIdentities returns the client certificate identities of the
+// caller's hospital.
+func ListServiceIdentities(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	var identities []models.ServiceIdentity
+	if err := config.DB.Where("hospital_id = ?", hospitalID).Order("name").Find(&identities).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list service identities"})
+		return
+	}
+
+	responses := []models.ServiceIdentityResponse{}
+	for _, identity := range identities {
+		responses = append(responses, identity.ToResponse())
+	}
+
+	c.JSON(200, gin.H{"data": responses})
+}
+
+// CreateServiceIdentity registers a client certificate of a calling system
+// of the caller's hospital. The identity only authenticates once the system
+// has confirmed it with VerifyServiceIdentity, using the verification code
+// that is only returned in this response.
+func CreateServiceIdentity(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var request models.ServiceIdentityRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	scopes, ok := checkAPIKeyScopes(c, request.Scopes)
+	if !ok {
+		return
+	}
+
+	cert, err := auth.ParseCertificatePEM(request.Certificate)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "certificate must be a PEM encoded certificate"})
+		return
+	}
+	if config.TLS.ClientCAs != nil && auth.VerifyClientCertificate(cert, config.TLS.ClientCAs, time.Now()) != nil {
+		c.JSON(400, gin.H{"error": "certificate is not a client certificate issued by a trusted CA"})
+		return
+	}
+	issuer, subject := auth.CertificateIdentity(cert)
+	if subject == "" {
+		c.JSON(400, gin.H{"error": "certificate has no subject"})
+		return
+	}
+
+	code, err := auth.GenerateToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate verification code"})
+		return
+	}
+
+	identity := models.ServiceIdentity{
+		HospitalID:           hospitalID.(uint),
+		Name:                 request.Name,
+		CertificateIssuer:    issuer,
+		CertificateSubject:   subject,
+		VerificationCodeHash: auth.HashToken(config.Auth.TokenHashKey, code),
+		Scopes:               strings.Join(scopes, ","),
+	}
+	if err := config.DB.Create(&identity).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(409, gin.H{"error": "Certificate already registered"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to save service identity"})
+		return
+	}
+
+	recordServiceIdentityEvent(c, models.SecurityEventServiceIdentityCreated, identity, &actorID)
+
+	c.JSON(201, gin.H{"data": models.ServiceIdentityCreatedResponse{
+		ServiceIdentityResponse: identity.ToResponse(),
+		VerificationCode:        code,
+	}})
+}
+
+// VerifyServiceIdentity confirms a service identity: the request carries
+// the identity's client certificate, proving the caller holds its key, and
+// the verification code the hospital's admin was given. A certificate can
+// only be verified for one hospital.
+func VerifyServiceIdentity(c *gin.Context) {
+	var request models.ServiceIdentityVerifyRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	issuer, subject := auth.CertificateIdentity(c.MustGet("client_certificate").(*x509.Certificate))
+
+	var identity models.ServiceIdentity
+	if err := config.DB.Where(
+		"verification_code_hash = ? AND certificate_issuer = ? AND certificate_subject = ? AND verified_at IS NULL",
+		auth.HashToken(config.Auth.TokenHashKey, request.Code), issuer, subject,
+	).First(&identity).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid verification code"})
+		return
+	}
+
+	now := time.Now()
+	if err := config.DB.Model(&identity).Updates(map[string]interface{}{
+		"verified_at":            now,
+		"verification_code_hash": "",
+	}).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(409, gin.H{"error": "Certificate already verified for another hospital"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to verify service identity"})
+		return
+	}
+
+	recordServiceIdentityEvent(c, models.SecurityEventServiceIdentityVerified, identity, nil)
+
+	c.JSON(200, gin.H{"data": identity.ToResponse()})
+}
+
+// DeleteServiceIdentity stops accepting a client certificate.
+func DeleteServiceIdentity(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+	actorStaffID, _ := c.Get("staff_id")
+	actorID, _ := actorStaffID.(uint)
+
+	var identity models.ServiceIdentity
+	if err := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).First(&identity).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Service identity not found"})
+		return
+	}
+
+	// Deleted for good so the certificate can be registered again.
+	if err := config.DB.Unscoped().Delete(&identity).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete service identity"})
+		return
+	}
+
+	recordServiceIdentityEvent(c, models.SecurityEventServiceIdentityDeleted, identity, &actorID)
+
+	c.JSON(200, gin.H{"message": "Service identity deleted"})
+}
+
+func recordServiceIdentityEvent(c *gin.Context, eventType string, identity models.ServiceIdentity, actorID *uint) {
+	recordSecurityEvent(models.SecurityEvent{
+		Type:         eventType,
+		HospitalID:   identity.HospitalID,
+		ActorStaffID: actorID,
+		ClientIP:     c.ClientIP(),
+		Detail:       identity.CertificateSubject + " " + identity.Name,
+	})
+}
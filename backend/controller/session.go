@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+)
+
+// LogoutStaff revokes the token used to authenticate the request.
+func LogoutStaff(c *gin.Context) {
+	tokenID, exists := c.Get("token_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Token ID not found in context"})
+		return
+	}
+
+	if _, err := revokeTokens(config.DB.Where("id = ?", tokenID)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Logged out"})
+}
+
+// ListSessions returns the caller's active (unexpired, unrevoked) tokens.
+func ListSessions(c *gin.Context) {
+	staffID, exists := c.Get("staff_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Staff ID not found in context"})
+		return
+	}
+	tokenID, _ := c.Get("token_id")
+	currentTokenID, _ := tokenID.(uint)
+
+	var tokens []models.Token
+	if err := config.DB.
+		Where("staff_id = ? AND (expires_at > ? OR refresh_expires_at > ?)", staffID, time.Now(), time.Now()).
+		Order("created_at DESC").
+		Find(&tokens).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	responses := []models.SessionResponse{}
+	for _, token := range tokens {
+		responses = append(responses, token.ToSessionResponse(currentTokenID))
+	}
+
+	c.JSON(200, gin.H{"data": responses})
+}
+
+// RevokeSession revokes one of the caller's own sessions.
+func RevokeSession(c *gin.Context) {
+	staffID, exists := c.Get("staff_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Staff ID not found in context"})
+		return
+	}
+
+	revoked, err := revokeTokens(config.DB.Where("id = ? AND staff_id = ?", c.Param("id"), staffID))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if revoked == 0 {
+		c.JSON(404, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Session revoked"})
+}
+
+// RevokeStaffSessions lets an admin revoke every session of a staff member of
+// the same hospital, e.g. after a lost device.
+func RevokeStaffSessions(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.Where("id = ? AND hospital_id = ?", c.Param("id"), hospitalID).First(&staff).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Staff not found"})
+		return
+	}
+
+	revoked, err := revokeTokens(config.DB.Where("staff_id = ?", staff.ID))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": gin.H{"revoked": revoked}})
+}
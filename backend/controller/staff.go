@@ -1,8 +1,9 @@
 package controller
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"crypto/subtle"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
@@ -11,13 +12,57 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// CreateStaff onboards a new staff member. Only an authenticated admin can
+// call it, and only for their own hospital.
 func CreateStaff(c *gin.Context) {
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		c.JSON(500, gin.H{"error": "Hospital ID not found in context"})
+		return
+	}
+
+	var request models.StaffCreateRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	if request.HospitalID != hospitalID {
+		c.JSON(403, gin.H{"error": "Cannot create staff for another hospital"})
+		return
+	}
+
+	if !checkPasswordPolicy(c, "password", request.Password, request.Username) {
+		return
+	}
+
+	insertStaff(c, request, false)
+}
+
+// BootstrapStaff creates the first admin account of a hospital that has no
+// admin yet, which includes hospitals whose staff predate roles. It is
+// guarded by the BOOTSTRAP_TOKEN shared secret.
+func BootstrapStaff(c *gin.Context) {
+	bootstrapToken := c.GetHeader("X-Bootstrap-Token")
+	if config.Auth.BootstrapToken == "" ||
+		subtle.ConstantTimeCompare([]byte(bootstrapToken), []byte(config.Auth.BootstrapToken)) != 1 {
+		c.JSON(403, gin.H{"error": "Invalid bootstrap token"})
+		return
+	}
+
 	var request models.StaffCreateRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	if !bindJSON(c, &request) {
+		return
+	}
+	request.Roles = []string{models.RoleAdmin}
+
+	if !checkPasswordPolicy(c, "password", request.Password, request.Username) {
 		return
 	}
 
+	insertStaff(c, request, true)
+}
+
+func insertStaff(c *gin.Context, request models.StaffCreateRequest, firstAdminOnly bool) {
 	tx := config.DB.Begin()
 
 	var hospital models.Hospital
@@ -27,12 +72,43 @@ func CreateStaff(c *gin.Context) {
 		return
 	}
 
+	if firstAdminOnly {
+		var adminCount int64
+		if err := tx.Model(&models.Staff{}).
+			Joins("JOIN staff_roles ON staff_roles.staff_id = staffs.id").
+			Joins("JOIN roles ON roles.id = staff_roles.role_id").
+			Where("staffs.hospital_id = ? AND roles.name = ?", request.HospitalID, models.RoleAdmin).
+			Count(&adminCount).Error; err != nil {
+			tx.Rollback()
+			c.JSON(500, gin.H{"error": "Failed to check hospital staff"})
+			return
+		}
+		if adminCount > 0 {
+			tx.Rollback()
+			c.JSON(409, gin.H{"error": "Hospital already has an admin"})
+			return
+		}
+	}
+
 	var existingStaff models.Staff
-	if err := config.DB.Where("username = ?", request.Username).First(&existingStaff).Error; err == nil {
+	if err := tx.Where("username = ?", request.Username).First(&existingStaff).Error; err == nil {
+		tx.Rollback()
 		c.JSON(400, gin.H{"error": "Username already exists"})
 		return
 	}
 
+	roles, err := findRoles(tx, request.Roles)
+	if err == errUnknownRole {
+		tx.Rollback()
+		c.JSON(400, gin.H{"error": "Unknown role"})
+		return
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to load roles"})
+		return
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
 	if err != nil {
 		tx.Rollback()
@@ -40,12 +116,18 @@ func CreateStaff(c *gin.Context) {
 		return
 	}
 
+	now := time.Now()
 	staff := models.Staff{
-		Username:   request.Username,
-		Password:   string(hashedPassword),
-		Name:       request.Name,
-		Email:      request.Email,
-		HospitalID: request.HospitalID,
+		Username:          request.Username,
+		Password:          string(hashedPassword),
+		Name:              request.Name,
+		Email:             request.Email,
+		HospitalID:        request.HospitalID,
+		Roles:             roles,
+		PasswordChangedAt: &now,
+		// Staff onboarded by an admin replace the password the admin chose
+		// at their first login; a bootstrapped admin chose their own.
+		MustChangePassword: !firstAdminOnly,
 	}
 
 	if err := tx.Create(&staff).Error; err != nil {
@@ -59,49 +141,52 @@ func CreateStaff(c *gin.Context) {
 	c.JSON(201, gin.H{"data": staff.ToResponse()})
 }
 
+// dummyPasswordHash is compared against when the username does not exist, so
+// a failed login takes as long whether or not the account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
 func LoginStaff(c *gin.Context) {
 	var request models.StaffLoginRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	// Locks are keyed by the submitted username, not the account, so the
+	// answer is the same for usernames that do not exist.
+	if lockedUntil := loginLockedUntil(userAttemptKey(request.HospitalID, request.Username), ipAttemptKey(c.ClientIP())); lockedUntil != nil {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(*lockedUntil).Seconds()))))
+		c.JSON(429, gin.H{"error": "Too many failed login attempts, try again later"})
 		return
 	}
 
 	var staff models.Staff
-	if err := config.DB.Where("username = ? AND hospital_id = ?", request.Username, request.HospitalID).First(&staff).Error; err != nil {
+	if err := config.DB.Preload("Roles.Permissions").Preload("Hospital").Where("username = ? AND hospital_id = ?", request.Username, request.HospitalID).First(&staff).Error; err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(request.Password))
+		registerLoginFailure(c, request)
 		c.JSON(401, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(staff.Password), []byte(request.Password)); err != nil {
+		registerLoginFailure(c, request)
 		c.JSON(401, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to generate token"})
+	// With MFA the failures are kept until the second step succeeds, so
+	// that the right password cannot reset the count of wrong codes.
+	if staff.TOTPEnabled {
+		startMFAChallenge(c, staff)
 		return
 	}
-	tokenString := hex.EncodeToString(tokenBytes)
 
-	expiresAt := time.Now().Add(24 * time.Hour)
+	clearLoginFailures(request.HospitalID, request.Username)
 
-	token := models.Token{
-		Token:      tokenString,
-		StaffID:    staff.ID,
-		HospitalID: staff.HospitalID,
-		ExpiresAt:  expiresAt,
-	}
-	if err := config.DB.Create(&token).Error; err != nil {
+	response, err := issueToken(config.DB, c, staff, "")
+	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to save token"})
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"data": models.TokenResponse{
-			Token:     tokenString,
-			ExpiresAt: expiresAt,
-			Staff:     staff.ToResponse(),
-		},
-	})
+	c.JSON(200, gin.H{"data": response})
 }
@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// issueToken stores a new access/refresh token pair for the staff member.
+// An empty familyID starts a new token family, i.e. a new login. The staff
+// member's roles with their permissions and hospital must be preloaded.
+func issueToken(db *gorm.DB, c *gin.Context, staff models.Staff, familyID string) (models.TokenResponse, error) {
+	accessToken, err := auth.GenerateToken()
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	refreshToken, err := auth.GenerateToken()
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	if familyID == "" {
+		if familyID, err = auth.GenerateToken(); err != nil {
+			return models.TokenResponse{}, err
+		}
+	}
+
+	now := time.Now()
+	var accessJTI string
+	if config.Auth.JWTKeys != nil {
+		if accessJTI, err = auth.GenerateToken(); err != nil {
+			return models.TokenResponse{}, err
+		}
+	}
+
+	token := models.Token{
+		TokenHash:        auth.HashToken(config.Auth.TokenHashKey, accessToken),
+		RefreshTokenHash: auth.HashToken(config.Auth.TokenHashKey, refreshToken),
+		FamilyID:         familyID,
+		AccessJTI:        accessJTI,
+		StaffID:          staff.ID,
+		HospitalID:       staff.HospitalID,
+		ExpiresAt:        now.Add(config.Auth.AccessTokenTTL),
+		RefreshExpiresAt: now.Add(config.Auth.RefreshTokenTTL),
+		ClientIP:         c.ClientIP(),
+		UserAgent:        c.Request.UserAgent(),
+	}
+	if err := db.Create(&token).Error; err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	// In JWT mode the opaque access token is never handed out; the client
+	// gets a signed token bound to the same session instead.
+	if config.Auth.JWTKeys != nil {
+		accessToken, err = config.Auth.JWTKeys.Sign(auth.StaffClaims{
+			ID:          accessJTI,
+			SessionID:   token.ID,
+			StaffID:     staff.ID,
+			HospitalID:  staff.HospitalID,
+			Roles:       staff.RoleNames(),
+			Permissions: staff.PermissionNames(),
+			IssuedAt:    now.Unix(),
+			ExpiresAt:   token.ExpiresAt.Unix(),
+
+			PasswordChangeRequired: staff.PasswordChangeRequired(config.Auth.PasswordPolicy, now),
+			MFAEnrollmentRequired:  staff.MFAEnrollmentRequired(),
+		})
+		if err != nil {
+			return models.TokenResponse{}, err
+		}
+	}
+
+	return models.TokenResponse{
+		Token:            accessToken,
+		ExpiresAt:        token.ExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: token.RefreshExpiresAt,
+		Staff:            staff.ToResponse(),
+
+		PasswordChangeRequired: staff.PasswordChangeRequired(config.Auth.PasswordPolicy, now),
+		MFAEnrollmentRequired:  staff.MFAEnrollmentRequired(),
+	}, nil
+}
+
+// RefreshToken rotates a refresh token: the presented token is revoked and a
+// new access/refresh pair of the same family is issued. Presenting a refresh
+// token that was already rotated means it leaked, so the whole family is
+// revoked.
+func RefreshToken(c *gin.Context) {
+	var request models.TokenRefreshRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	var token models.Token
+	refreshTokenHash := auth.HashToken(config.Auth.TokenHashKey, request.RefreshToken)
+	if err := config.DB.Unscoped().Where("refresh_token_hash = ?", refreshTokenHash).First(&token).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if token.DeletedAt.Valid {
+		revokeTokenFamily(token.FamilyID)
+		c.JSON(401, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if token.RefreshExpiresAt.Before(time.Now()) {
+		c.JSON(401, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	var staff models.Staff
+	if err := config.DB.Preload("Roles.Permissions").Preload("Hospital").First(&staff, token.StaffID).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	tx := config.DB.Begin()
+
+	// Only one request may rotate a given refresh token; a concurrent loser
+	// is treated as reuse.
+	result := tx.Delete(&token)
+	if result.Error != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to rotate token"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		revokeTokenFamily(token.FamilyID)
+		c.JSON(401, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	response, err := issueToken(tx, c, staff, token.FamilyID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to save token"})
+		return
+	}
+
+	tx.Commit()
+
+	revokeAccessToken(token)
+
+	c.JSON(200, gin.H{"data": response})
+}
+
+func revokeTokenFamily(familyID string) {
+	if familyID == "" {
+		return
+	}
+	revokeTokens(config.DB.Where("family_id = ?", familyID))
+}
+
+// revokeTokens deletes the tokens matched by query and puts the JWT access
+// tokens issued for them on the revocation list.
+func revokeTokens(query *gorm.DB) (int64, error) {
+	var tokens []models.Token
+	if err := query.Find(&tokens).Error; err != nil {
+		return 0, err
+	}
+	if len(tokens) == 0 {
+		return 0, nil
+	}
+
+	for _, token := range tokens {
+		if err := revokeAccessToken(token); err != nil {
+			return 0, err
+		}
+	}
+
+	result := config.DB.Delete(&tokens)
+	return result.RowsAffected, result.Error
+}
+
+func revokeAccessToken(token models.Token) error {
+	if token.AccessJTI == "" || !token.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+
+	revokedToken := models.RevokedToken{JTI: token.AccessJTI, ExpiresAt: token.ExpiresAt}
+	if err := config.DB.Create(&revokedToken).Error; err != nil {
+		return err
+	}
+	config.Auth.Revocations.Add(token.AccessJTI, token.ExpiresAt)
+	return nil
+}
+
+// JWKS publishes the public keys that verify JWT access tokens.
+func JWKS(c *gin.Context) {
+	if config.Auth.JWTKeys == nil {
+		c.JSON(404, gin.H{"error": "JWT mode is not enabled"})
+		return
+	}
+
+	c.JSON(200, config.Auth.JWTKeys.JWKS())
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Run the rules of both the binding and the validate tags.
+	structValidator, err := validation.NewStructValidator()
+	if err != nil {
+		panic(err)
+	}
+	binding.Validator = structValidator
+}
+
+// bindJSON binds the request body into request and on failure writes a 400
+// response listing the rejected fields.
+func bindJSON(c *gin.Context, request interface{}) bool {
+	return bindResult(c, c.ShouldBindJSON(request))
+}
+
+// bindQuery is bindJSON for query parameters.
+func bindQuery(c *gin.Context, request interface{}) bool {
+	return bindResult(c, c.ShouldBindQuery(request))
+}
+
+func bindResult(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	fieldErrors := fieldErrorsOf(err, requestLanguage(c))
+	if len(fieldErrors) == 0 {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return false
+	}
+	rejectFields(c, 400, "Validation failed", fieldErrors)
+	return false
+}
+
+// fieldErrorsOf turns validation and JSON type errors into field errors with
+// messages in language. It returns nil for any other error, e.g. malformed
+// JSON.
+func fieldErrorsOf(err error, language string) []models.FieldError {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fieldErrors := make([]models.FieldError, 0, len(validationErrors))
+		for _, fieldErr := range validationErrors {
+			fieldErrors = append(fieldErrors, validationFieldError(fieldErr, language))
+		}
+		return fieldErrors
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []models.FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: fieldMessage(language, "type", typeErr.Type.String()),
+		}}
+	}
+
+	return nil
+}
+
+func validationFieldError(fieldErr validator.FieldError, language string) models.FieldError {
+	rule, param := fieldErr.Tag(), fieldErr.Param()
+	message := rule
+	switch rule {
+	case "required_without", "required_with", "gtfield":
+		param = toSnakeCase(param)
+	case "gender":
+		param = strings.Join(models.Genders, " ")
+	case "min", "max":
+		switch fieldErr.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			message = rule + "_number"
+		}
+	}
+
+	return models.FieldError{
+		Field:   fieldErr.Field(),
+		Rule:    rule,
+		Param:   param,
+		Message: fieldMessage(language, message, param),
+	}
+}
+
+// toSnakeCase converts a Go field name used as a validator parameter to the
+// JSON name clients see, e.g. PassportID to passport_id.
+func toSnakeCase(name string) string {
+	var builder strings.Builder
+	for i, r := range name {
+		upper := r >= 'A' && r <= 'Z'
+		if upper && i > 0 {
+			previousLower := name[i-1] >= 'a' && name[i-1] <= 'z'
+			nextLower := i+1 < len(name) && name[i+1] >= 'a' && name[i+1] <= 'z'
+			if previousLower || nextLower {
+				builder.WriteByte('_')
+			}
+		}
+		if upper {
+			r += 'a' - 'A'
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
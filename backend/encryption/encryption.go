@@ -0,0 +1,185 @@
+// Package encryption encrypts sensitive columns at rest with envelope
+// encryption. Values are sealed with AES-GCM under a data key, which is
+// itself wrapped by a key encryption key held by a KeyProvider and stored
+// with the value:
+//
+//	enc:v1:<key ID>:<wrapped data key>:<nonce and ciphertext>
+//
+// Encrypted values are randomized, so columns that are looked up by value
+// get a blind index next to them: an HMAC of the plaintext under a separate
+// key, see BlindIndex.
+package encryption
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Prefix starts every encrypted value. Values without it are plaintext
+// stored before encryption.
+const Prefix = "enc:v1:"
+
+var (
+	ErrNotConfigured     = errors.New("encryption is not configured")
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+// Cipher encrypts values under data keys wrapped by a KeyProvider. One data
+// key is generated per process and key encryption key, and unwrapped data
+// keys are cached, so the provider is not called for every value.
+type Cipher struct {
+	provider KeyProvider
+	indexKey []byte
+
+	mu        sync.Mutex
+	current   *dataKey
+	unwrapped map[string]cipher.AEAD
+}
+
+type dataKey struct {
+	keyID   string
+	wrapped string
+	aead    cipher.AEAD
+}
+
+// NewCipher returns a cipher for the keys of provider. indexKey keys the
+// blind indexes and must be at least 32 bytes.
+func NewCipher(provider KeyProvider, indexKey []byte) (*Cipher, error) {
+	if len(indexKey) < 32 {
+		return nil, errors.New("blind index key must be at least 32 bytes")
+	}
+	return &Cipher{provider: provider, indexKey: indexKey, unwrapped: map[string]cipher.AEAD{}}, nil
+}
+
+// CurrentKeyID names the key encryption key new values are encrypted under.
+func (c *Cipher) CurrentKeyID() string {
+	return c.provider.CurrentKeyID()
+}
+
+// Encrypt encrypts plaintext. The empty string stays empty.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	key, err := c.currentKey()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key.aead, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return Prefix + key.keyID + ":" + key.wrapped + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value made by Encrypt. Values without Prefix are
+// returned as they are.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(parts) != 3 {
+		return "", ErrInvalidCiphertext
+	}
+	aead, err := c.unwrap(parts[0], parts[1])
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	plaintext, err := open(aead, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// BlindIndex returns the blind index of value in column: an HMAC-SHA256
+// that finds equal values without revealing them. The column is part of the
+// input so that equal values in different columns cannot be linked. The
+// empty string has an empty index.
+func (c *Cipher) BlindIndex(column string, value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(column))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// currentKey returns the data key of the provider's current key, making one
+// when the process starts or the current key changes.
+func (c *Cipher) currentKey() (*dataKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keyID := c.provider.CurrentKeyID()
+	if c.current != nil && c.current.keyID == keyID {
+		return c.current, nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	wrapped, err := c.provider.WrapKey(keyID, secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	c.current = &dataKey{keyID: keyID, wrapped: base64.RawStdEncoding.EncodeToString(wrapped), aead: aead}
+	c.unwrapped[keyID+":"+c.current.wrapped] = aead
+	return c.current, nil
+}
+
+func (c *Cipher) unwrap(keyID string, wrapped string) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if aead, ok := c.unwrapped[keyID+":"+wrapped]; ok {
+		return aead, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	secret, err := c.provider.UnwrapKey(keyID, sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+	c.unwrapped[keyID+":"+wrapped] = aead
+	return aead, nil
+}
+
+// KeyID returns the ID of the key encryption key value is encrypted under,
+// or false for plaintext.
+func KeyID(value string) (string, bool) {
+	if !strings.HasPrefix(value, Prefix) {
+		return "", false
+	}
+	keyID, _, found := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	return keyID, found
+}
@@ -0,0 +1,153 @@
+package encryption
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCipher(t *testing.T, keys ...Key) *Cipher {
+	provider, err := NewLocalKeyProvider(keys)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	cipher, err := NewCipher(provider, bytes.Repeat([]byte{9}, 32))
+	if err != nil {
+		t.Fatalf("Failed to set up cipher: %v", err)
+	}
+	return cipher
+}
+
+var (
+	key1 = Key{ID: "k1", Secret: bytes.Repeat([]byte{1}, 32)}
+	key2 = Key{ID: "k2", Secret: bytes.Repeat([]byte{2}, 32)}
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	cipher := testCipher(t, key1)
+
+	first, err := cipher.Encrypt("1234567890121")
+	assert.NoError(t, err)
+	second, _ := cipher.Encrypt("1234567890121")
+	assert.True(t, strings.HasPrefix(first, Prefix+"k1:"))
+	assert.NotContains(t, first, "1234567890121")
+	// Randomized: equal values encrypt differently
+	assert.NotEqual(t, first, second)
+
+	plaintext, err := cipher.Decrypt(first)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234567890121", plaintext)
+
+	keyID, encrypted := KeyID(first)
+	assert.True(t, encrypted)
+	assert.Equal(t, "k1", keyID)
+
+	// Empty values and plaintext stored before encryption pass through
+	empty, _ := cipher.Encrypt("")
+	assert.Equal(t, "", empty)
+	plaintext, err = cipher.Decrypt("legacy@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy@example.com", plaintext)
+	_, encrypted = KeyID("legacy@example.com")
+	assert.False(t, encrypted)
+
+	// Tampered values are rejected
+	tampered := first[:len(first)-2] + "AA"
+	if tampered == first {
+		tampered = first[:len(first)-2] + "BB"
+	}
+	_, err = cipher.Decrypt(tampered)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+	_, err = cipher.Decrypt(Prefix + "k1:garbage")
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := testCipher(t, key1)
+	value, _ := old.Encrypt("+66891234567")
+
+	// The old key still decrypts once a new key is current
+	rotated := testCipher(t, key2, key1)
+	plaintext, err := rotated.Decrypt(value)
+	assert.NoError(t, err)
+	assert.Equal(t, "+66891234567", plaintext)
+	reencrypted, _ := rotated.Encrypt(plaintext)
+	keyID, _ := KeyID(reencrypted)
+	assert.Equal(t, "k2", keyID)
+
+	// and nothing does once it is removed
+	_, err = testCipher(t, key2).Decrypt(value)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// A key with the same ID but another secret cannot unwrap the data key
+	_, err = testCipher(t, Key{ID: "k1", Secret: bytes.Repeat([]byte{7}, 32)}).Decrypt(value)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
+
+func TestBlindIndex(t *testing.T) {
+	cipher := testCipher(t, key1)
+
+	index := cipher.BlindIndex("national_id", "1234567890121")
+	assert.Len(t, index, 64)
+	assert.Equal(t, index, testCipher(t, key2).BlindIndex("national_id", "1234567890121"))
+	assert.NotEqual(t, index, cipher.BlindIndex("passport_id", "1234567890121"))
+	assert.NotEqual(t, index, cipher.BlindIndex("national_id", "1234567890139"))
+	assert.Equal(t, "", cipher.BlindIndex("national_id", ""))
+
+	_, err := NewCipher(&LocalKeyProvider{}, []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patient.keys")
+	os.WriteFile(path, []byte(`# current key first
+k2=AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=
+
+k1 = AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=
+`), 0600)
+
+	provider, err := LoadKeyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", provider.CurrentKeyID())
+
+	value, _ := testCipher(t, key1).Encrypt("somchai@example.com")
+	cipher, _ := NewCipher(provider, bytes.Repeat([]byte{9}, 32))
+	plaintext, err := cipher.Decrypt(value)
+	assert.NoError(t, err)
+	assert.Equal(t, "somchai@example.com", plaintext)
+
+	invalid := map[string][]Key{
+		"no keys":      nil,
+		"short key":    {{ID: "k1", Secret: []byte("short")}},
+		"bad ID":       {{ID: "k:1", Secret: key1.Secret}},
+		"wildcard ID":  {{ID: "k_1", Secret: key1.Secret}},
+		"duplicate ID": {key1, key1},
+	}
+	for name, keys := range invalid {
+		_, err := NewLocalKeyProvider(keys)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestEncryptedString(t *testing.T) {
+	Default = nil
+	_, err := EncryptedString("1234567890121").Value()
+	assert.ErrorIs(t, err, ErrNotConfigured)
+
+	Default = testCipher(t, key1)
+	defer func() { Default = nil }()
+
+	value, err := EncryptedString("1234567890121").Value()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(value.(string), Prefix))
+
+	var scanned EncryptedString
+	assert.NoError(t, scanned.Scan([]byte(value.(string))))
+	assert.Equal(t, EncryptedString("1234567890121"), scanned)
+	assert.NoError(t, scanned.Scan(nil))
+	assert.Equal(t, EncryptedString(""), scanned)
+}
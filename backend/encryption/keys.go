@@ -0,0 +1,155 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// KeyProvider holds the key encryption keys, like a KMS: data keys are sent
+// to it to be wrapped and unwrapped, and the key encryption keys never leave
+// it. Keys are named by an ID that is stored with every value.
+type KeyProvider interface {
+	// CurrentKeyID names the key new data keys are wrapped with.
+	CurrentKeyID() string
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Key is a 256-bit AES key encryption key.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// keyIDPattern keeps key IDs free of the separator of encrypted values and
+// of LIKE wildcards, so that rows can be selected by key.
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9.-]{1,64}$`)
+
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// LocalKeyProvider wraps data keys with AES-GCM under keys held in memory,
+// typically read from a keyfile by LoadKeyFile.
+type LocalKeyProvider struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewLocalKeyProvider returns a provider for keys. The first key wraps new
+// data keys and every key unwraps, so keys are rotated by adding a new key
+// in front and dropping the old one once ReencryptPatients has run.
+func NewLocalKeyProvider(keys []Key) (*LocalKeyProvider, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+
+	provider := &LocalKeyProvider{current: keys[0].ID, keys: map[string]cipher.AEAD{}}
+	for _, key := range keys {
+		if !keyIDPattern.MatchString(key.ID) {
+			return nil, fmt.Errorf("invalid encryption key ID %q", key.ID)
+		}
+		if _, duplicate := provider.keys[key.ID]; duplicate {
+			return nil, fmt.Errorf("duplicate encryption key ID %q", key.ID)
+		}
+		if len(key.Secret) != 32 {
+			return nil, fmt.Errorf("encryption key %s must be 32 bytes", key.ID)
+		}
+		aead, err := newGCM(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		provider.keys[key.ID] = aead
+	}
+	return provider, nil
+}
+
+// LoadKeyFile reads a keyfile of kid=base64 lines, one 32 byte key each,
+// current key first. Blank lines and lines starting with # are skipped.
+func LoadKeyFile(path string) (*LocalKeyProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var keys []Key
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, errors.New("keyfile lines must be kid=base64")
+		}
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", id, err)
+		}
+		keys = append(keys, Key{ID: strings.TrimSpace(id), Secret: secret})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewLocalKeyProvider(keys)
+}
+
+func (p *LocalKeyProvider) CurrentKeyID() string {
+	return p.current
+}
+
+// WrapKey seals the data key under the key named keyID, which is also
+// authenticated so that a wrapped key cannot be passed off under another.
+func (p *LocalKeyProvider) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return seal(aead, dataKey, []byte(keyID))
+}
+
+func (p *LocalKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under a random nonce, which it puts in front.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
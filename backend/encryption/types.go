@@ -0,0 +1,58 @@
+package encryption
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// Default is the cipher EncryptedString and BlindIndex use, set up by
+// config.LoadEncryptionConfig.
+var Default *Cipher
+
+// EncryptedString is a string column stored encrypted with Default.
+// Plaintext stored before the column was encrypted reads as it is.
+type EncryptedString string
+
+func (s EncryptedString) Value() (driver.Value, error) {
+	if Default == nil {
+		return nil, ErrNotConfigured
+	}
+	return Default.Encrypt(string(s))
+}
+
+func (s *EncryptedString) Scan(value interface{}) error {
+	var stored string
+	switch value := value.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		stored = value
+	case []byte:
+		stored = string(value)
+	default:
+		return fmt.Errorf("cannot scan %T into EncryptedString", value)
+	}
+
+	if Default == nil {
+		return ErrNotConfigured
+	}
+	plaintext, err := Default.Decrypt(stored)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plaintext)
+	return nil
+}
+
+func (EncryptedString) GormDataType() string {
+	return "text"
+}
+
+// BlindIndex is Cipher.BlindIndex with Default.
+func BlindIndex(column string, value string) (string, error) {
+	if Default == nil {
+		return "", ErrNotConfigured
+	}
+	return Default.BlindIndex(column, value), nil
+}
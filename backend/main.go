@@ -1,16 +1,55 @@
 package main
 
 import (
+	"log"
+	"net/http"
+	"time"
+
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/routes"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	router := gin.Default()
+	config.LoadAuthConfig()
+	config.LoadTLSConfig()
+	// The client IP counts failed logins and is kept in security events and
+	// the audit log, so it is only taken from headers set by our proxies.
+	if err := router.SetTrustedProxies(config.TLS.TrustedProxyList()); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+	config.LoadEncryptionConfig()
 	config.ConnectDB()
 	routes.PatientRoutes(router)
 	routes.StaffRoutes(router)
+	routes.AuthRoutes(router)
+	routes.APIKeyRoutes(router)
+	routes.AuditRoutes(router)
+
+	// Re-encrypt patients and revisions still encrypted under an older key.
+	go func() {
+		count, err := config.ReencryptPatients(config.DB)
+		if err != nil {
+			log.Printf("Failed to re-encrypt patients: %v", err)
+		} else if count > 0 {
+			log.Printf("Re-encrypted %d patient records", count)
+		}
+	}()
+
+	if config.Auth.JWTKeys != nil {
+		middleware.WatchRevokedTokens(time.Minute)
+	}
+
+	if config.TLS.Enabled() {
+		server := &http.Server{
+			Addr:      config.TLS.Addr,
+			Handler:   router,
+			TLSConfig: config.TLS.ServerConfig(),
+		}
+		log.Fatal(server.ListenAndServeTLS(config.TLS.CertFile, config.TLS.KeyFile))
+	}
 
 	router.Run() // listen and serve on 0.0.0.0:8080
 }
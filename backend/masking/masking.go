@@ -0,0 +1,84 @@
+// Package masking hides most of a personal identifier while keeping enough
+// of it to tell records apart, e.g. 1-xxxx-xxxxx-12-3 for a Thai national
+// ID. Masks keep the layout of the value but never its hidden characters.
+package masking
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const hidden = "x"
+
+// NationalID masks a 13 digit Thai national ID in its printed grouping,
+// keeping the first digit, which tells the kind of holder, and the last
+// three.
+func NationalID(id string) string {
+	if id == "" {
+		return ""
+	}
+	if len(id) != 13 {
+		return keepLast(id, 2)
+	}
+	return id[:1] + "-xxxx-xxxxx-" + id[10:12] + "-" + id[12:]
+}
+
+// Passport keeps the first two and last two characters of a passport
+// number.
+func Passport(number string) string {
+	if len(number) <= 4 {
+		return strings.Repeat(hidden, len(number))
+	}
+	return number[:2] + strings.Repeat(hidden, len(number)-4) + number[len(number)-2:]
+}
+
+// Phone keeps the last four digits of a phone number, the +66 country code
+// and any separators.
+func Phone(phone string) string {
+	digits := 0
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+
+	var masked strings.Builder
+	seen := 0
+	if strings.HasPrefix(phone, "+66") {
+		masked.WriteString("+66")
+		phone = phone[3:]
+		seen = 2
+	}
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			seen++
+			if seen <= digits-4 {
+				masked.WriteString(hidden)
+				continue
+			}
+		}
+		masked.WriteRune(r)
+	}
+	return masked.String()
+}
+
+// Email keeps the first character of the mailbox and the domain. The
+// length of the mailbox is hidden too.
+func Email(email string) string {
+	if email == "" {
+		return ""
+	}
+	local, domain, found := strings.Cut(email, "@")
+	if !found || local == "" {
+		return "***"
+	}
+	first, _ := utf8.DecodeRuneInString(local)
+	return string(first) + "***@" + domain
+}
+
+func keepLast(value string, n int) string {
+	if len(value) <= n {
+		return strings.Repeat(hidden, len(value))
+	}
+	return strings.Repeat(hidden, len(value)-n) + value[len(value)-n:]
+}
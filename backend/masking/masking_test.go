@@ -0,0 +1,36 @@
+package masking
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNationalID(t *testing.T) {
+	assert.Equal(t, "1-xxxx-xxxxx-12-3", NationalID("1101700203123"))
+	assert.Equal(t, "1-xxxx-xxxxx-12-1", NationalID("1234567890121"))
+	assert.Equal(t, "", NationalID(""))
+	assert.Equal(t, "xxxx45", NationalID("123445"))
+}
+
+func TestPassport(t *testing.T) {
+	assert.Equal(t, "AAxxxxx67", Passport("AA1234567"))
+	assert.Equal(t, "xxxx", Passport("AB12"))
+	assert.Equal(t, "", Passport(""))
+}
+
+func TestPhone(t *testing.T) {
+	assert.Equal(t, "+66xxxxx4567", Phone("+66891234567"))
+	assert.Equal(t, "+66xxxx4567", Phone("+6621234567"))
+	assert.Equal(t, "+x xxx xxx 0100", Phone("+1 415 555 0100"))
+	assert.Equal(t, "xxxxxx4567", Phone("0891234567"))
+	assert.Equal(t, "123", Phone("123"))
+	assert.Equal(t, "", Phone(""))
+}
+
+func TestEmail(t *testing.T) {
+	assert.Equal(t, "s***@example.com", Email("somchai@example.com"))
+	assert.Equal(t, "ส***@example.co.th", Email("สมชาย@example.co.th"))
+	assert.Equal(t, "***", Email("not-an-email"))
+	assert.Equal(t, "", Email(""))
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries the key of a machine integration.
+const APIKeyHeader = "X-API-Key"
+
+// lastUsedResolution limits how often LastUsedAt is written for a busy key.
+const lastUsedResolution = time.Minute
+
+// ActorRequired accepts a client certificate mapped to a service identity,
+// an API key in the X-API-Key header or a staff token as AuthRequired does,
+// in that order. Either way hospital_id, actor and permissions are set;
+// staff_id is only set for staff. A certificate that maps to no service
+// identity is ignored.
+func ActorRequired() gin.HandlerFunc {
+	staffAuth := AuthRequired()
+
+	return func(c *gin.Context) {
+		if cert := clientCertificate(c); cert != nil && authenticateClientCertificate(c, cert) {
+			c.Next()
+			return
+		}
+
+		key := c.GetHeader(APIKeyHeader)
+		if key == "" {
+			staffAuth(c)
+			return
+		}
+
+		if authenticateAPIKey(c, key) {
+			c.Next()
+		}
+	}
+}
+
+func authenticateAPIKey(c *gin.Context, key string) bool {
+	var apiKey models.APIKey
+	keyHash := auth.HashToken(config.Auth.TokenHashKey, key)
+	if err := config.DB.Where("key_hash = ?", keyHash).First(&apiKey).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return false
+	}
+
+	now := time.Now()
+	if apiKey.IsExpired(now) {
+		c.JSON(401, gin.H{"error": "API key expired"})
+		c.Abort()
+		return false
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedResolution {
+		config.DB.Model(&apiKey).UpdateColumn("last_used_at", now)
+	}
+
+	c.Set("api_key_id", apiKey.ID)
+	setHospital(c, apiKey.HospitalID)
+	c.Set("actor", apiKey.Actor())
+	c.Set("roles", []string{})
+	c.Set("permissions", apiKey.ScopeList())
+	return true
+}
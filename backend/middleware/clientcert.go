@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+)
+
+// clientCertificate returns the verified client certificate of the request:
+// the one verified in our own TLS handshake or, in trusted-proxy mode, the
+// one a trusted proxy verified and forwarded. It returns nil otherwise.
+func clientCertificate(c *gin.Context) *x509.Certificate {
+	if state := c.Request.TLS; state != nil && len(state.VerifiedChains) > 0 {
+		return state.VerifiedChains[0][0]
+	}
+
+	if config.TLS.ClientCertHeader == "" {
+		return nil
+	}
+	header := c.GetHeader(config.TLS.ClientCertHeader)
+	if header == "" {
+		return nil
+	}
+
+	remoteIP := net.ParseIP(c.RemoteIP())
+	if remoteIP == nil || !config.TLS.IsTrustedProxy(remoteIP) {
+		return nil
+	}
+	if c.GetHeader(config.TLS.ClientVerifyHeader) != "SUCCESS" {
+		return nil
+	}
+
+	cert, err := auth.ParseForwardedCertificate(header)
+	if err != nil {
+		return nil
+	}
+	if config.TLS.ClientCAs != nil && auth.VerifyClientCertificate(cert, config.TLS.ClientCAs, time.Now()) != nil {
+		return nil
+	}
+	return cert
+}
+
+// ClientCertificateRequired rejects requests without a verified client
+// certificate and sets it as client_certificate otherwise. The certificate
+// need not map to a service identity yet.
+func ClientCertificateRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cert := clientCertificate(c)
+		if cert == nil {
+			c.JSON(401, gin.H{"error": "Client certificate required"})
+			c.Abort()
+			return
+		}
+		c.Set("client_certificate", cert)
+		c.Next()
+	}
+}
+
+// authenticateClientCertificate maps a verified client certificate to the
+// verified service identity with its exact issuer and subject.
+func authenticateClientCertificate(c *gin.Context, cert *x509.Certificate) bool {
+	issuer, subject := auth.CertificateIdentity(cert)
+
+	var identity models.ServiceIdentity
+	if err := config.DB.
+		Where("certificate_issuer = ? AND certificate_subject = ? AND verified_at IS NOT NULL", issuer, subject).
+		First(&identity).Error; err != nil {
+		return false
+	}
+
+	now := time.Now()
+	if identity.LastUsedAt == nil || now.Sub(*identity.LastUsedAt) >= lastUsedResolution {
+		config.DB.Model(&identity).UpdateColumn("last_used_at", now)
+	}
+
+	c.Set("service_identity_id", identity.ID)
+	setHospital(c, identity.HospitalID)
+	c.Set("actor", identity.Actor())
+	c.Set("roles", []string{})
+	c.Set("permissions", identity.ScopeList())
+	return true
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"log"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+)
+
+// authenticateJWT verifies a JWT access token without touching the database
+// and fills the gin context like the opaque token path does.
+func authenticateJWT(c *gin.Context, tokenString string) bool {
+	claims, err := config.Auth.JWTKeys.Verify(tokenString, time.Now())
+	if err == auth.ErrExpiredJWT {
+		c.JSON(401, gin.H{"error": "Token expired"})
+		c.Abort()
+		return false
+	}
+	if err != nil || config.Auth.Revocations.Contains(claims.ID) {
+		c.JSON(401, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return false
+	}
+
+	c.Set("token_id", claims.SessionID)
+	c.Set("jti", claims.ID)
+	c.Set("staff_id", claims.StaffID)
+	setHospital(c, claims.HospitalID)
+	c.Set("actor", models.StaffActor(claims.StaffID))
+	c.Set("roles", claims.Roles)
+	c.Set("permissions", claims.Permissions)
+	return allowRestrictedSession(c, claims.PasswordChangeRequired, claims.MFAEnrollmentRequired)
+}
+
+// WatchRevokedTokens loads the JWT revocation list and keeps reloading it,
+// so tokens revoked through another instance are rejected here as well.
+func WatchRevokedTokens(interval time.Duration) {
+	loadRevokedTokens()
+
+	go func() {
+		for range time.Tick(interval) {
+			loadRevokedTokens()
+		}
+	}()
+}
+
+func loadRevokedTokens() {
+	now := time.Now()
+	config.DB.Where("expires_at < ?", now).Delete(&models.RevokedToken{})
+
+	var revokedTokens []models.RevokedToken
+	if err := config.DB.Where("expires_at >= ?", now).Find(&revokedTokens).Error; err != nil {
+		log.Printf("Failed to load revoked tokens: %v", err)
+		return
+	}
+
+	entries := make(map[string]time.Time, len(revokedTokens))
+	for _, revokedToken := range revokedTokens {
+		entries[revokedToken.JTI] = revokedToken.ExpiresAt
+	}
+	config.Auth.Revocations.Replace(entries)
+}
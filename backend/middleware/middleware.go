@@ -1,10 +1,13 @@
 package middleware
 
 import (
+	"strings"
 	"time"
 
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/tenant"
 	"github.com/gin-gonic/gin"
 )
 
@@ -21,8 +24,16 @@ func AuthRequired() gin.HandlerFunc {
 			tokenString = tokenString[7:]
 		}
 
+		if config.Auth.JWTKeys != nil && strings.Count(tokenString, ".") == 2 {
+			if authenticateJWT(c, tokenString) {
+				c.Next()
+			}
+			return
+		}
+
 		var token models.Token
-		if err := config.DB.Where("token = ?", tokenString).First(&token).Error; err != nil {
+		tokenHash := auth.HashToken(config.Auth.TokenHashKey, tokenString)
+		if err := config.DB.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
 			c.JSON(401, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
@@ -41,9 +52,96 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
+		var staff models.Staff
+		if err := config.DB.Preload("Roles.Permissions").Preload("Hospital").First(&staff, token.StaffID).Error; err != nil {
+			c.JSON(401, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		c.Set("token_id", token.ID)
 		c.Set("staff_id", token.StaffID)
-		c.Set("hospital_id", token.HospitalID)
+		setHospital(c, token.HospitalID)
+		c.Set("actor", models.StaffActor(token.StaffID))
+		c.Set("roles", staff.RoleNames())
+		c.Set("permissions", staff.PermissionNames())
+
+		passwordChangeRequired := staff.PasswordChangeRequired(config.Auth.PasswordPolicy, time.Now())
+		if !allowRestrictedSession(c, passwordChangeRequired, staff.MFAEnrollmentRequired()) {
+			return
+		}
 
 		c.Next()
 	}
 }
+
+// setHospital records the caller's hospital both for handlers and in the
+// request context, where the tenant package picks it up to scope queries.
+func setHospital(c *gin.Context, hospitalID uint) {
+	c.Set("hospital_id", hospitalID)
+	c.Request = c.Request.WithContext(tenant.WithHospital(c.Request.Context(), hospitalID))
+}
+
+// passwordChangePaths and mfaEnrollmentPaths stay reachable for sessions that
+// must change their password or enroll MFA before doing anything else.
+var passwordChangePaths = map[string]bool{
+	"/staff/password": true,
+	"/staff/logout":   true,
+}
+
+var mfaEnrollmentPaths = map[string]bool{
+	"/staff/mfa/enroll": true,
+	"/staff/mfa/verify": true,
+	"/staff/logout":     true,
+}
+
+func allowRestrictedSession(c *gin.Context, passwordChangeRequired bool, mfaEnrollmentRequired bool) bool {
+	if passwordChangeRequired {
+		if !passwordChangePaths[c.FullPath()] {
+			c.JSON(403, gin.H{"error": "Password change required"})
+			c.Abort()
+			return false
+		}
+		return true
+	}
+
+	if mfaEnrollmentRequired && !mfaEnrollmentPaths[c.FullPath()] {
+		c.JSON(403, gin.H{"error": "MFA enrollment required"})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// RequirePermission rejects the request with 403 unless one of the caller's
+// roles grants the permission. It must run after AuthRequired.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, permission) {
+			c.JSON(403, gin.H{"error": "Permission denied"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func HasPermission(c *gin.Context, permission string) bool {
+	value, exists := c.Get("permissions")
+	if !exists {
+		return false
+	}
+
+	permissions, ok := value.([]string)
+	if !ok {
+		return false
+	}
+
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
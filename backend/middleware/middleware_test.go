@@ -1,12 +1,23 @@
 package middleware
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/config"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
 	"github.com/gin-gonic/gin"
@@ -23,9 +34,15 @@ func SetupTestDB() (*gorm.DB, error) {
 	}
 
 	// Migrate the schema
-	db.AutoMigrate(&models.Token{})
+	db.AutoMigrate(&models.Token{}, &models.RevokedToken{})
+	db.AutoMigrate(&models.Permission{}, &models.Role{})
 	db.AutoMigrate(&models.Staff{})
 	db.AutoMigrate(&models.Hospital{})
+	db.AutoMigrate(&models.APIKey{}, &models.ServiceIdentity{})
+
+	if err := config.SeedRoles(db); err != nil {
+		return nil, err
+	}
 
 	config.DB = db
 	return db, nil
@@ -54,16 +71,24 @@ func SeedTestData(db *gorm.DB) error {
 		return err
 	}
 
+	var nurseRole models.Role
+	if err := db.Where("name = ?", models.RoleNurse).First(&nurseRole).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&staff).Association("Roles").Append(&nurseRole); err != nil {
+		return err
+	}
+
 	// Create test tokens
 	tokens := []models.Token{
 		{
-			Token:      "valid-token-12345",
+			TokenHash:  auth.HashToken(config.Auth.TokenHashKey, "valid-token-12345"),
 			StaffID:    staff.ID,
 			HospitalID: hospital.ID,
 			ExpiresAt:  time.Now().Add(24 * time.Hour),
 		},
 		{
-			Token:      "expired-token-12345",
+			TokenHash:  auth.HashToken(config.Auth.TokenHashKey, "expired-token-12345"),
 			StaffID:    staff.ID,
 			HospitalID: hospital.ID,
 			ExpiresAt:  time.Now().Add(-24 * time.Hour), // Expired
@@ -179,3 +204,370 @@ func TestAuthRequired(t *testing.T) {
 		assert.NotNil(t, response["hospital_id"])
 	})
 }
+
+func TestRequirePermission(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthRequired())
+
+	router.GET("/read", RequirePermission(models.PermPatientRead), func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "success"})
+	})
+	router.GET("/manage", RequirePermission(models.PermStaffManage), func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "success"})
+	})
+
+	// Test case 1: Role grants the permission
+	t.Run("Granted", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/read", nil)
+		req.Header.Set("Authorization", "Bearer valid-token-12345")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+	})
+
+	// Test case 2: Role does not grant the permission
+	t.Run("Denied", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/manage", nil)
+		req.Header.Set("Authorization", "Bearer valid-token-12345")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 403, w.Code)
+
+		var response map[string]string
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Contains(t, response["error"], "Permission denied")
+	})
+}
+
+func TestAuthRequiredJWT(t *testing.T) {
+	// Setup
+	_, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	key, _ := auth.NewSigningKey("k1", auth.AlgHS256, []byte("test-jwt-secret"))
+	config.Auth.JWTKeys, _ = auth.NewKeySet(key)
+	defer func() { config.Auth.JWTKeys = nil }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthRequired())
+
+	router.GET("/read", RequirePermission(models.PermPatientRead), func(c *gin.Context) {
+		staffID, _ := c.Get("staff_id")
+		hospitalID, _ := c.Get("hospital_id")
+		c.JSON(200, gin.H{"staff_id": staffID, "hospital_id": hospitalID})
+	})
+
+	sign := func(jti string, expiresAt time.Time) string {
+		token, _ := config.Auth.JWTKeys.Sign(auth.StaffClaims{
+			ID:          jti,
+			SessionID:   1,
+			StaffID:     5,
+			HospitalID:  2,
+			Roles:       []string{models.RoleNurse},
+			Permissions: []string{models.PermPatientRead},
+			IssuedAt:    time.Now().Unix(),
+			ExpiresAt:   expiresAt.Unix(),
+		})
+		return token
+	}
+
+	// Test case 1: Valid JWT, no database row needed
+	t.Run("Valid JWT", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/read", nil)
+		req.Header.Set("Authorization", "Bearer "+sign("jti-valid", time.Now().Add(time.Minute)))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, float64(5), response["staff_id"])
+		assert.Equal(t, float64(2), response["hospital_id"])
+	})
+
+	// Test case 2: Expired JWT
+	t.Run("Expired JWT", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/read", nil)
+		req.Header.Set("Authorization", "Bearer "+sign("jti-expired", time.Now().Add(-time.Minute)))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 401, w.Code)
+
+		var response map[string]string
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Contains(t, response["error"], "Token expired")
+	})
+
+	// Test case 3: Revoked JWT
+	t.Run("Revoked JWT", func(t *testing.T) {
+		config.Auth.Revocations.Add("jti-revoked", time.Now().Add(time.Minute))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/read", nil)
+		req.Header.Set("Authorization", "Bearer "+sign("jti-revoked", time.Now().Add(time.Minute)))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 401, w.Code)
+	})
+}
+
+func TestActorRequired(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	apiKey := models.APIKey{
+		HospitalID: 1,
+		Name:       "Lab integration",
+		Prefix:     "hk_lab",
+		KeyHash:    auth.HashToken(config.Auth.TokenHashKey, "hk_lab-key-12345"),
+		Scopes:     models.PermPatientRead,
+	}
+	db.Create(&apiKey)
+
+	// Setup test router
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ActorRequired())
+
+	router.GET("/protected", func(c *gin.Context) {
+		_, isStaff := c.Get("staff_id")
+		c.JSON(200, gin.H{
+			"actor":       c.GetString("actor"),
+			"hospital_id": c.GetUint("hospital_id"),
+			"is_staff":    isStaff,
+			"can_read":    HasPermission(c, models.PermPatientRead),
+		})
+	})
+
+	request := func(header string, value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.Header.Set(header, value)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test case 1: API key
+	t.Run("API Key", func(t *testing.T) {
+		w := request(APIKeyHeader, "hk_lab-key-12345")
+		assert.Equal(t, 200, w.Code)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, apiKey.Actor(), response["actor"])
+		assert.Equal(t, float64(1), response["hospital_id"])
+		assert.Equal(t, false, response["is_staff"])
+		assert.Equal(t, true, response["can_read"])
+	})
+
+	// Test case 2: Invalid API key
+	t.Run("Invalid API Key", func(t *testing.T) {
+		w := request(APIKeyHeader, "hk_wrong")
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 3: Staff token
+	t.Run("Staff Token", func(t *testing.T) {
+		w := request("Authorization", "Bearer valid-token-12345")
+		assert.Equal(t, 200, w.Code)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "staff:1", response["actor"])
+		assert.Equal(t, true, response["is_staff"])
+	})
+}
+
+func TestActorRequiredClientCertificate(t *testing.T) {
+	// Setup
+	db, err := SetupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test DB: %v", err)
+	}
+
+	err = SeedTestData(db)
+	if err != nil {
+		t.Fatalf("Failed to seed data: %v", err)
+	}
+
+	verifiedAt := time.Now()
+	identity := models.ServiceIdentity{
+		HospitalID:         1,
+		Name:               "Lab system",
+		CertificateIssuer:  "CN=Hospital CA",
+		CertificateSubject: "CN=lab-system",
+		VerifiedAt:         &verifiedAt,
+		Scopes:             models.PermPatientRead,
+	}
+	db.Create(&identity)
+	// Registered by another hospital, but never confirmed by the holder
+	db.Create(&models.ServiceIdentity{
+		HospitalID:         2,
+		Name:               "Claimed lab system",
+		CertificateIssuer:  "CN=Hospital CA",
+		CertificateSubject: "CN=lab-system",
+		Scopes:             models.PermPatientRead,
+	})
+
+	// Issue a client certificate from a throwaway CA
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Hospital CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "lab-system"},
+		DNSNames:     []string{"lab.hospital-a.internal"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &clientKey.PublicKey, caKey)
+	client, _ := x509.ParseCertificate(clientDER)
+	forwardedCert := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER})))
+
+	previousTLS := config.TLS
+	defer func() { config.TLS = previousTLS }()
+	config.TLS.ClientCAs = x509.NewCertPool()
+	config.TLS.ClientCAs.AddCert(ca)
+	config.TLS.ClientCertHeader = "X-SSL-Client-Cert"
+	config.TLS.ClientVerifyHeader = "X-SSL-Client-Verify"
+	_, proxyNetwork, _ := net.ParseCIDR("10.0.0.0/8")
+	config.TLS.TrustedProxies = []*net.IPNet{proxyNetwork}
+
+	// Setup test router
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ActorRequired())
+
+	router.GET("/protected", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"actor":       c.GetString("actor"),
+			"hospital_id": c.GetUint("hospital_id"),
+		})
+	})
+
+	proxied := func(remoteAddr string, verify string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-SSL-Client-Cert", forwardedCert)
+		req.Header.Set("X-SSL-Client-Verify", verify)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test case 1: Certificate verified in our own TLS handshake
+	t.Run("Direct TLS", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{client},
+			VerifiedChains:   [][]*x509.Certificate{{client, ca}},
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, identity.Actor(), response["actor"])
+		assert.Equal(t, float64(1), response["hospital_id"])
+	})
+
+	// Test case 2: Certificate forwarded by a trusted proxy
+	t.Run("Trusted Proxy", func(t *testing.T) {
+		w := proxied("10.1.2.3:40000", "SUCCESS")
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), identity.Actor())
+	})
+
+	// Test case 3: Forwarded headers are ignored from anyone else
+	t.Run("Untrusted Proxy", func(t *testing.T) {
+		w := proxied("192.0.2.10:40000", "SUCCESS")
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 4: The same subject from another trusted CA is another identity
+	t.Run("Other Issuer", func(t *testing.T) {
+		otherCAKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		otherCATemplate := *caTemplate
+		otherCATemplate.Subject = pkix.Name{CommonName: "Other CA"}
+		otherCADER, _ := x509.CreateCertificate(rand.Reader, &otherCATemplate, &otherCATemplate, &otherCAKey.PublicKey, otherCAKey)
+		otherCA, _ := x509.ParseCertificate(otherCADER)
+		config.TLS.ClientCAs.AddCert(otherCA)
+
+		impostorDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "lab-system"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, otherCA, &clientKey.PublicKey, otherCAKey)
+		impostor, _ := x509.ParseCertificate(impostorDER)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{impostor},
+			VerifiedChains:   [][]*x509.Certificate{{impostor, otherCA}},
+		}
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 4: The proxy could not verify the certificate
+	t.Run("Failed Verification", func(t *testing.T) {
+		w := proxied("10.1.2.3:40000", "FAILED:certificate has expired")
+		assert.Equal(t, 401, w.Code)
+	})
+
+	// Test case 5: Certificate without a service identity
+	t.Run("Unknown Certificate", func(t *testing.T) {
+		db.Unscoped().Delete(&identity)
+		w := proxied("10.1.2.3:40000", "SUCCESS")
+		assert.Equal(t, 401, w.Code)
+	})
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyScopes are the permissions an API key or service identity can be
+// granted. They are meant for lab and HIS integrations, so staff
+// administration is left out.
+var APIKeyScopes = []string{PermPatientRead, PermPatientWrite, PermPatientPII}
+
+// APIKey authenticates a machine integration of one hospital through the
+// X-API-Key header. Only the keyed hash of the key is stored; Prefix is kept
+// in clear so admins can tell keys apart. Revoking a key soft deletes it.
+type APIKey struct {
+	gorm.Model
+	HospitalID  uint       `json:"hospital_id" gorm:"index"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	KeyHash     string     `json:"-" gorm:"uniqueIndex"`
+	Scopes      string     `json:"-"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	CreatedByID uint       `json:"created_by_id"`
+}
+
+// ScopeList returns the permissions granted to the key. They are stored as a
+// comma separated list.
+func (k *APIKey) ScopeList() []string {
+	return splitList(k.Scopes)
+}
+
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && k.ExpiresAt.Before(now)
+}
+
+// Actor identifies the key in the gin context and in logs.
+func (k *APIKey) Actor() string {
+	return fmt.Sprintf("api_key:%d", k.ID)
+}
+
+// StaffActor identifies a staff member in the gin context and in logs.
+func StaffActor(staffID uint) string {
+	return fmt.Sprintf("staff:%d", staffID)
+}
+
+type APIKeyCreateRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeyCreatedResponse carries the key itself, which is only shown when the
+// key is created or rotated.
+type APIKeyCreatedResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func (k *APIKey) ToResponse() APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.ScopeList(),
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditPatientRead    = "patient.read"
+	AuditPatientSearch  = "patient.search"
+	AuditPatientReveal  = "patient.reveal"
+	AuditPatientCreate  = "patient.create"
+	AuditPatientUpdate  = "patient.update"
+	AuditPatientDelete  = "patient.delete"
+	AuditPatientRestore = "patient.restore"
+	AuditPatientHistory = "patient.history"
+)
+
+// AuditEntry records one access to patient records: who made it, from
+// where, with which filters and which patients it returned or changed.
+// Entries are never changed or deleted. Each hospital's entries form a hash
+// chain: Hash covers the entry and the hash of the entry before it, see
+// package audit, so editing, removing or reordering entries is detected.
+type AuditEntry struct {
+	ID         uint              `json:"id" gorm:"primarykey"`
+	HospitalID uint              `json:"hospital_id" gorm:"uniqueIndex:idx_audit_entries_hospital_sequence,priority:1"`
+	Sequence   uint64            `json:"sequence" gorm:"uniqueIndex:idx_audit_entries_hospital_sequence,priority:2"`
+	CreatedAt  time.Time         `json:"created_at" gorm:"index"`
+	Actor      string            `json:"actor" gorm:"index"`
+	StaffID    *uint             `json:"staff_id" gorm:"index"`
+	Action     string            `json:"action" gorm:"index"`
+	PatientIDs []uint            `json:"patient_ids" gorm:"serializer:json"`
+	Filters    map[string]string `json:"filters,omitempty" gorm:"serializer:json"`
+	Reason     string            `json:"reason,omitempty"`
+	ClientIP   string            `json:"client_ip"`
+	PrevHash   string            `json:"prev_hash"`
+	Hash       string            `json:"hash"`
+}
+
+// HospitalScoped makes the tenant package filter audit queries by the
+// caller's hospital.
+func (AuditEntry) HospitalScoped() {}
+
+// AuditEntryPatient lists the patients of an entry, so that the accesses
+// to one patient can be found.
+type AuditEntryPatient struct {
+	AuditEntryID uint `gorm:"primaryKey;autoIncrement:false"`
+	PatientID    uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// AuditChain is the head of a hospital's audit chain: the sequence number
+// and hash of its last entry.
+type AuditChain struct {
+	HospitalID uint   `gorm:"primaryKey;autoIncrement:false"`
+	Sequence   uint64 `gorm:"not null;default:0"`
+	Hash       string `gorm:"not null;default:''"`
+}
+
+// AuditSortFields are the fields the audit log can be sorted by.
+var AuditSortFields = []string{"sequence", "created_at"}
+
+// AuditQueryRequest filters GET /audit. From and To bound created_at, from
+// inclusive and to exclusive.
+type AuditQueryRequest struct {
+	PageRequest
+	PatientID uint      `json:"patient_id" form:"patient_id"`
+	StaffID   uint      `json:"staff_id" form:"staff_id"`
+	Actor     string    `json:"actor" form:"actor"`
+	Action    string    `json:"action" form:"action"`
+	From      time.Time `json:"from" form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        time.Time `json:"to" form:"to" time_format:"2006-01-02T15:04:05Z07:00" binding:"omitempty,gtfield=From"`
+}
+
+// AuditVerification is the result of checking a hospital's audit chain.
+// BrokenAt is the sequence number of the first entry that does not match.
+type AuditVerification struct {
+	Valid    bool    `json:"valid"`
+	Entries  uint64  `json:"entries"`
+	HeadHash string  `json:"head_hash"`
+	BrokenAt *uint64 `json:"broken_at,omitempty"`
+	Problem  string  `json:"problem,omitempty"`
+}
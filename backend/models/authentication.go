@@ -6,21 +6,93 @@ import (
 	"gorm.io/gorm"
 )
 
+// Token is one login session. TokenHash identifies the short-lived access
+// token; the refresh token is rotated on every use, and all tokens descending
+// from the same login share a FamilyID. Only keyed hashes of the bearer
+// secrets are stored. In JWT mode AccessJTI is the jti of the signed access
+// token handed out instead of the opaque one.
 type Token struct {
 	gorm.Model
-	Token      string    `json:"token" gorm:"uniqueIndex"`
-	StaffID    uint      `json:"staff_id"`
-	Staff      Staff     `json:"-"`
-	HospitalID uint      `json:"hospital_id"`
+	TokenHash        string    `json:"-" gorm:"uniqueIndex"`
+	RefreshTokenHash string    `json:"-" gorm:"index"`
+	FamilyID         string    `json:"-" gorm:"index"`
+	AccessJTI        string    `json:"-" gorm:"index"`
+	StaffID          uint      `json:"staff_id"`
+	Staff            Staff     `json:"-"`
+	HospitalID       uint      `json:"hospital_id"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	ClientIP         string    `json:"client_ip"`
+	UserAgent        string    `json:"user_agent"`
+}
+
+// RevokedToken is a JWT access token revoked before its expiry. Rows are only
+// needed until ExpiresAt.
+type RevokedToken struct {
+	ID        uint      `gorm:"primarykey"`
+	JTI       string    `gorm:"uniqueIndex"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// PasswordResetToken is a one-time token issued by an admin so a staff member
+// can choose a new password.
+type PasswordResetToken struct {
+	gorm.Model
+	TokenHash string `gorm:"uniqueIndex"`
+	StaffID   uint   `gorm:"index"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type PasswordResetTokenResponse struct {
+	ResetToken string    `json:"reset_token"`
 	ExpiresAt  time.Time `json:"expires_at"`
 }
 
 type TokenResponse struct {
-	Token     string        `json:"token"`
-	ExpiresAt time.Time     `json:"expires_at"`
-	Staff     StaffResponse `json:"staff"`
+	Token                  string        `json:"token"`
+	ExpiresAt              time.Time     `json:"expires_at"`
+	RefreshToken           string        `json:"refresh_token,omitempty"`
+	RefreshExpiresAt       time.Time     `json:"refresh_expires_at"`
+	PasswordChangeRequired bool          `json:"password_change_required,omitempty"`
+	MFAEnrollmentRequired  bool          `json:"mfa_enrollment_required,omitempty"`
+	Staff                  StaffResponse `json:"staff"`
+}
+
+type TokenRefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// SessionResponse describes a login session without exposing its token.
+type SessionResponse struct {
+	ID        uint      `json:"id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent"`
+	Current   bool      `json:"current"`
 }
 
 func (t *Token) IsValid() bool {
 	return time.Now().Before(t.ExpiresAt)
 }
+
+// SessionExpiresAt is when the session ends for good: the refresh token's
+// expiry, or the access token's for tokens issued without a refresh token.
+func (t *Token) SessionExpiresAt() time.Time {
+	if t.RefreshExpiresAt.After(t.ExpiresAt) {
+		return t.RefreshExpiresAt
+	}
+	return t.ExpiresAt
+}
+
+func (t *Token) ToSessionResponse(currentTokenID uint) SessionResponse {
+	return SessionResponse{
+		ID:        t.ID,
+		IssuedAt:  t.CreatedAt,
+		ExpiresAt: t.SessionExpiresAt(),
+		ClientIP:  t.ClientIP,
+		UserAgent: t.UserAgent,
+		Current:   t.ID == currentTokenID,
+	}
+}
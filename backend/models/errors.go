@@ -0,0 +1,12 @@
+package models
+
+// FieldError describes why one field of a request was rejected. Field is the
+// JSON name of the field, Rule the rule it broke with its parameter, if any,
+// in Param, and Message explains it in the caller's language, English or
+// Thai.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
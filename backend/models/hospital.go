@@ -1,14 +1,147 @@
 package models
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Year components of an HN.
+const (
+	HNYearNone = ""   // no year, one sequence for ever
+	HNYearCE   = "CE" // last two digits of the Gregorian year, 24 for 2024
+	HNYearBE   = "BE" // last two digits of the Buddhist Era year, 67 for 2567
+)
+
+// LocalTimeZone is the hospitals' time zone. It decides dates such as the
+// year of an HN handed out around midnight on New Year's Eve, or today's
+// date when ages are computed.
+var LocalTimeZone = time.FixedZone("Asia/Bangkok", 7*60*60)
+
 type Hospital struct {
 	gorm.Model
 	Name     string
 	Location string
 
+	// RequireMFA forces every staff member of the hospital to enroll TOTP.
+	RequireMFA bool `json:"require_mfa"`
+
+	// The HN format: HNPrefix, the year (HNYear), the sequence zero padded to
+	// HNDigits and, with HNCheckDigit, a Luhn check digit over the year and
+	// sequence. With a year the sequence restarts every year.
+	HNPrefix     string `json:"hn_prefix" gorm:"not null;default:HN"`
+	HNYear       string `json:"hn_year"`
+	HNDigits     int    `json:"hn_digits" gorm:"not null;default:6"`
+	HNCheckDigit bool   `json:"hn_check_digit"`
+
 	Staffs   []Staff
 	Patients []Patient
 }
+
+// HNSequenceYear returns the year whose sequence an HN allocated at t comes
+// from, or 0 if the hospital's HNs have no year.
+func (h *Hospital) HNSequenceYear(t time.Time) int {
+	year := t.In(LocalTimeZone).Year()
+	switch h.HNYear {
+	case HNYearCE:
+		return year
+	case HNYearBE:
+		return year + 543
+	}
+	return 0
+}
+
+// FormatHN builds the HN with the given sequence number in year, as returned
+// by HNSequenceYear.
+func (h *Hospital) FormatHN(year int, sequence uint) string {
+	digits := fmt.Sprintf("%0*d", h.HNDigits, sequence)
+	if year != 0 {
+		digits = fmt.Sprintf("%02d", year%100) + digits
+	}
+	if h.HNCheckDigit {
+		digits += strconv.Itoa(luhnDigit(digits))
+	}
+	return h.HNPrefix + digits
+}
+
+// ParseHN returns the sequence number of an HN of year in the hospital's
+// current format. It reports false for HNs in any other format or year.
+func (h *Hospital) ParseHN(year int, hn string) (uint, bool) {
+	digits, found := strings.CutPrefix(hn, h.HNPrefix)
+	if !found {
+		return 0, false
+	}
+	if year != 0 {
+		if digits, found = strings.CutPrefix(digits, fmt.Sprintf("%02d", year%100)); !found {
+			return 0, false
+		}
+	}
+	if h.HNCheckDigit {
+		if digits == "" {
+			return 0, false
+		}
+		digits = digits[:len(digits)-1]
+	}
+
+	sequence, err := strconv.ParseUint(digits, 10, 0)
+	if err != nil || h.FormatHN(year, uint(sequence)) != hn {
+		return 0, false
+	}
+	return uint(sequence), true
+}
+
+// luhnDigit computes the Luhn check digit of a string of digits.
+func luhnDigit(digits string) int {
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
+
+// HNSequence holds the last HN sequence number a hospital handed out in a
+// year. Year is 0 for hospitals whose HNs have no year.
+type HNSequence struct {
+	HospitalID uint `gorm:"primaryKey;autoIncrement:false"`
+	Year       int  `gorm:"primaryKey;autoIncrement:false"`
+	Value      uint `gorm:"not null;default:0"`
+}
+
+type HospitalHNFormatRequest struct {
+	Prefix     string `json:"prefix" binding:"max=10,excludesall= "`
+	Year       string `json:"year" binding:"omitempty,oneof=CE BE"`
+	Digits     int    `json:"digits" binding:"required,min=3,max=10"`
+	CheckDigit bool   `json:"check_digit"`
+}
+
+type HospitalHNFormatResponse struct {
+	HospitalID uint   `json:"hospital_id"`
+	Prefix     string `json:"prefix"`
+	Year       string `json:"year"`
+	Digits     int    `json:"digits"`
+	CheckDigit bool   `json:"check_digit"`
+	Example    string `json:"example"`
+}
+
+func (h *Hospital) HNFormatResponse() HospitalHNFormatResponse {
+	return HospitalHNFormatResponse{
+		HospitalID: h.ID,
+		Prefix:     h.HNPrefix,
+		Year:       h.HNYear,
+		Digits:     h.HNDigits,
+		CheckDigit: h.HNCheckDigit,
+		Example:    h.FormatHN(h.HNSequenceYear(time.Now()), 1),
+	}
+}
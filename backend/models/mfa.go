@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MFAChallenge is the short-lived second step of a login by a staff member
+// with TOTP enabled. Only the hash of the challenge is stored.
+type MFAChallenge struct {
+	gorm.Model
+	ChallengeHash string `gorm:"uniqueIndex"`
+	StaffID       uint   `gorm:"index"`
+	ExpiresAt     time.Time
+	Attempts      int `gorm:"not null;default:0"`
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the
+// authenticator device is lost.
+type RecoveryCode struct {
+	gorm.Model
+	StaffID  uint   `gorm:"index"`
+	CodeHash string `gorm:"index"`
+	UsedAt   *time.Time
+}
+
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	Challenge   string    `json:"challenge"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type MFALoginRequest struct {
+	Challenge    string `json:"challenge" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFAEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type HospitalMFARequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
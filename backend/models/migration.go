@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// SchemaMigration records a one-off data migration that has run, so that it
+// is not repeated on every start.
+type SchemaMigration struct {
+	Name      string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdentityProvider is an OpenID Connect provider a hospital's staff can log
+// in with. Staff who log in for the first time are provisioned with
+// DefaultRoles when AutoProvision is set; otherwise only staff already linked
+// or matching a verified email can log in.
+type IdentityProvider struct {
+	gorm.Model
+	HospitalID    uint   `json:"hospital_id" gorm:"index"`
+	Name          string `json:"name"`
+	Issuer        string `json:"issuer"`
+	ClientID      string `json:"client_id"`
+	ClientSecret  string `json:"-"`
+	RedirectURL   string `json:"redirect_url"`
+	Scopes        string `json:"-"`
+	AutoProvision bool   `json:"auto_provision"`
+	DefaultRoles  string `json:"-"`
+}
+
+// ScopeList and DefaultRoleList split the comma separated columns.
+func (p *IdentityProvider) ScopeList() []string {
+	return splitList(p.Scopes)
+}
+
+func (p *IdentityProvider) DefaultRoleList() []string {
+	return splitList(p.DefaultRoles)
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+// ExternalIdentity links a staff member to the subject of an identity
+// provider.
+type ExternalIdentity struct {
+	gorm.Model
+	ProviderID uint   `gorm:"uniqueIndex:idx_external_identity_subject"`
+	Subject    string `gorm:"uniqueIndex:idx_external_identity_subject"`
+	StaffID    uint   `gorm:"index"`
+}
+
+// OIDCLoginState is a login started at the identity provider that has not
+// come back yet. The state is the key; the nonce and PKCE code verifier never
+// leave the server. BindingHash is the hash of the cookie the login was
+// started with.
+type OIDCLoginState struct {
+	gorm.Model
+	StateHash    string `gorm:"uniqueIndex"`
+	BindingHash  string
+	ProviderID   uint
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+type IdentityProviderRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Issuer        string   `json:"issuer" binding:"required"`
+	ClientID      string   `json:"client_id" binding:"required"`
+	ClientSecret  string   `json:"client_secret"`
+	RedirectURL   string   `json:"redirect_url" binding:"required"`
+	Scopes        []string `json:"scopes"`
+	AutoProvision bool     `json:"auto_provision"`
+	DefaultRoles  []string `json:"default_roles"`
+}
+
+type IdentityProviderResponse struct {
+	ID            uint     `json:"id"`
+	Name          string   `json:"name"`
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"client_id"`
+	RedirectURL   string   `json:"redirect_url"`
+	Scopes        []string `json:"scopes"`
+	AutoProvision bool     `json:"auto_provision"`
+	DefaultRoles  []string `json:"default_roles"`
+}
+
+func (p *IdentityProvider) ToResponse() IdentityProviderResponse {
+	return IdentityProviderResponse{
+		ID:            p.ID,
+		Name:          p.Name,
+		Issuer:        p.Issuer,
+		ClientID:      p.ClientID,
+		RedirectURL:   p.RedirectURL,
+		Scopes:        p.ScopeList(),
+		AutoProvision: p.AutoProvision,
+		DefaultRoles:  p.DefaultRoleList(),
+	}
+}
+
+// OIDCCallbackRequest carries what the identity provider redirected back
+// with: the code and state, or an error.
+type OIDCCallbackRequest struct {
+	State string `json:"state" binding:"required_without=Error"`
+	Code  string `json:"code" binding:"required_without=Error"`
+	Error string `json:"error"`
+}
+
+type OIDCLoginResponse struct {
+	AuthorizationURL string    `json:"authorization_url"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// PageRequest selects one page of a list, either by page number or by the
+// cursor returned with the previous page. Sort names a field, prefixed with
+// "-" for descending order. Cursors stay stable while rows are added, page
+// numbers do not.
+type PageRequest struct {
+	Page   int    `json:"page" form:"page" binding:"omitempty,min=1"`
+	Limit  int    `json:"limit" form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor string `json:"cursor" form:"cursor"`
+	Sort   string `json:"sort" form:"sort"`
+}
+
+// Pagination describes the page returned alongside the data. Page is only
+// set for page number requests. NextCursor fetches the following page.
+type Pagination struct {
+	Page       int    `json:"page,omitempty"`
+	Limit      int    `json:"limit"`
+	Total      int64  `json:"total"`
+	Sort       string `json:"sort"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
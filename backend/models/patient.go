@@ -3,51 +3,259 @@ package models
 import (
 	"time"
 
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/encryption"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/masking"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/namesearch"
 	"gorm.io/gorm"
 )
 
 type Patient struct {
 	gorm.Model
-	FirstNameTh  string    `json:"first_name_th"`
-	MiddleNameTh string    `json:"middle_name_th"`
-	LastNameTh   string    `json:"last_name_th"`
-	FirstNameEn  string    `json:"first_name_en"`
-	MiddleNameEn string    `json:"middle_name_en"`
-	LastNameEn   string    `json:"last_name_en"`
-	DateOfBirth  time.Time `json:"date_of_birth"`
-	PatientHN    string    `json:"patient_hn"`
-	NationalID   string    `json:"national_id"`
-	PassportID   string    `json:"passport_id"`
-	PhoneNumber  string    `json:"phone_number"`
-	Email        string    `json:"email"`
-	Gender       string    `json:"gender"` // M หรือ F
-	HospitalID   uint      `json:"hospital_id"`
-	Hospital     Hospital  `json:"hospital"`
+	FirstNameTh     string    `json:"first_name_th"`
+	MiddleNameTh    string    `json:"middle_name_th"`
+	LastNameTh      string    `json:"last_name_th"`
+	FirstNameEn     string    `json:"first_name_en"`
+	MiddleNameEn    string    `json:"middle_name_en"`
+	LastNameEn      string    `json:"last_name_en"`
+	DateOfBirth     time.Time `json:"date_of_birth" gorm:"index:idx_patients_hospital_date_of_birth,priority:2"`
+	PatientHN       string    `json:"patient_hn" gorm:"uniqueIndex:idx_patients_hospital_hn,priority:2"`
+	PassportCountry string    `json:"passport_country"`
+	Gender          string    `json:"gender"`
+
+	// Identifiers and contact details are encrypted at rest. Each has a
+	// blind index, which exact match lookups compare instead; BeforeSave
+	// keeps them up to date.
+	NationalID       encryption.EncryptedString `json:"national_id"`
+	NationalIDIndex  string                     `json:"-" gorm:"uniqueIndex:idx_patients_hospital_national_id_index,priority:2"`
+	PassportID       encryption.EncryptedString `json:"passport_id"`
+	PassportIDIndex  string                     `json:"-" gorm:"uniqueIndex:idx_patients_hospital_passport_id_index,priority:2"`
+	PhoneNumber      encryption.EncryptedString `json:"phone_number"`
+	PhoneNumberIndex string                     `json:"-" gorm:"index:idx_patients_hospital_phone_number_index,priority:2"`
+	Email            encryption.EncryptedString `json:"email"`
+	EmailIndex       string                     `json:"-" gorm:"index:idx_patients_hospital_email_index,priority:2"`
+
+	// SearchName and SearchPhonetic hold the Thai and English names in the
+	// forms fuzzy search compares, see package namesearch. BeforeSave keeps
+	// them up to date.
+	SearchName     string `json:"-"`
+	SearchPhonetic string `json:"-"`
+
+	// Identifiers are searched by blind index and dates of birth by range
+	// within a hospital, which these indexes serve. National IDs and passport
+	// numbers are unique among a hospital's patients that are not deleted.
+	HospitalID uint     `json:"hospital_id" gorm:"uniqueIndex:idx_patients_hospital_hn,priority:1,where:patient_hn <> '';uniqueIndex:idx_patients_hospital_national_id_index,priority:1,where:national_id_index <> '' AND deleted_at IS NULL;uniqueIndex:idx_patients_hospital_passport_id_index,priority:1,where:passport_id_index <> '' AND deleted_at IS NULL;index:idx_patients_hospital_phone_number_index,priority:1;index:idx_patients_hospital_email_index,priority:1;index:idx_patients_hospital_date_of_birth,priority:1"`
+	Hospital   Hospital `json:"hospital"`
+}
+
+// NameKeys returns the values of SearchName and SearchPhonetic for the
+// patient's current names.
+func (p *Patient) NameKeys() (string, string) {
+	return namesearch.Keys(
+		p.FirstNameTh, p.MiddleNameTh, p.LastNameTh,
+		p.FirstNameEn, p.MiddleNameEn, p.LastNameEn,
+	)
+}
+
+// SetBlindIndexes computes the blind indexes of the encrypted identifiers.
+func (p *Patient) SetBlindIndexes() error {
+	indexes := []struct {
+		index  *string
+		column string
+		value  encryption.EncryptedString
+	}{
+		{&p.NationalIDIndex, "national_id", p.NationalID},
+		{&p.PassportIDIndex, "passport_id", p.PassportID},
+		{&p.PhoneNumberIndex, "phone_number", p.PhoneNumber},
+		{&p.EmailIndex, "email", p.Email},
+	}
+	for _, index := range indexes {
+		value, err := encryption.BlindIndex(index.column, string(index.value))
+		if err != nil {
+			return err
+		}
+		*index.index = value
+	}
+	return nil
+}
+
+// BeforeSave derives the name search columns and the blind indexes.
+func (p *Patient) BeforeSave(tx *gorm.DB) error {
+	p.SearchName, p.SearchPhonetic = p.NameKeys()
+	return p.SetBlindIndexes()
 }
 
+// Genders a patient can be recorded with.
+const (
+	GenderMale   = "M"
+	GenderFemale = "F"
+)
+
+var Genders = []string{GenderMale, GenderFemale}
+
+// HospitalScoped makes the tenant package filter every patient query by the
+// caller's hospital.
+func (Patient) HospitalScoped() {}
+
+// PatientSearchRequest filters GET /patient/search. Identifiers match
+// exactly, after the normalization applied when they are stored; only names
+// match substrings. The results can be sorted by PatientSortFields.
 type PatientSearchRequest struct {
-	NationalID  string     `json:"national_id" form:"national_id" validate:"omitempty,len=13"`
-	PassportID  string     `json:"passport_id" form:"passport_id" validate:"omitempty,min=5,max=20"`
-	FirstName   string     `json:"first_name" form:"first_name" validate:"omitempty,min=2,max=50"`
-	MiddleName  string     `json:"middle_name" form:"middle_name"`
-	LastName    string     `json:"last_name" form:"last_name"`
-	DateOfBirth *time.Time `json:"date_of_birth" form:"date_of_birth"`
-	PhoneNumber string     `json:"phone_number" form:"phone_number"`
-	Email       string     `json:"email" form:"email" validate:"omitempty,email"`
+	NationalID string `json:"national_id" form:"national_id" validate:"omitempty,thai_national_id"`
+	PassportID string `json:"passport_id" form:"passport_id" validate:"omitempty,min=5,max=20"`
+	FirstName  string `json:"first_name" form:"first_name" validate:"omitempty,min=2,max=50"`
+	MiddleName string `json:"middle_name" form:"middle_name"`
+	LastName   string `json:"last_name" form:"last_name"`
+
+	// Dates are written 2006-01-02 and may use Buddhist Era years
+	// (2533-01-01). DOBFrom and DOBTo are inclusive. BirthYear may also be
+	// a Buddhist Era year. Ages are in whole years as of today.
+	DateOfBirth string `json:"date_of_birth" form:"date_of_birth"`
+	DOBFrom     string `json:"dob_from" form:"dob_from"`
+	DOBTo       string `json:"dob_to" form:"dob_to"`
+	BirthYear   int    `json:"birth_year" form:"birth_year" binding:"omitempty,min=1800,max=2700"`
+	AgeMin      *int   `json:"age_min" form:"age_min" binding:"omitempty,min=0,max=150"`
+	AgeMax      *int   `json:"age_max" form:"age_max" binding:"omitempty,min=0,max=150"`
+
+	PhoneNumber string `json:"phone_number" form:"phone_number" validate:"omitempty,phone_th"`
+	Email       string `json:"email" form:"email" validate:"omitempty,email"`
+
+	// Match selects how the name filters match: "substring" (the default)
+	// or "fuzzy", which tolerates spelling variants and orders the results
+	// by match score.
+	Match string `json:"match" form:"match" binding:"omitempty,oneof=substring fuzzy"`
+
+	PageRequest
+}
+
+// Name matching modes of PatientSearchRequest.
+const (
+	MatchSubstring = "substring"
+	MatchFuzzy     = "fuzzy"
+)
+
+// PatientSortFields are the fields patient searches can be sorted by.
+var PatientSortFields = []string{
+	"id", "created_at", "patient_hn", "date_of_birth",
+	"first_name_th", "last_name_th", "first_name_en", "last_name_en",
+}
+
+// PatientRequest is the body of POST /patient and PUT /patient/:id. PATCH
+// binds the same struct over the stored values, so the rules apply to the
+// patient as a whole after the change. The HN is not part of it: it is
+// allocated when the patient is created and never changes.
+type PatientRequest struct {
+	FirstNameTh     string    `json:"first_name_th" binding:"required,max=100"`
+	MiddleNameTh    string    `json:"middle_name_th" binding:"max=100"`
+	LastNameTh      string    `json:"last_name_th" binding:"required,max=100"`
+	FirstNameEn     string    `json:"first_name_en" binding:"max=100"`
+	MiddleNameEn    string    `json:"middle_name_en" binding:"max=100"`
+	LastNameEn      string    `json:"last_name_en" binding:"max=100"`
+	DateOfBirth     time.Time `json:"date_of_birth" binding:"required"`
+	NationalID      string    `json:"national_id" binding:"required_without=PassportID,omitempty,thai_national_id"`
+	PassportID      string    `json:"passport_id" binding:"omitempty,passport=PassportCountry"`
+	PassportCountry string    `json:"passport_country" binding:"required_with=PassportID,omitempty,iso3166_1_alpha3"`
+	PhoneNumber     string    `json:"phone_number" binding:"omitempty,phone_th"`
+	Email           string    `json:"email" binding:"omitempty,email"`
+	Gender          string    `json:"gender" binding:"omitempty,gender"`
+}
+
+// ToRequest returns the patient's current values as a request, the starting
+// point of a partial update.
+func (p *Patient) ToRequest() PatientRequest {
+	return PatientRequest{
+		FirstNameTh:     p.FirstNameTh,
+		MiddleNameTh:    p.MiddleNameTh,
+		LastNameTh:      p.LastNameTh,
+		FirstNameEn:     p.FirstNameEn,
+		MiddleNameEn:    p.MiddleNameEn,
+		LastNameEn:      p.LastNameEn,
+		DateOfBirth:     p.DateOfBirth,
+		NationalID:      string(p.NationalID),
+		PassportID:      string(p.PassportID),
+		PassportCountry: p.PassportCountry,
+		PhoneNumber:     string(p.PhoneNumber),
+		Email:           string(p.Email),
+		Gender:          p.Gender,
+	}
+}
+
+// Apply copies the request's values onto the patient.
+func (p *Patient) Apply(request PatientRequest) {
+	p.FirstNameTh = request.FirstNameTh
+	p.MiddleNameTh = request.MiddleNameTh
+	p.LastNameTh = request.LastNameTh
+	p.FirstNameEn = request.FirstNameEn
+	p.MiddleNameEn = request.MiddleNameEn
+	p.LastNameEn = request.LastNameEn
+	p.DateOfBirth = request.DateOfBirth
+	p.NationalID = encryption.EncryptedString(request.NationalID)
+	p.PassportID = encryption.EncryptedString(request.PassportID)
+	p.PassportCountry = request.PassportCountry
+	p.PhoneNumber = encryption.EncryptedString(request.PhoneNumber)
+	p.Email = encryption.EncryptedString(request.Email)
+	p.Gender = request.Gender
 }
 
 type PatientResponse struct {
-	FirstNameTh  string    `json:"first_name_th"`
-	MiddleNameTh string    `json:"middle_name_th"`
-	LastNameTh   string    `json:"last_name_th"`
-	FirstNameEn  string    `json:"first_name_en"`
-	MiddleNameEn string    `json:"middle_name_en"`
-	LastNameEn   string    `json:"last_name_en"`
-	DateOfBirth  time.Time `json:"date_of_birth"`
-	PatientHN    string    `json:"patient_hn"`
-	NationalID   string    `json:"national_id"`
-	PassportID   string    `json:"passport_id"`
-	PhoneNumber  string    `json:"phone_number"`
-	Email        string    `json:"email"`
-	Gender       string    `json:"gender"` // M หรือ F
+	ID              uint      `json:"id"`
+	FirstNameTh     string    `json:"first_name_th"`
+	MiddleNameTh    string    `json:"middle_name_th"`
+	LastNameTh      string    `json:"last_name_th"`
+	FirstNameEn     string    `json:"first_name_en"`
+	MiddleNameEn    string    `json:"middle_name_en"`
+	LastNameEn      string    `json:"last_name_en"`
+	DateOfBirth     time.Time `json:"date_of_birth"`
+	PatientHN       string    `json:"patient_hn"`
+	NationalID      string    `json:"national_id"`
+	PassportID      string    `json:"passport_id"`
+	PassportCountry string    `json:"passport_country"`
+	PhoneNumber     string    `json:"phone_number"`
+	Email           string    `json:"email"`
+	Gender          string    `json:"gender"`
+
+	// Masked is set when the identifiers and contact details are masked.
+	Masked bool `json:"masked"`
+}
+
+// Mask hides most of the identifiers and contact details, see package
+// masking.
+func (r PatientResponse) Mask() PatientResponse {
+	r.NationalID = masking.NationalID(r.NationalID)
+	r.PassportID = masking.Passport(r.PassportID)
+	r.PhoneNumber = masking.Phone(r.PhoneNumber)
+	r.Email = masking.Email(r.Email)
+	r.Masked = true
+	return r
+}
+
+func (p *Patient) ToResponse() PatientResponse {
+	return PatientResponse{
+		ID:              p.ID,
+		FirstNameTh:     p.FirstNameTh,
+		MiddleNameTh:    p.MiddleNameTh,
+		LastNameTh:      p.LastNameTh,
+		FirstNameEn:     p.FirstNameEn,
+		MiddleNameEn:    p.MiddleNameEn,
+		LastNameEn:      p.LastNameEn,
+		DateOfBirth:     p.DateOfBirth,
+		PatientHN:       p.PatientHN,
+		NationalID:      string(p.NationalID),
+		PassportID:      string(p.PassportID),
+		PassportCountry: p.PassportCountry,
+		PhoneNumber:     string(p.PhoneNumber),
+		Email:           string(p.Email),
+		Gender:          p.Gender,
+	}
+}
+
+// PatientRevealRequest is the body of POST /patient/:id/reveal.
+type PatientRevealRequest struct {
+	Reason string `json:"reason" binding:"required,min=10,max=500"`
+}
+
+// PatientMatchResponse is a fuzzy search result. MatchScore runs from 0 to
+// 1, where 1 is an exact match.
+type PatientMatchResponse struct {
+	PatientResponse
+	MatchScore float64 `json:"match_score"`
 }
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/encryption"
+)
+
+// RevisionBaseline is the action of the first revision of patients
+// registered before revisions were kept: their record as it was when
+// revisions started. Other revisions carry the audit action of the change,
+// e.g. AuditPatientUpdate.
+const RevisionBaseline = "baseline"
+
+// PatientRevision is a version of a patient's record: the whole record
+// after a change, who made the change and why. Versions count from 1 for
+// each patient. The snapshot holds identifiers, so it is encrypted like
+// them.
+type PatientRevision struct {
+	ID            uint                       `json:"-" gorm:"primarykey"`
+	HospitalID    uint                       `json:"-" gorm:"index"`
+	PatientID     uint                       `json:"patient_id" gorm:"uniqueIndex:idx_patient_revisions_patient_version,priority:1"`
+	Version       uint                       `json:"version" gorm:"uniqueIndex:idx_patient_revisions_patient_version,priority:2"`
+	CreatedAt     time.Time                  `json:"created_at" gorm:"index"`
+	Action        string                     `json:"action"`
+	Actor         string                     `json:"actor"`
+	StaffID       *uint                      `json:"staff_id"`
+	Reason        string                     `json:"reason"`
+	ChangedFields []string                   `json:"changed_fields" gorm:"serializer:json"`
+	Snapshot      encryption.EncryptedString `json:"-"`
+}
+
+// HospitalScoped makes the tenant package filter every revision query by
+// the caller's hospital.
+func (PatientRevision) HospitalScoped() {}
+
+// PatientSnapshot is a patient's record as kept in a revision.
+type PatientSnapshot struct {
+	PatientRequest
+	PatientHN string `json:"patient_hn"`
+}
+
+// Snapshot returns the patient's current record.
+func (p *Patient) Snapshot() PatientSnapshot {
+	return PatientSnapshot{PatientRequest: p.ToRequest(), PatientHN: p.PatientHN}
+}
+
+// Patient returns the patient as recorded in the snapshot.
+func (s PatientSnapshot) Patient(id uint, hospitalID uint) Patient {
+	patient := Patient{HospitalID: hospitalID, PatientHN: s.PatientHN}
+	patient.ID = id
+	patient.Apply(s.PatientRequest)
+	return patient
+}
+
+// FieldChange is a field that differs between two snapshots.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// Changes lists the fields that differ from s to other by their JSON
+// names, in alphabetical order.
+func (s PatientSnapshot) Changes(other PatientSnapshot) []FieldChange {
+	from, to := s.fields(), other.fields()
+	names := make([]string, 0, len(from))
+	for name := range from {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+	for _, name := range names {
+		if from[name] != to[name] {
+			changes = append(changes, FieldChange{Field: name, From: from[name], To: to[name]})
+		}
+	}
+	return changes
+}
+
+func (s PatientSnapshot) fields() map[string]any {
+	fields := map[string]any{}
+	data, _ := json.Marshal(s)
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// SetSnapshot stores the snapshot in the revision.
+func (r *PatientRevision) SetSnapshot(snapshot PatientSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	r.Snapshot = encryption.EncryptedString(data)
+	return nil
+}
+
+// PatientSnapshot returns the snapshot stored in the revision.
+func (r *PatientRevision) PatientSnapshot() (PatientSnapshot, error) {
+	var snapshot PatientSnapshot
+	err := json.Unmarshal([]byte(r.Snapshot), &snapshot)
+	return snapshot, err
+}
+
+// PatientRevisionDiffRequest selects the revisions GET
+// /patient/:id/revisions/diff compares.
+type PatientRevisionDiffRequest struct {
+	From uint `json:"from" form:"from" binding:"required,min=1"`
+	To   uint `json:"to" form:"to" binding:"required,min=1"`
+}
+
+// PatientAsOfRequest is the time GET /patient/:id/as-of reads the record
+// at.
+type PatientAsOfRequest struct {
+	At time.Time `json:"at" form:"at" time_format:"2006-01-02T15:04:05Z07:00" binding:"required"`
+}
+
+// PatientRevisionSortFields are the fields revisions can be sorted by.
+var PatientRevisionSortFields = []string{"version", "created_at"}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+const (
+	PermPatientRead  = "patient:read"
+	PermPatientWrite = "patient:write"
+	PermStaffRead    = "staff:read"
+	PermStaffManage  = "staff:manage"
+
+	// PermPatientPII shows patients' identifiers and contact details in
+	// full; without it they are masked. PermPatientReveal allows revealing
+	// them one patient at a time, giving a reason that is audited.
+	PermPatientPII    = "patient:pii"
+	PermPatientReveal = "patient:reveal"
+
+	PermHospitalManage = "hospital:manage"
+	PermAPIKeyManage   = "apikey:manage"
+
+	// PermAuditRead allows reading and verifying the audit log of access
+	// to patient records.
+	PermAuditRead = "audit:read"
+)
+
+const (
+	RoleAdmin      = "admin"
+	RoleDoctor     = "doctor"
+	RoleNurse      = "nurse"
+	RoleRegistrar  = "registrar"
+	RoleCompliance = "compliance"
+)
+
+// DefaultRolePermissions is the permission set every built-in role gets when
+// the database is seeded.
+var DefaultRolePermissions = map[string][]string{
+	RoleAdmin:      {PermPatientRead, PermPatientWrite, PermPatientReveal, PermStaffRead, PermStaffManage, PermHospitalManage, PermAPIKeyManage, PermAuditRead},
+	RoleDoctor:     {PermPatientRead, PermPatientWrite, PermPatientReveal},
+	RoleNurse:      {PermPatientRead},
+	RoleRegistrar:  {PermPatientRead, PermPatientWrite, PermPatientPII},
+	RoleCompliance: {PermAuditRead},
+}
+
+type Permission struct {
+	gorm.Model
+	Name string `json:"name" gorm:"uniqueIndex"`
+}
+
+type Role struct {
+	gorm.Model
+	Name        string       `json:"name" gorm:"uniqueIndex"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+}
+
+type StaffRoleAssignRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+type RoleResponse struct {
+	ID          uint     `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+func (r *Role) ToResponse() RoleResponse {
+	permissions := make([]string, 0, len(r.Permissions))
+	for _, permission := range r.Permissions {
+		permissions = append(permissions, permission.Name)
+	}
+
+	return RoleResponse{
+		ID:          r.ID,
+		Name:        r.Name,
+		Permissions: permissions,
+	}
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SecurityEventLoginLocked   = "login_locked"
+	SecurityEventLoginUnlocked = "login_unlocked"
+
+	SecurityEventPasswordChanged     = "password_changed"
+	SecurityEventPasswordResetIssued = "password_reset_issued"
+	SecurityEventPasswordReset       = "password_reset"
+
+	SecurityEventMFAEnabled     = "mfa_enabled"
+	SecurityEventMFADisabled    = "mfa_disabled"
+	SecurityEventMFARecoveryUse = "mfa_recovery_code_used"
+	SecurityEventHospitalMFA    = "hospital_mfa_changed"
+	SecurityEventHospitalHN     = "hospital_hn_format_changed"
+
+	SecurityEventAPIKeyCreated = "api_key_created"
+	SecurityEventAPIKeyRotated = "api_key_rotated"
+	SecurityEventAPIKeyRevoked = "api_key_revoked"
+
+	SecurityEventServiceIdentityCreated  = "service_identity_created"
+	SecurityEventServiceIdentityDeleted  = "service_identity_deleted"
+	SecurityEventServiceIdentityVerified = "service_identity_verified"
+
+	SecurityEventSSOLogin       = "sso_login"
+	SecurityEventSSOProvisioned = "sso_provisioned"
+	SecurityEventSSOLinked      = "sso_linked"
+
+	SecurityEventPatientReveal = "patient_pii_revealed"
+)
+
+// LoginAttempt counts recent failed logins for one username or client IP.
+// AttemptKey is "user:<hospital id>:<username>" or "ip:<address>".
+type LoginAttempt struct {
+	gorm.Model
+	AttemptKey   string `gorm:"uniqueIndex"`
+	Failures     int    `gorm:"not null;default:0"`
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+func (a *LoginAttempt) IsLocked(now time.Time) bool {
+	return a.LockedUntil != nil && a.LockedUntil.After(now)
+}
+
+// SecurityEvent records authentication events such as lockouts for later
+// review. ActorStaffID is the admin who triggered the event, if any.
+type SecurityEvent struct {
+	gorm.Model
+	Type         string `json:"type" gorm:"index"`
+	HospitalID   uint   `json:"hospital_id" gorm:"index"`
+	StaffID      *uint  `json:"staff_id"`
+	ActorStaffID *uint  `json:"actor_staff_id"`
+	Username     string `json:"username"`
+	ClientIP     string `json:"client_ip"`
+	Detail       string `json:"detail"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ServiceIdentity maps a client certificate to a calling system of one
+// hospital. It matches exactly the certificates with CertificateSubject
+// issued by CertificateIssuer, see auth.CertificateIdentity. It is taken from
+// a certificate the hospital's admin uploads, and only authenticates once
+// the system holding the certificate has confirmed it with the
+// verification code given to the admin. Each certificate can be verified
+// for one hospital only.
+type ServiceIdentity struct {
+	gorm.Model
+	HospitalID           uint       `json:"hospital_id" gorm:"uniqueIndex:idx_service_identities_hospital_certificate,priority:1"`
+	Name                 string     `json:"name"`
+	CertificateIssuer    string     `json:"certificate_issuer" gorm:"uniqueIndex:idx_service_identities_hospital_certificate,priority:2;uniqueIndex:idx_service_identities_verified_certificate,priority:1,where:verified_at IS NOT NULL"`
+	CertificateSubject   string     `json:"certificate_subject" gorm:"uniqueIndex:idx_service_identities_hospital_certificate,priority:3;uniqueIndex:idx_service_identities_verified_certificate,priority:2,where:verified_at IS NOT NULL"`
+	VerificationCodeHash string     `json:"-" gorm:"index"`
+	VerifiedAt           *time.Time `json:"verified_at"`
+	Scopes               string     `json:"-"`
+	LastUsedAt           *time.Time `json:"last_used_at"`
+}
+
+func (s *ServiceIdentity) ScopeList() []string {
+	return splitList(s.Scopes)
+}
+
+// Actor identifies the service in the gin context and in logs.
+func (s *ServiceIdentity) Actor() string {
+	return fmt.Sprintf("service:%d", s.ID)
+}
+
+// ServiceIdentityRequest registers a client certificate, given as a PEM
+// block.
+type ServiceIdentityRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Certificate string   `json:"certificate" binding:"required"`
+	Scopes      []string `json:"scopes" binding:"required"`
+}
+
+// ServiceIdentityVerifyRequest confirms a service identity. It is sent with
+// the client certificate of the identity.
+type ServiceIdentityVerifyRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type ServiceIdentityResponse struct {
+	ID                 uint       `json:"id"`
+	Name               string     `json:"name"`
+	CertificateIssuer  string     `json:"certificate_issuer"`
+	CertificateSubject string     `json:"certificate_subject"`
+	Scopes             []string   `json:"scopes"`
+	VerifiedAt         *time.Time `json:"verified_at"`
+	LastUsedAt         *time.Time `json:"last_used_at"`
+}
+
+// ServiceIdentityCreatedResponse carries the verification code, which is
+// only shown when the identity is created.
+type ServiceIdentityCreatedResponse struct {
+	ServiceIdentityResponse
+	VerificationCode string `json:"verification_code"`
+}
+
+func (s *ServiceIdentity) ToResponse() ServiceIdentityResponse {
+	return ServiceIdentityResponse{
+		ID:                 s.ID,
+		Name:               s.Name,
+		CertificateIssuer:  s.CertificateIssuer,
+		CertificateSubject: s.CertificateSubject,
+		Scopes:             s.ScopeList(),
+		VerifiedAt:         s.VerifiedAt,
+		LastUsedAt:         s.LastUsedAt,
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"time"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/auth"
 	"gorm.io/gorm"
 )
 
@@ -12,6 +15,17 @@ type Staff struct {
 	Email      string `json:"email"`
 	HospitalID uint   `json:"hospital_id"`
 	Hospital   Hospital
+	Roles      []Role `json:"roles" gorm:"many2many:staff_roles"`
+
+	PasswordChangedAt  *time.Time `json:"password_changed_at"`
+	MustChangePassword bool       `json:"must_change_password"`
+
+	// TOTPSecret is set on enrollment and only used for login once the first
+	// code has been verified and TOTPEnabled is set. TOTPLastStep is the
+	// last accepted time step, which prevents code replay.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"`
 }
 
 type StaffLoginRequest struct {
@@ -21,19 +35,31 @@ type StaffLoginRequest struct {
 }
 
 type StaffCreateRequest struct {
-	Username   string `json:"username" binding:"required"`
-	Password   string `json:"password" binding:"required"`
-	Name       string `json:"name" binding:"required"`
-	Email      string `json:"email"`
-	HospitalID uint   `json:"hospital" binding:"required"`
+	Username   string   `json:"username" binding:"required"`
+	Password   string   `json:"password" binding:"required"`
+	Name       string   `json:"name" binding:"required"`
+	Email      string   `json:"email"`
+	HospitalID uint     `json:"hospital" binding:"required"`
+	Roles      []string `json:"roles"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type PasswordResetRequest struct {
+	ResetToken  string `json:"reset_token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
 }
 
 type StaffResponse struct {
-	ID         uint   `json:"id"`
-	Username   string `json:"username"`
-	Name       string `json:"name"`
-	Email      string `json:"email"`
-	HospitalID uint   `json:"hospital_id"`
+	ID         uint     `json:"id"`
+	Username   string   `json:"username"`
+	Name       string   `json:"name"`
+	Email      string   `json:"email"`
+	HospitalID uint     `json:"hospital_id"`
+	Roles      []string `json:"roles"`
 }
 
 func (s *Staff) ToResponse() StaffResponse {
@@ -43,5 +69,69 @@ func (s *Staff) ToResponse() StaffResponse {
 		Name:       s.Name,
 		Email:      s.Email,
 		HospitalID: s.HospitalID,
+		Roles:      s.RoleNames(),
+	}
+}
+
+func (s *Staff) RoleNames() []string {
+	names := make([]string, 0, len(s.Roles))
+	for _, role := range s.Roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// PermissionNames returns the union of the permissions granted by every role
+// of the staff member. Roles must be preloaded with their permissions.
+func (s *Staff) PermissionNames() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, role := range s.Roles {
+		for _, permission := range role.Permissions {
+			if !seen[permission.Name] {
+				seen[permission.Name] = true
+				names = append(names, permission.Name)
+			}
+		}
+	}
+	return names
+}
+
+// PasswordSetAt is when the current password was set. Accounts created before
+// this was tracked count from their creation.
+func (s *Staff) PasswordSetAt() time.Time {
+	if s.PasswordChangedAt != nil {
+		return *s.PasswordChangedAt
+	}
+	return s.CreatedAt
+}
+
+// PasswordChangeRequired reports whether the staff member has to change their
+// password before doing anything else.
+func (s *Staff) PasswordChangeRequired(policy auth.PasswordPolicy, now time.Time) bool {
+	if !s.HasPassword() {
+		return false
+	}
+	return s.MustChangePassword || policy.Expired(s.PasswordSetAt(), now)
+}
+
+// HasPassword is false for staff provisioned through single sign-on, who can
+// only log in through their identity provider.
+func (s *Staff) HasPassword() bool {
+	return s.Password != ""
+}
+
+// MFAEnrollmentRequired reports whether the staff member's hospital requires
+// MFA and they have not enrolled yet. Hospital must be preloaded.
+func (s *Staff) MFAEnrollmentRequired() bool {
+	return s.Hospital.RequireMFA && !s.TOTPEnabled
+}
+
+func (s *Staff) HasRole(name string) bool {
+	for _, role := range s.Roles {
+		if role.Name == name {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,191 @@
+// Package namesearch matches person names despite spelling variants: Thai
+// names typed with different tone marks or vowel forms, and romanized Thai
+// names transliterated in different ways (Somchai, Somchay, Somchaai).
+//
+// Names are compared in two forms. Normalize folds Thai spelling variants
+// and case. PhoneticKey maps romanized names to a key that sounds alike
+// spellings share. Similarity and WordSimilarity score two strings by their
+// shared trigrams the way Postgres' pg_trgm does, so that tests on SQLite
+// rank like production.
+package namesearch
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MinScore is the word similarity a name needs to match a query.
+const MinScore = 0.5
+
+// thaiReplacer folds spellings of Thai text that read the same.
+var thaiReplacer = strings.NewReplacer(
+	// Tone marks, mai taikhu, thanthakhat and yamakkan
+	"่", "", "้", "", "๊", "", "๋", "",
+	"็", "", "์", "", "๎", "",
+	// Sara am typed as nikhahit and sara aa
+	"ํา", "ำ",
+	// Sara ae typed as two sara e
+	"เเ", "แ",
+	// Lakkhangyao as in ฤๅ, read as sara aa
+	"ๅ", "า",
+	// Zero width spaces some Thai input methods insert between words
+	"\u200b", "",
+)
+
+// Normalize folds a name for comparison: Thai tone marks and other
+// diacritics are dropped and vowel variants unified, Latin letters are
+// lower-cased and anything but letters and digits separates words.
+func Normalize(name string) string {
+	name = thaiReplacer.Replace(strings.ToLower(name))
+	return strings.Join(words(name), " ")
+}
+
+// words splits text into runs of letters (including Thai vowel signs) and
+// digits.
+func words(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	})
+}
+
+// Keys returns the normalized form and phonetic key of a name given in
+// parts, e.g. the Thai and English first and last names of one person.
+func Keys(parts ...string) (normalized string, phonetic string) {
+	name := strings.Join(parts, " ")
+	return Normalize(name), PhoneticKey(name)
+}
+
+// phoneticRules rewrite romanized Thai into a phonetic key, in order.
+// Digraphs go first so that their letters are not rewritten on their own.
+var phoneticRules = strings.NewReplacer(
+	"ph", "p", "th", "t", "kh", "k", "ch", "c", "sh", "c",
+	"j", "c", "q", "k", "x", "s", "z", "s", "v", "w", "r", "l",
+	"oo", "u", "ou", "u", "ee", "i", "ay", "ai", "ey", "ei",
+)
+
+// PhoneticKey returns a key that romanized spellings of the same Thai name
+// share. Aspirated and plain consonants, r and l, and long and short vowels
+// are not told apart, as in the common transliteration variants. Words that
+// are not in Latin script are left out.
+func PhoneticKey(name string) string {
+	var keys []string
+	for _, word := range words(strings.ToLower(name)) {
+		if key := phoneticWord(word); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return strings.Join(keys, " ")
+}
+
+func phoneticWord(word string) string {
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+
+	word = phoneticRules.Replace(word)
+	// A final y is a vowel, as in Somchay
+	if strings.HasSuffix(word, "y") && len(word) > 1 {
+		word = word[:len(word)-1] + "i"
+	}
+
+	// Doubled letters only lengthen the sound
+	var key strings.Builder
+	var previous byte
+	for i := 0; i < len(word); i++ {
+		if word[i] != previous {
+			key.WriteByte(word[i])
+		}
+		previous = word[i]
+	}
+	return key.String()
+}
+
+// Trigrams returns the set of trigrams of text as pg_trgm computes them:
+// every word is padded with two spaces in front and one behind.
+func Trigrams(text string) map[string]bool {
+	trigrams := map[string]bool{}
+	for _, word := range words(strings.ToLower(text)) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			trigrams[string(padded[i:i+3])] = true
+		}
+	}
+	return trigrams
+}
+
+// Similarity is pg_trgm's similarity: the share of trigrams a and b have in
+// common.
+func Similarity(a string, b string) float64 {
+	return jaccard(Trigrams(a), Trigrams(b))
+}
+
+// WordSimilarity scores how well query matches some run of consecutive
+// words of text, like pg_trgm's word_similarity. It approximates
+// pg_trgm, which also considers runs that start or end inside a word.
+func WordSimilarity(query string, text string) float64 {
+	queryTrigrams := Trigrams(query)
+	textWords := words(strings.ToLower(text))
+	queryLength := len(words(query))
+	if queryLength == 0 || len(textWords) == 0 {
+		return 0
+	}
+
+	best := 0.0
+	for start := range textWords {
+		for end := start + 1; end <= len(textWords) && end-start <= queryLength+1; end++ {
+			score := jaccard(queryTrigrams, Trigrams(strings.Join(textWords[start:end], " ")))
+			if score > best {
+				best = score
+			}
+		}
+	}
+	return best
+}
+
+func jaccard(a map[string]bool, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range a {
+		if b[trigram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// Score is how well query matches a name stored as its normalized form and
+// phonetic key: the better of the two word similarities.
+func Score(query string, normalized string, phonetic string) float64 {
+	score := WordSimilarity(Normalize(query), normalized)
+	if key := PhoneticKey(query); key != "" {
+		score = max(score, WordSimilarity(key, phonetic))
+	}
+	return score
+}
+
+// Ranked is a match and its score.
+type Ranked[T any] struct {
+	Item  T
+	Score float64
+}
+
+// Rank keeps the items whose score is at least MinScore, best first. Items
+// with equal scores keep their order.
+func Rank[T any](items []T, score func(T) float64) []Ranked[T] {
+	var ranked []Ranked[T]
+	for _, item := range items {
+		if s := score(item); s >= MinScore {
+			ranked = append(ranked, Ranked[T]{Item: item, Score: s})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
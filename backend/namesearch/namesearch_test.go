@@ -0,0 +1,66 @@
+package namesearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	// Tone marks and thanthakhat
+	assert.Equal(t, Normalize("สมศักดิ์"), Normalize("สมศักดิ"))
+	assert.Equal(t, Normalize("ใจดี"), Normalize("ใจดี่"))
+	// Sara am as nikhahit and sara aa, sara ae as two sara e
+	assert.Equal(t, Normalize("คำ"), Normalize("คํา"))
+	assert.Equal(t, Normalize("แสง"), Normalize("เเสง"))
+
+	assert.Equal(t, "สมชาย ใจดี somchai", Normalize("  สมชาย-ใจดี่ (SOMCHAI) "))
+}
+
+func TestPhoneticKey(t *testing.T) {
+	variants := [][]string{
+		{"Somchai", "Somchay", "Somchaai", "SOMCHAI"},
+		{"Boonsri", "Bunsri", "Bunsli"},
+		{"Pornthip", "Porntip", "Phornthip"},
+		{"Jaidee", "Chaidee", "Jaidi"},
+		{"Saowanee", "Saovanee"},
+	}
+	for _, names := range variants {
+		for _, name := range names[1:] {
+			assert.Equal(t, PhoneticKey(names[0]), PhoneticKey(name), name)
+		}
+	}
+
+	assert.NotEqual(t, PhoneticKey("Somchai"), PhoneticKey("Somsak"))
+	// Thai script has no phonetic key
+	assert.Equal(t, "", PhoneticKey("สมชาย"))
+	assert.Equal(t, "somcai", PhoneticKey("สมชาย Somchai"))
+}
+
+func TestSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, Similarity("somchai", "Somchai"))
+	assert.Equal(t, 0.0, Similarity("somchai", ""))
+	// 6 shared trigrams of 10: "  s", " so", "som", "omc", "mch", "cha"
+	assert.InDelta(t, 0.6, Similarity("somchai", "somchay"), 0.001)
+
+	assert.Equal(t, 1.0, WordSimilarity("jaidee", "สมชาย ใจดี somchai jaidee"))
+	assert.Equal(t, 1.0, WordSimilarity("somchai jaidee", "สมชาย ใจดี somchai jaidee"))
+	assert.Less(t, WordSimilarity("somsak", "somchai jaidee"), MinScore)
+}
+
+func TestScore(t *testing.T) {
+	normalized, phonetic := Keys("สมชาย", "", "ใจดี", "Somchai", "", "Jaidee")
+
+	assert.Equal(t, 1.0, Score("สมชาย", normalized, phonetic))
+	assert.Equal(t, 1.0, Score("Somchay", normalized, phonetic))
+	assert.Equal(t, 1.0, Score("ใจดี่", normalized, phonetic))
+	assert.GreaterOrEqual(t, Score("Somchai Jaidi", normalized, phonetic), MinScore)
+	assert.Less(t, Score("Somsak", normalized, phonetic), MinScore)
+
+	ranked := Rank([]string{"Somsak", "Somchay", "Somchai"}, func(name string) float64 {
+		return Score(name, normalized, phonetic)
+	})
+	assert.Equal(t, 2, len(ranked))
+	assert.Equal(t, "Somchay", ranked[0].Item)
+	assert.Equal(t, "Somchai", ranked[1].Item)
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/controller"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+)
+
+func APIKeyRoutes(router *gin.Engine) {
+	protected := router.Group("/")
+	protected.Use(middleware.AuthRequired(), middleware.RequirePermission(models.PermAPIKeyManage))
+	{
+		protected.GET("/api-keys", controller.ListAPIKeys)
+		protected.POST("/api-keys", controller.CreateAPIKey)
+		protected.POST("/api-keys/:id/rotate", controller.RotateAPIKey)
+		protected.DELETE("/api-keys/:id", controller.RevokeAPIKey)
+	}
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/controller"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/gin-gonic/gin"
+)
+
+func AuditRoutes(router *gin.Engine) {
+	protected := router.Group("/")
+	protected.Use(middleware.AuthRequired(), middleware.RequirePermission(models.PermAuditRead))
+	{
+		protected.GET("/audit", controller.ListAuditEntries)
+		protected.GET("/audit/verify", controller.VerifyAuditLog)
+	}
+}
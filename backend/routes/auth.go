@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/controller"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func AuthRoutes(router *gin.Engine) {
+	router.GET("/.well-known/jwks.json", controller.JWKS)
+
+	router.GET("/auth/oidc/:id/login", controller.StartOIDCLogin)
+	router.POST("/auth/oidc/callback", controller.OIDCCallback)
+
+	router.POST("/auth/client-certificate/verify", middleware.ClientCertificateRequired(), controller.VerifyServiceIdentity)
+}
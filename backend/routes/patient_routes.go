@@ -3,15 +3,29 @@ package routes
 import (
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/controller"
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
 	"github.com/gin-gonic/gin"
 )
 
 func PatientRoutes(router *gin.Engine) {
-	router.GET("/patient/search/:id", controller.GetPatient)
-
 	protected := router.Group("/")
-	protected.Use(middleware.AuthRequired())
+	protected.Use(middleware.ActorRequired())
 	{
-		protected.GET("/patient/search", controller.SearchPatients)
+		protected.GET("/patient/search", middleware.RequirePermission(models.PermPatientRead), controller.SearchPatients)
+		protected.GET("/patient/search/:id", middleware.RequirePermission(models.PermPatientRead), controller.GetPatient)
+
+		protected.GET("/patient/hn/:hn", middleware.RequirePermission(models.PermPatientRead), controller.GetPatientByHN)
+
+		protected.GET("/patient/:id", middleware.RequirePermission(models.PermPatientRead), controller.ShowPatient)
+		protected.POST("/patient", middleware.RequirePermission(models.PermPatientWrite), controller.CreatePatient)
+		protected.PUT("/patient/:id", middleware.RequirePermission(models.PermPatientWrite), controller.UpdatePatient)
+		protected.PATCH("/patient/:id", middleware.RequirePermission(models.PermPatientWrite), controller.PatchPatient)
+		protected.DELETE("/patient/:id", middleware.RequirePermission(models.PermPatientWrite), controller.DeletePatient)
+		protected.POST("/patient/:id/restore", middleware.RequirePermission(models.PermPatientWrite), controller.RestorePatient)
+		protected.POST("/patient/:id/reveal", middleware.RequirePermission(models.PermPatientReveal), controller.RevealPatient)
+
+		protected.GET("/patient/:id/revisions", middleware.RequirePermission(models.PermPatientRead), controller.ListPatientRevisions)
+		protected.GET("/patient/:id/revisions/diff", middleware.RequirePermission(models.PermPatientRead), controller.DiffPatientRevisions)
+		protected.GET("/patient/:id/as-of", middleware.RequirePermission(models.PermPatientRead), controller.GetPatientAsOf)
 	}
 }
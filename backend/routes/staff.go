@@ -2,11 +2,45 @@ package routes
 
 import (
 	"github.com/Natthaphatpiw/Backend-with-GO-GIN/controller"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/middleware"
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
 	"github.com/gin-gonic/gin"
 )
 
 func StaffRoutes(router *gin.Engine) {
-	router.POST("/staff/create", controller.CreateStaff)
+	router.POST("/staff/bootstrap", controller.BootstrapStaff)
 
 	router.POST("/staff/login", controller.LoginStaff)
+	router.POST("/staff/login/mfa", controller.LoginMFA)
+	router.POST("/staff/token/refresh", controller.RefreshToken)
+	router.POST("/staff/password/reset", controller.ResetPassword)
+
+	protected := router.Group("/")
+	protected.Use(middleware.AuthRequired())
+	{
+		protected.POST("/staff/create", middleware.RequirePermission(models.PermStaffManage), controller.CreateStaff)
+		protected.GET("/roles", middleware.RequirePermission(models.PermStaffRead), controller.ListRoles)
+		protected.PUT("/staff/:id/roles", middleware.RequirePermission(models.PermStaffManage), controller.AssignStaffRoles)
+		protected.DELETE("/staff/:id/sessions", middleware.RequirePermission(models.PermStaffManage), controller.RevokeStaffSessions)
+		protected.POST("/staff/:id/unlock", middleware.RequirePermission(models.PermStaffManage), controller.UnlockStaff)
+		protected.POST("/staff/:id/password/reset", middleware.RequirePermission(models.PermStaffManage), controller.IssuePasswordReset)
+		protected.DELETE("/staff/:id/mfa", middleware.RequirePermission(models.PermStaffManage), controller.ResetStaffMFA)
+		protected.PUT("/hospital/mfa", middleware.RequirePermission(models.PermHospitalManage), controller.SetHospitalMFA)
+		protected.GET("/hospital/hn-format", middleware.RequirePermission(models.PermHospitalManage), controller.GetHospitalHNFormat)
+		protected.PUT("/hospital/hn-format", middleware.RequirePermission(models.PermHospitalManage), controller.SetHospitalHNFormat)
+		protected.GET("/hospital/identity-providers", middleware.RequirePermission(models.PermHospitalManage), controller.ListIdentityProviders)
+		protected.POST("/hospital/identity-providers", middleware.RequirePermission(models.PermHospitalManage), controller.CreateIdentityProvider)
+		protected.DELETE("/hospital/identity-providers/:id", middleware.RequirePermission(models.PermHospitalManage), controller.DeleteIdentityProvider)
+		protected.GET("/hospital/service-identities", middleware.RequirePermission(models.PermHospitalManage), controller.ListServiceIdentities)
+		protected.POST("/hospital/service-identities", middleware.RequirePermission(models.PermHospitalManage), controller.CreateServiceIdentity)
+		protected.DELETE("/hospital/service-identities/:id", middleware.RequirePermission(models.PermHospitalManage), controller.DeleteServiceIdentity)
+
+		protected.POST("/staff/logout", controller.LogoutStaff)
+		protected.POST("/staff/password", controller.ChangePassword)
+		protected.POST("/staff/mfa/enroll", controller.EnrollMFA)
+		protected.POST("/staff/mfa/verify", controller.VerifyMFA)
+		protected.DELETE("/staff/mfa", controller.DisableMFA)
+		protected.GET("/staff/sessions", controller.ListSessions)
+		protected.DELETE("/staff/sessions/:id", controller.RevokeSession)
+	}
 }
@@ -0,0 +1,144 @@
+// Package tenant keeps the data of one hospital invisible to the others.
+//
+// Models implementing Scoped are filtered by hospital_id on every query
+// (including Row, Rows and Scan), update and delete made through a *gorm.DB whose context carries a
+// hospital (see WithHospital). Statements without a hospital fail with
+// ErrMissingHospital instead of silently reading every hospital's rows, so a
+// handler that forgets the context cannot leak data. Code that legitimately
+// works across hospitals, such as seeding or migrations, opts out with
+// AllHospitals.
+//
+// Raw SQL (db.Raw, db.Exec) is not filtered, nor are tables named only
+// with db.Table, whose rows GORM cannot tell apart from unscoped ones.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Column is the column every scoped table keeps its hospital in.
+const Column = "hospital_id"
+
+var (
+	ErrMissingHospital = errors.New("tenant: no hospital in context")
+	ErrWrongHospital   = errors.New("tenant: row belongs to another hospital")
+)
+
+// Scoped marks models whose rows belong to one hospital.
+type Scoped interface {
+	HospitalScoped()
+}
+
+type hospitalKey struct{}
+type allHospitalsKey struct{}
+
+// WithHospital returns a context that scopes statements to one hospital.
+func WithHospital(ctx context.Context, hospitalID uint) context.Context {
+	return context.WithValue(ctx, hospitalKey{}, hospitalID)
+}
+
+// HospitalID returns the hospital a context is scoped to.
+func HospitalID(ctx context.Context) (uint, bool) {
+	hospitalID, ok := ctx.Value(hospitalKey{}).(uint)
+	return hospitalID, ok
+}
+
+// AllHospitals lifts the hospital filter from db. Use it only where reading
+// or writing across hospitals is the point.
+func AllHospitals(db *gorm.DB) *gorm.DB {
+	return db.WithContext(context.WithValue(db.Statement.Context, allHospitalsKey{}, true))
+}
+
+// Register installs the callbacks that enforce the scoping on db.
+func Register(db *gorm.DB) error {
+	if err := db.Callback().Query().Before("gorm:query").Register("tenant:query", scopeStatement); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("tenant:row", scopeStatement); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("tenant:update", scopeStatement); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:delete").Register("tenant:delete", scopeStatement); err != nil {
+		return err
+	}
+	return db.Callback().Create().Before("gorm:create").Register("tenant:create", checkCreate)
+}
+
+func isScoped(s *schema.Schema) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := reflect.New(s.ModelType).Interface().(Scoped)
+	return ok
+}
+
+func allHospitals(ctx context.Context) bool {
+	bypass, _ := ctx.Value(allHospitalsKey{}).(bool)
+	return bypass
+}
+
+// scopeStatement adds hospital_id = <context hospital> to the statement.
+func scopeStatement(db *gorm.DB) {
+	if db.Error != nil || !isScoped(db.Statement.Schema) || allHospitals(db.Statement.Context) {
+		return
+	}
+
+	hospitalID, ok := HospitalID(db.Statement.Context)
+	if !ok {
+		db.AddError(ErrMissingHospital)
+		return
+	}
+
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: Column}, Value: hospitalID},
+	}})
+}
+
+// checkCreate fills in the context hospital on new rows and refuses rows of
+// another hospital. Without a hospital in the context rows are created as
+// given, since nothing can leak from an insert.
+func checkCreate(db *gorm.DB) {
+	if db.Error != nil || !isScoped(db.Statement.Schema) || allHospitals(db.Statement.Context) {
+		return
+	}
+
+	hospitalID, ok := HospitalID(db.Statement.Context)
+	if !ok {
+		return
+	}
+
+	field := db.Statement.Schema.LookUpField(Column)
+	if field == nil {
+		return
+	}
+
+	setHospital := func(value reflect.Value) {
+		current, isZero := field.ValueOf(db.Statement.Context, value)
+		if isZero {
+			if err := field.Set(db.Statement.Context, value, hospitalID); err != nil {
+				db.AddError(err)
+			}
+			return
+		}
+		if current != hospitalID {
+			db.AddError(ErrWrongHospital)
+		}
+	}
+
+	switch db.Statement.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+			setHospital(reflect.Indirect(db.Statement.ReflectValue.Index(i)))
+		}
+	case reflect.Struct:
+		setHospital(db.Statement.ReflectValue)
+	}
+}
@@ -0,0 +1,118 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type record struct {
+	ID         uint
+	HospitalID uint
+	Name       string
+}
+
+func (record) HospitalScoped() {}
+
+type shared struct {
+	ID   uint
+	Name string
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	db.AutoMigrate(&record{}, &shared{})
+	if err := Register(db); err != nil {
+		t.Fatalf("Failed to register callbacks: %v", err)
+	}
+
+	AllHospitals(db).Create(&[]record{
+		{HospitalID: 1, Name: "a"},
+		{HospitalID: 1, Name: "b"},
+		{HospitalID: 2, Name: "c"},
+	})
+	db.Create(&shared{Name: "shared"})
+	return db
+}
+
+func TestQueriesAreScoped(t *testing.T) {
+	db := setupTestDB(t)
+	hospital1 := db.WithContext(WithHospital(context.Background(), 1))
+
+	var records []record
+	assert.NoError(t, hospital1.Find(&records).Error)
+	assert.Len(t, records, 2)
+
+	var other record
+	err := hospital1.Where("name = ? OR name = ?", "c", "c").First(&other).Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	var count int64
+	hospital1.Model(&record{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	// Row, Rows and Scan go through their own callbacks
+	var names []string
+	assert.NoError(t, hospital1.Model(&record{}).Select("name").Order("name").Scan(&names).Error)
+	assert.Equal(t, []string{"a", "b"}, names)
+
+	var name string
+	err = hospital1.Model(&record{}).Select("name").Where("name = ?", "c").Row().Scan(&name)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	rows, err := hospital1.Model(&record{}).Select("name").Rows()
+	assert.NoError(t, err)
+	rowCount := 0
+	for rows.Next() {
+		rowCount++
+	}
+	rows.Close()
+	assert.Equal(t, 2, rowCount)
+
+	// Unscoped models are untouched
+	var sharedRows []shared
+	assert.NoError(t, db.Find(&sharedRows).Error)
+	assert.Len(t, sharedRows, 1)
+}
+
+func TestMissingHospitalFails(t *testing.T) {
+	db := setupTestDB(t)
+
+	var records []record
+	assert.ErrorIs(t, db.Find(&records).Error, ErrMissingHospital)
+	assert.ErrorIs(t, db.Model(&record{}).Where("name = ?", "a").Update("name", "x").Error, ErrMissingHospital)
+	assert.ErrorIs(t, db.Where("name = ?", "a").Delete(&record{}).Error, ErrMissingHospital)
+	var names []string
+	assert.ErrorIs(t, db.Model(&record{}).Select("name").Scan(&names).Error, ErrMissingHospital)
+	_, err := db.Model(&record{}).Rows()
+	assert.ErrorIs(t, err, ErrMissingHospital)
+
+	assert.NoError(t, AllHospitals(db).Find(&records).Error)
+	assert.Len(t, records, 3)
+}
+
+func TestWritesAreScoped(t *testing.T) {
+	db := setupTestDB(t)
+	hospital1 := db.WithContext(WithHospital(context.Background(), 1))
+
+	result := hospital1.Model(&record{}).Where("name = ?", "c").Update("name", "x")
+	assert.NoError(t, result.Error)
+	assert.Equal(t, int64(0), result.RowsAffected)
+
+	result = hospital1.Where("name IN ?", []string{"a", "c"}).Delete(&record{})
+	assert.NoError(t, result.Error)
+	assert.Equal(t, int64(1), result.RowsAffected)
+
+	created := record{Name: "d"}
+	assert.NoError(t, hospital1.Create(&created).Error)
+	assert.Equal(t, uint(1), created.HospitalID)
+
+	assert.ErrorIs(t, hospital1.Create(&record{HospitalID: 2, Name: "e"}).Error, ErrWrongHospital)
+}
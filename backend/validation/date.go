@@ -0,0 +1,52 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// BuddhistEraOffset is the difference between Buddhist Era (พ.ศ.) and
+// Gregorian years.
+const BuddhistEraOffset = 543
+
+var ErrInvalidDate = errors.New("invalid date")
+
+// GregorianYear converts a Buddhist Era year to the Gregorian calendar.
+// Years from 2400 on are taken to be Buddhist Era, since no patient was born
+// in 2400 CE and 2400 BE is 1857 CE. Other years are returned unchanged.
+func GregorianYear(year int) int {
+	if year >= 2400 {
+		return year - BuddhistEraOffset
+	}
+	return year
+}
+
+// DateOnly keeps the calendar date of t, as written in t's own time zone, at
+// midnight UTC. Dates of birth are stored this way so that they compare
+// equal however the client's offset was written.
+func DateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// ParseDate reads a date written as 2006-01-02 or as an RFC 3339 timestamp,
+// whose calendar date counts. Buddhist Era years such as 2533-01-01 are
+// converted to the Gregorian calendar first, so that 29 February is only
+// accepted if the Gregorian year is a leap year.
+func ParseDate(value string) (time.Time, error) {
+	if len(value) >= 4 {
+		if year, err := strconv.Atoi(value[:4]); err == nil && GregorianYear(year) != year {
+			value = fmt.Sprintf("%04d", GregorianYear(year)) + value[4:]
+		}
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, ErrInvalidDate
+		}
+	}
+	return DateOnly(t), nil
+}
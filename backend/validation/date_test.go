@@ -0,0 +1,38 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDate(t *testing.T) {
+	want := time.Date(1990, 1, 31, 0, 0, 0, 0, time.UTC)
+	for _, input := range []string{
+		"1990-01-31",
+		"2533-01-31",
+		"1990-01-31T00:00:00+07:00",
+		"1990-01-31T23:30:00-05:00",
+	} {
+		date, err := ParseDate(input)
+		assert.NoError(t, err, input)
+		assert.True(t, want.Equal(date), input)
+	}
+
+	// 2535 BE is 1992, a leap year; 2534 BE is 1991, which is not
+	date, err := ParseDate("2535-02-29")
+	assert.NoError(t, err)
+	assert.True(t, time.Date(1992, 2, 29, 0, 0, 0, 0, time.UTC).Equal(date))
+
+	for _, input := range []string{"2534-02-29", "1990-02-30", "31/01/1990", "1990-1-31", ""} {
+		_, err := ParseDate(input)
+		assert.ErrorIs(t, err, ErrInvalidDate, input)
+	}
+}
+
+func TestGregorianYear(t *testing.T) {
+	assert.Equal(t, 1990, GregorianYear(2533))
+	assert.Equal(t, 1990, GregorianYear(1990))
+	assert.Equal(t, 2024, GregorianYear(2567))
+}
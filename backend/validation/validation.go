@@ -0,0 +1,159 @@
+// Package validation checks the identifiers stored for patients:
+// Thai national IDs, passport numbers, Thai phone numbers and genders. The
+// checks are also available as validator tags, see Register.
+package validation
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Natthaphatpiw/Backend-with-GO-GIN/models"
+	"github.com/go-playground/validator/v10"
+)
+
+// Register adds the tags thai_national_id, passport, phone_th and gender to
+// v. passport takes the name of the sibling field holding the ISO 3166-1
+// alpha-3 issuing country, e.g. passport=PassportCountry. The passport and
+// phone_th tags accept numbers before normalization; callers store the
+// normalized forms.
+func Register(v *validator.Validate) error {
+	validations := map[string]validator.Func{
+		"thai_national_id": func(fl validator.FieldLevel) bool {
+			return ThaiNationalID(fl.Field().String())
+		},
+		"passport": func(fl validator.FieldLevel) bool {
+			country := ""
+			if fl.Param() != "" {
+				if field := fl.Parent().FieldByName(fl.Param()); field.IsValid() {
+					country = field.String()
+				}
+			}
+			return Passport(NormalizePassport(fl.Field().String()), country)
+		},
+		"phone_th": func(fl validator.FieldLevel) bool {
+			_, ok := NormalizeThaiPhone(fl.Field().String())
+			return ok
+		},
+		"gender": func(fl validator.FieldLevel) bool {
+			return Gender(fl.Field().String())
+		},
+	}
+
+	for tag, fn := range validations {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ThaiNationalID checks the 13 digits and the mod-11 check digit of a Thai
+// national ID: the first 12 digits are weighted 13 down to 2, and the last
+// digit is (11 - sum mod 11) mod 10.
+func ThaiNationalID(id string) bool {
+	if len(id) != 13 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 13; i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+		if i < 12 {
+			sum += int(id[i]-'0') * (13 - i)
+		}
+	}
+	return (11-sum%11)%10 == int(id[12]-'0')
+}
+
+// passportFormats are the passport number formats of countries whose
+// passports we see often. Other countries get genericPassport.
+var passportFormats = map[string]*regexp.Regexp{
+	"THA": regexp.MustCompile(`^[A-Z]{1,2}[0-9]{6,7}$`),
+	"USA": regexp.MustCompile(`^[A-Z0-9][0-9]{8}$`),
+	"GBR": regexp.MustCompile(`^[0-9]{9}$`),
+	"CHN": regexp.MustCompile(`^[EGDSP][A-Z0-9][0-9]{7}$`),
+	"JPN": regexp.MustCompile(`^[A-Z]{2}[0-9]{7}$`),
+	"MMR": regexp.MustCompile(`^[A-Z]{1,2}[0-9]{6,7}$`),
+	"LAO": regexp.MustCompile(`^[A-Z]{1,2}[0-9]{6,7}$`),
+	"KHM": regexp.MustCompile(`^[A-Z]{1,2}[0-9]{6,7}$`),
+}
+
+// genericPassport is the ICAO 9303 limit for document numbers in the
+// machine readable zone, relaxed for older documents.
+var genericPassport = regexp.MustCompile(`^[A-Z0-9]{5,20}$`)
+
+// Passport checks a passport number against the format of its issuing
+// country, an ISO 3166-1 alpha-3 code. Unknown or empty countries only get
+// the generic check. The number must already be normalized, see
+// NormalizePassport.
+func Passport(number string, country string) bool {
+	if format, ok := passportFormats[country]; ok {
+		return format.MatchString(number)
+	}
+	return genericPassport.MatchString(number)
+}
+
+// NormalizePassport removes spaces and dashes and upper-cases a passport
+// number.
+func NormalizePassport(number string) string {
+	number = strings.NewReplacer(" ", "", "-", "").Replace(number)
+	return strings.ToUpper(number)
+}
+
+// NormalizeEmail trims and lower-cases an email address, so that addresses
+// typed in different case compare equal.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeThaiPhone converts a Thai phone number written in national
+// (089-123-4567) or international (+66 89 123 4567) form to E.164
+// (+66891234567). Mobile numbers have 9 digits after the 0 trunk prefix,
+// landlines 8.
+func NormalizeThaiPhone(phone string) (string, bool) {
+	var digits strings.Builder
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", false
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case strings.HasPrefix(phone, "+66"):
+		number = strings.TrimPrefix(number, "66")
+	case strings.HasPrefix(phone, "+"):
+		return "", false
+	case strings.HasPrefix(number, "0"):
+		number = number[1:]
+	default:
+		return "", false
+	}
+
+	if len(number) < 8 || len(number) > 9 || number[0] < '2' {
+		return "", false
+	}
+	// Mobile numbers (6, 8 and 9) have 9 digits, landlines 8.
+	mobile := number[0] == '6' || number[0] == '8' || number[0] == '9'
+	if mobile != (len(number) == 9) {
+		return "", false
+	}
+	return "+66" + number, true
+}
+
+// Gender checks a value against the models.Gender values.
+func Gender(value string) bool {
+	for _, gender := range models.Genders {
+		if value == gender {
+			return true
+		}
+	}
+	return false
+}
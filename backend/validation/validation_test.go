@@ -0,0 +1,103 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThaiNationalID(t *testing.T) {
+	assert.True(t, ThaiNationalID("1101700203514"))
+	assert.True(t, ThaiNationalID("1234567890121"))
+
+	// Wrong check digit
+	assert.False(t, ThaiNationalID("1101700203515"))
+	assert.False(t, ThaiNationalID("1234567890123"))
+	// Wrong length or not digits
+	assert.False(t, ThaiNationalID("110170020351"))
+	assert.False(t, ThaiNationalID("11017002035140"))
+	assert.False(t, ThaiNationalID("1-1017-00203-51-4"))
+	assert.False(t, ThaiNationalID(""))
+}
+
+func TestPassport(t *testing.T) {
+	assert.True(t, Passport("AA1234567", "THA"))
+	assert.True(t, Passport("123456789", "GBR"))
+	assert.False(t, Passport("123456789", "THA"))
+	assert.False(t, Passport("AB123456", "GBR"))
+
+	// Countries without a known format get the generic check
+	assert.True(t, Passport("X1234567", "DEU"))
+	assert.True(t, Passport("X1234567", ""))
+	assert.False(t, Passport("X12", "DEU"))
+	assert.False(t, Passport("x1234567", "DEU"))
+
+	assert.Equal(t, "AA1234567", NormalizePassport("aa 123-4567"))
+}
+
+func TestNormalizeThaiPhone(t *testing.T) {
+	valid := map[string]string{
+		"0891234567":      "+66891234567",
+		"089-123-4567":    "+66891234567",
+		"+66 89 123 4567": "+66891234567",
+		"+66891234567":    "+66891234567",
+		"02 123 4567":     "+6621234567",
+		"(053) 123-456":   "+6653123456",
+		"061.234.5678":    "+66612345678",
+	}
+	for input, want := range valid {
+		phone, ok := NormalizeThaiPhone(input)
+		assert.True(t, ok, input)
+		assert.Equal(t, want, phone, input)
+	}
+
+	for _, input := range []string{
+		"",
+		"891234567",       // no trunk prefix
+		"089123456",       // mobile number one digit short
+		"021234567 8",     // landline one digit long
+		"+1 415 555 0100", // not Thai
+		"0012345678",
+		"+66 (0)2 1234567", // trunk prefix after the country code
+		"089-123-456x",
+	} {
+		_, ok := NormalizeThaiPhone(input)
+		assert.False(t, ok, input)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	v := validator.New()
+	assert.NoError(t, Register(v))
+
+	type request struct {
+		NationalID      string `validate:"omitempty,thai_national_id"`
+		PassportID      string `validate:"omitempty,passport=PassportCountry"`
+		PassportCountry string
+		PhoneNumber     string `validate:"omitempty,phone_th"`
+		Gender          string `validate:"omitempty,gender"`
+	}
+
+	assert.NoError(t, v.Struct(request{
+		NationalID:      "1101700203514",
+		PassportID:      "aa1234567",
+		PassportCountry: "THA",
+		PhoneNumber:     "089-123-4567",
+		Gender:          "F",
+	}))
+	assert.NoError(t, v.Struct(request{}))
+
+	err := v.Struct(request{
+		NationalID:      "1101700203515",
+		PassportID:      "123456789",
+		PassportCountry: "THA",
+		PhoneNumber:     "12345",
+		Gender:          "X",
+	})
+	var tags []string
+	for _, fieldErr := range err.(validator.ValidationErrors) {
+		tags = append(tags, fieldErr.Tag())
+	}
+	assert.ElementsMatch(t, []string{"thai_national_id", "passport", "phone_th", "gender"}, tags)
+}
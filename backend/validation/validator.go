@@ -0,0 +1,112 @@
+package validation
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// StructValidator is gin's struct validator extended to the validate tags.
+// Request models declare rules in either binding or validate tags; gin's
+// default validator only runs the former. Both sets of rules are checked
+// with the rules of this package registered, and fields are named by their
+// JSON names.
+type StructValidator struct {
+	engines []*validator.Validate
+}
+
+var _ binding.StructValidator = (*StructValidator)(nil)
+
+// NewStructValidator returns a validator for the binding and validate tags.
+func NewStructValidator() (*StructValidator, error) {
+	v := &StructValidator{}
+	for _, tag := range []string{"binding", "validate"} {
+		engine := validator.New()
+		engine.SetTagName(tag)
+		engine.RegisterTagNameFunc(JSONFieldName)
+		if err := Register(engine); err != nil {
+			return nil, err
+		}
+		v.engines = append(v.engines, engine)
+	}
+	return v, nil
+}
+
+// ValidateStruct checks a struct, a pointer to one or each element of a
+// slice, like gin's default validator. A field rejected by its binding tag
+// is not reported again for its validate tag.
+func (v *StructValidator) ValidateStruct(obj any) error {
+	if obj == nil {
+		return nil
+	}
+
+	value := reflect.ValueOf(obj)
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.Elem().Kind() != reflect.Struct {
+			return v.ValidateStruct(value.Elem().Interface())
+		}
+		return v.validateStruct(obj)
+	case reflect.Struct:
+		return v.validateStruct(obj)
+	case reflect.Slice, reflect.Array:
+		var sliceErrors binding.SliceValidationError
+		for i := 0; i < value.Len(); i++ {
+			if err := v.ValidateStruct(value.Index(i).Interface()); err != nil {
+				sliceErrors = append(sliceErrors, err)
+			}
+		}
+		if len(sliceErrors) == 0 {
+			return nil
+		}
+		return sliceErrors
+	}
+	return nil
+}
+
+func (v *StructValidator) validateStruct(obj any) error {
+	var validationErrors validator.ValidationErrors
+	rejected := map[string]bool{}
+	for _, engine := range v.engines {
+		err := engine.Struct(obj)
+		if err == nil {
+			continue
+		}
+		engineErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return err
+		}
+		for _, fieldErr := range engineErrors {
+			if rejected[fieldErr.Namespace()] {
+				continue
+			}
+			rejected[fieldErr.Namespace()] = true
+			validationErrors = append(validationErrors, fieldErr)
+		}
+	}
+
+	if len(validationErrors) == 0 {
+		return nil
+	}
+	return validationErrors
+}
+
+// Engine returns the engine of the binding tags, where gin expects custom
+// rules to be registered.
+func (v *StructValidator) Engine() any {
+	return v.engines[0]
+}
+
+// JSONFieldName names a field by its JSON name, as clients know it.
+func JSONFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
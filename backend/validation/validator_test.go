@@ -0,0 +1,40 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStructValidator(t *testing.T) {
+	type request struct {
+		Name       string `json:"name" binding:"required" validate:"omitempty,min=2"`
+		NationalID string `json:"national_id" validate:"omitempty,thai_national_id"`
+		Email      string `json:"email" validate:"omitempty,email"`
+	}
+
+	v, err := NewStructValidator()
+	assert.NoError(t, err)
+
+	assert.NoError(t, v.ValidateStruct(&request{Name: "Somchai", NationalID: "1234567890121"}))
+
+	// The validate tags run too, and fields are named as in JSON
+	err = v.ValidateStruct(&request{Name: "S", NationalID: "1234567890123", Email: "not-an-email"})
+	var validationErrors validator.ValidationErrors
+	assert.ErrorAs(t, err, &validationErrors)
+	var rejected []string
+	for _, fieldErr := range validationErrors {
+		rejected = append(rejected, fieldErr.Field()+":"+fieldErr.Tag())
+	}
+	assert.Equal(t, []string{"name:min", "national_id:thai_national_id", "email:email"}, rejected)
+
+	// A field rejected by its binding tag is reported once
+	err = v.ValidateStruct(request{})
+	assert.ErrorAs(t, err, &validationErrors)
+	assert.Equal(t, 1, len(validationErrors))
+	assert.Equal(t, "required", validationErrors[0].Tag())
+
+	// Slices are validated element by element
+	assert.Error(t, v.ValidateStruct([]request{{Name: "Somchai"}, {}}))
+}